@@ -0,0 +1,354 @@
+package burstbooth
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang/glog"
+)
+
+// postStateHidden is the moderation state, the X attribute, of a post an
+// admin has hidden.
+const postStateHidden = "hidden"
+
+// auditPartition is the I attribute of every audit entry, which puts the
+// whole log in one partition ordered by time.
+const auditPartition = "audit"
+
+type AuditDDB struct {
+	I  struct{ S string } // always auditPartition
+	K  struct{ B []byte } // time of the action, see postKey
+	A  struct{ S string } // name of the admin
+	Op struct{ S string } // the action, the name of its endpoint
+
+	// Optional Attributes
+	P *struct{ B []byte } `json:",omitempty"` // post ID the action was on
+	D *struct{ B []byte } `json:",omitempty"` // device ID the action was on
+	M *struct{ S string } `json:",omitempty"` // details, like a new caption or a ban reason
+}
+
+// adminToken is a bearer token of the admin API.
+type adminToken struct {
+	name  string // who the token belongs to, as recorded in the audit log
+	token string
+}
+
+var defaultAdminTokens = adminTokensFromEnv()
+
+// adminTokensFromEnv reads the admin tokens from ADMIN_TOKENS, a comma
+// separated list of name:token pairs. The admin API is disabled when it is
+// unset.
+func adminTokensFromEnv() []adminToken {
+	var tokens []adminToken
+	for _, pair := range strings.Split(os.Getenv("ADMIN_TOKENS"), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		i := strings.Index(pair, ":")
+		if i <= 0 || i == len(pair)-1 {
+			glog.Fatalf("bad ADMIN_TOKENS entry %q, want name:token", pair)
+		}
+		tokens = append(tokens, adminToken{name: strings.TrimSpace(pair[:i]), token: pair[i+1:]})
+	}
+	return tokens
+}
+
+// adminFunc is an admin API handler. admin is the name of the caller.
+type adminFunc func(w http.ResponseWriter, r *http.Request, admin string) *appError
+
+// adminAPI wraps an admin API handler with bearer token authentication.
+func (s *Server) adminAPI(fn adminFunc) func(w http.ResponseWriter, r *http.Request) *appError {
+	return func(w http.ResponseWriter, r *http.Request) *appError {
+		if len(s.admins) == 0 {
+			return &appError{Message: "the admin API is disabled", Code: http.StatusForbidden}
+		}
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return &appError{Message: "no bearer token", Code: http.StatusUnauthorized}
+		}
+		token := []byte(strings.TrimPrefix(auth, "Bearer "))
+		admin := ""
+		// Compare with every token, so the time taken does not tell which
+		// one matched.
+		for _, t := range s.admins {
+			if subtle.ConstantTimeCompare(token, []byte(t.token)) == 1 {
+				admin = t.name
+			}
+		}
+		if admin == "" {
+			return &appError{Message: "bad bearer token", Code: http.StatusUnauthorized}
+		}
+		return fn(w, r, admin)
+	}
+}
+
+// audit records an admin action in the audit log. postPK and deviceID are
+// what the action was on, nil if not applicable. The action has already
+// happened, so failing to record it is logged rather than returned.
+func (s *Server) audit(admin, op string, postPK, deviceID []byte, detail string) {
+	key, err := postKey(time.Now())
+	if err != nil {
+		glog.Errorf("audit %s %s: %v", admin, op, err)
+		return
+	}
+	a := AuditDDB{}
+	a.I.S = auditPartition
+	a.K.B = key
+	a.A.S = admin
+	a.Op.S = op
+	if postPK != nil {
+		a.P = &struct{ B []byte }{B: postPK}
+	}
+	if deviceID != nil {
+		a.D = &struct{ B []byte }{B: deviceID}
+	}
+	if detail != "" {
+		a.M = &struct{ S string }{S: detail}
+	}
+	glog.Infof("audit: %s %s post %q device %q: %s", admin, op, postPK, deviceID, detail)
+	if err := s.store.AddAudit(a); err != nil {
+		glog.Errorf("audit %s %s: %v", admin, op, err)
+	}
+}
+
+// formPost returns the post a request is about, identified by its type and
+// key like in Vote.
+func (s *Server) formPost(r *http.Request) (*PostDDB, *appError) {
+	postType, appErr := formPostType(r)
+	if appErr != nil {
+		return nil, appErr
+	}
+	key, err := base64.StdEncoding.DecodeString(r.FormValue("key"))
+	if err != nil {
+		return nil, &appError{Message: err.Error(), Code: http.StatusBadRequest}
+	}
+	post, err := s.store.GetPost(postType, key)
+	if err != nil {
+		glog.Errorf("%v", err)
+		return nil, &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+	}
+	if post == nil {
+		return nil, &appError{Message: ErrNoPost.Error(), Code: http.StatusNotFound}
+	}
+	return post, nil
+}
+
+// writeAdminPost responds with a post after an admin changed it.
+func (s *Server) writeAdminPost(w http.ResponseWriter, postType string, key []byte) *appError {
+	post, err := s.store.GetPost(postType, key)
+	if err != nil {
+		glog.Errorf("%v", err)
+		return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+	}
+	if post == nil {
+		return &appError{Message: ErrNoPost.Error(), Code: http.StatusNotFound}
+	}
+	json.NewEncoder(w).Encode(post)
+	return nil
+}
+
+// DeletePost deletes a post, identified like in Vote, with its votes,
+// reactions, reports, comments, tags and words, and its uploaded image and
+// thumbnails. It responds with the deleted post.
+//   curl -H 'Authorization: Bearer t0k3n' 'http://localhost:8080/admin/DeletePost?key=E7MySUSwyFQ%3D'
+func (s *Server) DeletePost(w http.ResponseWriter, r *http.Request, admin string) *appError {
+	post, appErr := s.formPost(r)
+	if appErr != nil {
+		return appErr
+	}
+	if appErr := voteError(s.store.DeletePost(post.I.S, post.K.B)); appErr != nil {
+		return appErr
+	}
+	s.deletePostBlobs(post.I.S, post.K.B)
+	s.audit(admin, "DeletePost", postPK(post.I.S, post.K.B), nil, post.URL.S)
+	json.NewEncoder(w).Encode(post)
+	return nil
+}
+
+// EditCaption replaces the caption of a post, identified like in Vote, with
+// caption, or removes it if caption is empty, and retags and reindexes the
+// post. The caption is normalized but not checked against the word lists.
+//   curl -H 'Authorization: Bearer t0k3n' 'http://localhost:8080/admin/EditCaption?key=E7MySUSwyFQ%3D&caption=hi'
+func (s *Server) EditCaption(w http.ResponseWriter, r *http.Request, admin string) *appError {
+	post, appErr := s.formPost(r)
+	if appErr != nil {
+		return appErr
+	}
+	caption := normalizeCaption(r.FormValue("caption"))
+	if utf8.RuneCountInString(caption) > s.captions.maxLen {
+		return &appError{Message: errCaptionTooLong.Error(), Code: http.StatusBadRequest}
+	}
+	if appErr := voteError(s.store.SetCaption(post.I.S, post.K.B, caption)); appErr != nil {
+		return appErr
+	}
+	old := ""
+	if post.C != nil {
+		old = post.C.S
+	}
+	s.indexCaption(postPK(post.I.S, post.K.B), old, caption)
+	s.tagPost(post, caption)
+	s.audit(admin, "EditCaption", postPK(post.I.S, post.K.B), nil, caption)
+	return s.writeAdminPost(w, post.I.S, post.K.B)
+}
+
+// Hide takes a post, identified like in Vote, out of the feeds.
+//   curl -H 'Authorization: Bearer t0k3n' 'http://localhost:8080/admin/Hide?key=E7MySUSwyFQ%3D'
+func (s *Server) Hide(w http.ResponseWriter, r *http.Request, admin string) *appError {
+	return s.setState(w, r, admin, "Hide", postStateHidden)
+}
+
+// Unhide puts a post, identified like in Vote, back in the feeds, whether it
+// was hidden, flagged or held. It clears the report count of the post.
+//   curl -H 'Authorization: Bearer t0k3n' 'http://localhost:8080/admin/Unhide?key=E7MySUSwyFQ%3D'
+func (s *Server) Unhide(w http.ResponseWriter, r *http.Request, admin string) *appError {
+	return s.setState(w, r, admin, "Unhide", "")
+}
+
+func (s *Server) setState(w http.ResponseWriter, r *http.Request, admin, op, state string) *appError {
+	post, appErr := s.formPost(r)
+	if appErr != nil {
+		return appErr
+	}
+	if appErr := voteError(s.store.SetState(post.I.S, post.K.B, state)); appErr != nil {
+		return appErr
+	}
+	old := ""
+	if post.X != nil {
+		old = "was " + post.X.S
+	}
+	s.audit(admin, op, postPK(post.I.S, post.K.B), nil, old)
+	return s.writeAdminPost(w, post.I.S, post.K.B)
+}
+
+// ResetScore deletes the votes of a post, identified like in Vote, and sets
+// its score to 0.
+//   curl -H 'Authorization: Bearer t0k3n' 'http://localhost:8080/admin/ResetScore?key=E7MySUSwyFQ%3D'
+func (s *Server) ResetScore(w http.ResponseWriter, r *http.Request, admin string) *appError {
+	post, appErr := s.formPost(r)
+	if appErr != nil {
+		return appErr
+	}
+	hot := s.hot.scoreN(0, post.K.B)
+	if appErr := voteError(s.store.ResetScore(post.I.S, post.K.B, hot)); appErr != nil {
+		return appErr
+	}
+	s.audit(admin, "ResetScore", postPK(post.I.S, post.K.B), nil, "was "+post.S.N)
+	post.S.N = "0"
+	s.updateTagScores(post)
+	return s.writeAdminPost(w, post.I.S, post.K.B)
+}
+
+// Ban bans a device_id from posting, voting and reporting, for reason.
+//   curl -H 'Authorization: Bearer t0k3n' 'http://localhost:8080/admin/Ban?device_id=ddd&reason=spam'
+func (s *Server) Ban(w http.ResponseWriter, r *http.Request, admin string) *appError {
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if reason == "" {
+		return &appError{Message: "no reason", Code: http.StatusBadRequest}
+	}
+	return s.setBan(w, r, admin, "Ban", reason)
+}
+
+// Unban lifts the ban of a device_id.
+//   curl -H 'Authorization: Bearer t0k3n' 'http://localhost:8080/admin/Unban?device_id=ddd'
+func (s *Server) Unban(w http.ResponseWriter, r *http.Request, admin string) *appError {
+	return s.setBan(w, r, admin, "Unban", "")
+}
+
+func (s *Server) setBan(w http.ResponseWriter, r *http.Request, admin, op, reason string) *appError {
+	deviceID := r.FormValue("device_id")
+	if deviceID == "" {
+		return &appError{Message: "no device_id", Code: http.StatusBadRequest}
+	}
+	if err := s.store.SetBan([]byte(deviceID), reason); err != nil {
+		glog.Errorf("%v", err)
+		return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+	}
+	s.audit(admin, op, nil, []byte(deviceID), reason)
+	d, err := s.store.GetDevice([]byte(deviceID))
+	if err != nil {
+		glog.Errorf("%v", err)
+		return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+	}
+	json.NewEncoder(w).Encode(d)
+	return nil
+}
+
+// AuditJSON is an entry of the audit log as returned by Audit.
+type AuditJSON struct {
+	Time   time.Time
+	Admin  string
+	Action string
+	Type   string `json:",omitempty"` // type of the post the action was on
+	Key    []byte `json:",omitempty"` // key of the post the action was on
+	Device string `json:",omitempty"`
+	Detail string `json:",omitempty"`
+}
+
+// AuditLogJSON is a page of the audit log.
+type AuditLogJSON struct {
+	Entries []AuditJSON
+	Next    string `json:",omitempty"`
+}
+
+// Audit returns the audit log of the admin API, newest first, limit entries,
+// 50 by default, at a time. To get the next page, pass the Next cursor of a
+// response as cursor.
+//   curl -H 'Authorization: Bearer t0k3n' http://localhost:8080/admin/Audit
+func (s *Server) Audit(w http.ResponseWriter, r *http.Request, admin string) *appError {
+	const feed = "Audit"
+	limit := 50
+	if v := r.FormValue("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l <= 0 {
+			return &appError{Message: "limit must be a positive number", Code: http.StatusBadRequest}
+		}
+		limit = l
+	}
+	var start json.RawMessage
+	if token := r.FormValue("cursor"); token != "" {
+		c, err := s.cursors.decode(token)
+		if err != nil {
+			return &appError{Message: err.Error(), Code: http.StatusBadRequest}
+		}
+		if c.Feed != feed {
+			return &appError{Message: "cursor is for another feed", Code: http.StatusBadRequest}
+		}
+		start = c.Key
+	}
+
+	entries, last, err := s.store.AuditLog(start, limit)
+	if err != nil {
+		glog.Errorf("%v", err)
+		return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+	}
+	resp := AuditLogJSON{Entries: make([]AuditJSON, len(entries))}
+	for i, a := range entries {
+		aj := AuditJSON{Time: postTime(a.K.B), Admin: a.A.S, Action: a.Op.S}
+		if a.P != nil {
+			aj.Type, aj.Key = splitPostPK(a.P.B)
+		}
+		if a.D != nil {
+			aj.Device = string(a.D.B)
+		}
+		if a.M != nil {
+			aj.Detail = a.M.S
+		}
+		resp.Entries[i] = aj
+	}
+	if last != nil {
+		token, err := s.cursors.encode(cursor{Feed: feed, Key: last})
+		if err != nil {
+			return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+		}
+		resp.Next = token
+	}
+	json.NewEncoder(w).Encode(resp)
+	return nil
+}
@@ -0,0 +1,128 @@
+package burstbooth
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/cardinalblue/burstbooth/util"
+)
+
+func TestAdmin(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *Server, ts *httptest.Server) {
+		s.admins = []adminToken{{name: "alice", token: "a"}, {name: "bob", token: "b"}}
+		admin := func(path string, v url.Values, token string, res interface{}) int {
+			header := http.Header{"Authorization": {"Bearer " + token}}
+			resp, _, err := util.JSONReq5("POST", ts.URL+path+"?"+v.Encode(), nil, header, res)
+			if err != nil {
+				t.Fatalf("%s: %v", path, err)
+			}
+			return resp.StatusCode
+		}
+
+		p := postAndVoteNTimes(ts, "http://127.0.0.1/a.gif", 2)
+		v := url.Values{"key": {base64.StdEncoding.EncodeToString(p.K.B)}}
+		if code := admin("/admin/Hide", v, "c", nil); code != http.StatusUnauthorized {
+			t.Fatalf("%d", code)
+		}
+
+		v.Set("caption", "  fixed\ncaption ")
+		edited := PostDDB{}
+		if code := admin("/admin/EditCaption", v, "a", &edited); code != http.StatusOK || edited.C == nil || edited.C.S != "fixed caption" {
+			t.Fatalf("%d %+v", code, edited)
+		}
+		v.Del("caption")
+
+		// Hidden posts leave the feeds until they are unhidden.
+		for _, c := range []struct {
+			path  string
+			posts int
+		}{{"/admin/Hide", 0}, {"/admin/Unhide", 1}} {
+			if code := admin(c.path, v, "b", nil); code != http.StatusOK {
+				t.Fatalf("%s: %d", c.path, code)
+			}
+			imgs := FeedJSON{}
+			util.JSONReq3("GET", ts.URL+"/New", &imgs)
+			if len(imgs.Posts) != c.posts {
+				t.Fatalf("%s: %+v", c.path, imgs)
+			}
+		}
+
+		// Resetting the score deletes the votes, so devices can vote again.
+		reset := PostDDB{}
+		if code := admin("/admin/ResetScore", v, "a", &reset); code != http.StatusOK || reset.S.N != "0" {
+			t.Fatalf("%d %+v", code, reset)
+		}
+		vote := url.Values{"device_id": {"0"}, "key": v["key"]}
+		pj := PostJSON{}
+		resp, _, err := util.JSONReq3("POST", ts.URL+"/Vote?"+vote.Encode(), &pj)
+		if err != nil || resp.StatusCode != http.StatusOK || pj.S.N != "1" {
+			t.Fatalf("%v %+v %+v", err, resp, pj)
+		}
+
+		// Banned devices can not vote.
+		ban := url.Values{"device_id": {"1"}}
+		if code := admin("/admin/Ban", ban, "a", nil); code != http.StatusBadRequest {
+			t.Fatalf("%d", code)
+		}
+		ban.Set("reason", "spam")
+		d := DeviceDDB{}
+		if code := admin("/admin/Ban", ban, "a", &d); code != http.StatusOK || d.BN == nil || d.BN.S != "spam" {
+			t.Fatalf("%d %+v", code, d)
+		}
+		vote.Set("device_id", "1")
+		resp, _, err = util.JSONReq3("POST", ts.URL+"/Vote?"+vote.Encode(), nil)
+		if err != nil || resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("%v %+v", err, resp)
+		}
+		ban.Del("reason")
+		admin("/admin/Unban", ban, "a", nil)
+		resp, _, err = util.JSONReq3("POST", ts.URL+"/Vote?"+vote.Encode(), nil)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("%v %+v", err, resp)
+		}
+
+		if code := admin("/admin/DeletePost", v, "b", nil); code != http.StatusOK {
+			t.Fatalf("%d", code)
+		}
+		if code := admin("/admin/DeletePost", v, "b", nil); code != http.StatusNotFound {
+			t.Fatalf("%d", code)
+		}
+		resp, _, err = util.JSONReq3("POST", ts.URL+"/Unvote?"+vote.Encode(), nil)
+		if err != nil || resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%v %+v", err, resp)
+		}
+
+		// Every action is in the audit log, newest first.
+		want := []string{"DeletePost", "Unban", "Ban", "ResetScore", "Unhide", "Hide", "EditCaption"}
+		var got []AuditJSON
+		cursor := ""
+		for {
+			page := AuditLogJSON{}
+			q := url.Values{"limit": {"3"}, "cursor": {cursor}}
+			if code := admin("/admin/Audit", q, "a", &page); code != http.StatusOK {
+				t.Fatalf("%d", code)
+			}
+			got = append(got, page.Entries...)
+			if cursor = page.Next; cursor == "" {
+				break
+			}
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%+v", got)
+		}
+		for i, a := range got {
+			if a.Action != want[i] {
+				t.Fatalf("%d: %+v", i, a)
+			}
+		}
+		if a := got[len(got)-1]; a.Admin != "alice" || a.Type != postTypeGIF || string(a.Key) != string(p.K.B) || a.Detail != "fixed caption" {
+			t.Fatalf("%+v", a)
+		}
+		if a := got[2]; a.Admin != "alice" || a.Device != "1" || a.Detail != "spam" {
+			t.Fatalf("%+v", a)
+		}
+	})
+}
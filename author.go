@@ -0,0 +1,61 @@
+package burstbooth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/golang/glog"
+)
+
+// MyPosts returns the posts of the requesting device, of every type, newest
+// first, including those under moderation. It pages like New.
+//   curl 'http://localhost:8080/MyPosts?device_id=ddd'
+func (s *Server) MyPosts(w http.ResponseWriter, r *http.Request) *appError {
+	deviceID, appErr := s.requireDevice(r)
+	if appErr != nil {
+		return appErr
+	}
+	// Cursors name the device, so that they can not page another one.
+	feed := "Mine/" + deviceID
+	q, appErr := s.feedQuery(r, feed, "")
+	if appErr != nil {
+		return appErr
+	}
+
+	page, err := s.store.AuthorPosts([]byte(deviceID), q)
+	if err != nil {
+		glog.Errorf("%v", err)
+		return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+	}
+	resp, appErr := s.feedJSON(feed, q, page, []byte(deviceID))
+	if appErr != nil {
+		return appErr
+	}
+	json.NewEncoder(w).Encode(resp)
+	return nil
+}
+
+// DeleteMyPost deletes a post, identified like in Vote, that the requesting
+// device created, with everything attached to it like in admin DeletePost.
+// It responds with the deleted post.
+//   curl 'http://localhost:8080/DeleteMyPost?device_id=ddd&key=E7MySUSwyFQ%3D'
+func (s *Server) DeleteMyPost(w http.ResponseWriter, r *http.Request) *appError {
+	deviceID, appErr := s.requireDevice(r)
+	if appErr != nil {
+		return appErr
+	}
+	post, appErr := s.formPost(r)
+	if appErr != nil {
+		return appErr
+	}
+	if post.A == nil || !bytes.Equal(post.A.B, []byte(deviceID)) {
+		return &appError{Message: "not your post", Code: http.StatusForbidden}
+	}
+	if appErr := voteError(s.store.DeletePost(post.I.S, post.K.B)); appErr != nil {
+		return appErr
+	}
+	s.deletePostBlobs(post.I.S, post.K.B)
+	json.NewEncoder(w).Encode(post)
+	return nil
+}
@@ -0,0 +1,87 @@
+package burstbooth
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/cardinalblue/burstbooth/util"
+)
+
+func TestMyPosts(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *Server, ts *httptest.Server) {
+		urls := map[string]string{postTypeGIF: "http://127.0.0.1/a.gif", postTypePhoto: "http://127.0.0.1/photo.jpg"}
+		post := func(device, postType string) PostDDB {
+			p := PostDDB{}
+			v := url.Values{"url": {urls[postType]}, "device_id": {device}, "type": {postType}}
+			resp, _, err := util.JSONReq3("POST", ts.URL+"/PostImg?"+v.Encode(), &p)
+			if err != nil || resp.StatusCode != http.StatusOK {
+				t.Fatalf("%v %+v", err, resp)
+			}
+			return p
+		}
+		var mine []PostDDB
+		for _, postType := range []string{postTypeGIF, postTypePhoto, postTypeGIF} {
+			mine = append(mine, post("ddd", postType))
+			post("eee", postType)
+		}
+		postAndVoteNTimes(ts, "http://127.0.0.1/a.gif", 0)
+
+		// Pages cover every type, newest first.
+		var got []PostJSON
+		cursor := ""
+		for {
+			page := FeedJSON{}
+			v := url.Values{"device_id": {"ddd"}, "limit": {"2"}, "cursor": {cursor}}
+			resp, _, err := util.JSONReq3("GET", ts.URL+"/MyPosts?"+v.Encode(), &page)
+			if err != nil || resp.StatusCode != http.StatusOK {
+				t.Fatalf("%v %+v", err, resp)
+			}
+			got = append(got, page.Posts...)
+			if cursor = page.Next; cursor == "" {
+				break
+			}
+		}
+		if len(got) != len(mine) {
+			t.Fatalf("%+v", got)
+		}
+		for i, p := range got {
+			if want := mine[len(mine)-1-i]; string(p.K.B) != string(want.K.B) {
+				t.Fatalf("%d: %+v", i, p)
+			}
+		}
+
+		// A cursor pages only the device it was issued to.
+		page := FeedJSON{}
+		util.JSONReq3("GET", ts.URL+"/MyPosts?device_id=ddd&limit=1", &page)
+		v := url.Values{"device_id": {"eee"}, "cursor": {page.Next}}
+		resp, _, err := util.JSONReq3("GET", ts.URL+"/MyPosts?"+v.Encode(), nil)
+		if err != nil || resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%v %+v", err, resp)
+		}
+		resp, _, err = util.JSONReq3("GET", ts.URL+"/MyPosts", nil)
+		if err != nil || resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%v %+v", err, resp)
+		}
+
+		// Only the author can delete a post.
+		del := url.Values{"device_id": {"eee"}, "key": {base64.StdEncoding.EncodeToString(mine[0].K.B)}}
+		for _, c := range []struct {
+			device string
+			code   int
+		}{{"eee", http.StatusForbidden}, {"ddd", http.StatusOK}, {"ddd", http.StatusNotFound}} {
+			del.Set("device_id", c.device)
+			resp, _, err := util.JSONReq3("POST", ts.URL+"/DeleteMyPost?"+del.Encode(), nil)
+			if err != nil || resp.StatusCode != c.code {
+				t.Fatalf("%+v: %v %+v", c, err, resp)
+			}
+		}
+		page = FeedJSON{}
+		util.JSONReq3("GET", ts.URL+"/MyPosts?device_id=ddd", &page)
+		if len(page.Posts) != len(mine)-1 {
+			t.Fatalf("%+v", page)
+		}
+	})
+}
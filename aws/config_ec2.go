@@ -18,4 +18,8 @@ func init() {
 		glog.Fatalf("%v", err)
 	}
 	SQSEndpoint = "http://sqs." + region + ".amazonaws.com/"
+	s3Endpoint, err = url.Parse("https://s3." + region + ".amazonaws.com/")
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
 }
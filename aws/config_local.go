@@ -16,4 +16,8 @@ func init() {
 		glog.Fatalf("%v", err)
 	}
 	SQSEndpoint = "http://localhost:" + os.Getenv("SQS_PORT")
+	s3Endpoint, err = url.Parse("http://localhost:" + os.Getenv("S3_PORT"))
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
 }
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/smartystreets/go-aws-auth"
 )
@@ -21,8 +22,45 @@ func (e *ErrDynamoDB) Error() string {
 	return e.Message
 }
 
+// CancellationReason explains the outcome of one operation of a canceled
+// transaction. Code is "None" for operations that would have succeeded.
+type CancellationReason struct {
+	Code    string
+	Message string
+}
+
+// ErrTransactionCanceled is returned when DynamoDB cancels a
+// TransactWriteItems call. Reasons holds one entry per operation, in the
+// order of the request.
+type ErrTransactionCanceled struct {
+	ErrDynamoDB
+	Reasons []CancellationReason
+}
+
+// ConditionFailed reports whether the condition of operation i failed.
+func (e *ErrTransactionCanceled) ConditionFailed(i int) bool {
+	return i < len(e.Reasons) && e.Reasons[i].Code == "ConditionalCheckFailed"
+}
+
+// Invalid reports whether operation i was invalid for the item it targets,
+// like an update of a path the item lacks.
+func (e *ErrTransactionCanceled) Invalid(i int) bool {
+	return i < len(e.Reasons) && e.Reasons[i].Code == "ValidationError"
+}
+
 var dynamoDBEndpoint *url.URL
 
+// SetDynamoDBEndpoint points all DynamoDB requests at rawurl, for example an
+// in-process fake from the dynamodbtest package.
+func SetDynamoDBEndpoint(rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+	dynamoDBEndpoint = u
+	return nil
+}
+
 func DynamoDBPost(operation string, reqb interface{}, respj interface{}) error {
 	body, err := json.Marshal(reqb)
 	if err != nil {
@@ -55,13 +93,18 @@ func DynamoDBPostBytes(operation string, body []byte, respj interface{}) error {
 	if resp.StatusCode != 200 {
 		derr := &ErrDynamoDB{}
 		if err := json.Unmarshal(respBody, derr); err != nil {
-			return fmt.Errorf(string(respBody))
+			return fmt.Errorf("%s", respBody)
 		}
 		z := strings.SplitN(derr.Type, "#", 2)
 		if len(z) != 2 {
-			return fmt.Errorf(string(respBody))
+			return fmt.Errorf("%s", respBody)
 		}
 		derr.Type = z[1]
+		if derr.Type == "TransactionCanceledException" {
+			reasons := struct{ CancellationReasons []CancellationReason }{}
+			json.Unmarshal(respBody, &reasons)
+			return &ErrTransactionCanceled{ErrDynamoDB: *derr, Reasons: reasons.CancellationReasons}
+		}
 		return derr
 	}
 
@@ -74,3 +117,68 @@ func DynamoDBPostBytes(operation string, body []byte, respj interface{}) error {
 	}
 	return nil
 }
+
+const (
+	// batchGetMaxKeys is the most keys BatchGetItem accepts in one request.
+	batchGetMaxKeys = 100
+	// batchGetMaxAttempts bounds how often UnprocessedKeys are retried.
+	batchGetMaxAttempts = 8
+)
+
+// DynamoDBBatchGet gets the items with the given keys from a table using
+// BatchGetItem. Keys that DynamoDB leaves unprocessed are retried with
+// exponential backoff. Items are returned in no particular order, and keys
+// without an item are left out.
+func DynamoDBBatchGet(tableName string, keys []interface{}) ([]json.RawMessage, error) {
+	var items []json.RawMessage
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > batchGetMaxKeys {
+			n = batchGetMaxKeys
+		}
+		batch := make([]json.RawMessage, n)
+		for i, k := range keys[:n] {
+			b, err := json.Marshal(k)
+			if err != nil {
+				return nil, err
+			}
+			batch[i] = b
+		}
+		keys = keys[n:]
+
+		backoff := 50 * time.Millisecond
+		for attempt := 1; len(batch) > 0; attempt++ {
+			if attempt > batchGetMaxAttempts {
+				return nil, fmt.Errorf("BatchGetItem: %d keys still unprocessed after %d attempts", len(batch), batchGetMaxAttempts)
+			}
+			if attempt > 1 {
+				<-time.After(backoff)
+				backoff *= 2
+			}
+			req := struct {
+				RequestItems map[string]struct{ Keys []json.RawMessage }
+			}{
+				RequestItems: map[string]struct{ Keys []json.RawMessage }{tableName: {Keys: batch}},
+			}
+			resp := struct {
+				Responses       map[string][]json.RawMessage
+				UnprocessedKeys map[string]struct{ Keys []json.RawMessage }
+			}{}
+			if err := DynamoDBPost("BatchGetItem", req, &resp); err != nil {
+				return nil, err
+			}
+			items = append(items, resp.Responses[tableName]...)
+			batch = resp.UnprocessedKeys[tableName].Keys
+		}
+	}
+	return items, nil
+}
+
+// DynamoDBTransactWrite performs the given TransactItems, each a struct with
+// one of Put, Update, Delete or ConditionCheck set, as a single
+// TransactWriteItems call. If DynamoDB cancels the transaction the error is
+// an *ErrTransactionCanceled.
+func DynamoDBTransactWrite(items ...interface{}) error {
+	req := struct{ TransactItems []interface{} }{TransactItems: items}
+	return DynamoDBPost("TransactWriteItems", req, nil)
+}
@@ -0,0 +1,905 @@
+package dynamodbtest
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// This file implements the subset of DynamoDB's expression language used by
+// condition, key condition, filter and update expressions.
+
+type token struct {
+	kind string // "ident", "name", "value", "num" or the symbol itself
+	text string
+}
+
+func tokenize(s string) ([]token, error) {
+	var toks []token
+	isIdent := func(r byte) bool {
+		return r == '_' || r < unicode.MaxASCII && (unicode.IsLetter(rune(r)) || unicode.IsDigit(rune(r)))
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '#' || c == ':':
+			j := i + 1
+			for j < len(s) && isIdent(s[j]) {
+				j++
+			}
+			if j == i+1 {
+				return nil, fmt.Errorf("invalid token at %q", s[i:])
+			}
+			kind := "name"
+			if c == ':' {
+				kind = "value"
+			}
+			toks = append(toks, token{kind: kind, text: s[i:j]})
+			i = j
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+				j++
+			}
+			toks = append(toks, token{kind: "num", text: s[i:j]})
+			i = j
+		case isIdent(c):
+			j := i
+			for j < len(s) && isIdent(s[j]) {
+				j++
+			}
+			toks = append(toks, token{kind: "ident", text: s[i:j]})
+			i = j
+		case strings.HasPrefix(s[i:], "<>") || strings.HasPrefix(s[i:], "<=") || strings.HasPrefix(s[i:], ">="):
+			toks = append(toks, token{kind: s[i : i+2], text: s[i : i+2]})
+			i += 2
+		case strings.ContainsRune("()[],.=<>+-", rune(c)):
+			toks = append(toks, token{kind: string(c), text: string(c)})
+			i++
+		default:
+			return nil, fmt.Errorf("invalid character %q in expression", c)
+		}
+	}
+	return toks, nil
+}
+
+// pathElem is one step of a document path: an attribute or map key, or a
+// list index.
+type pathElem struct {
+	name  string
+	index int
+	isIdx bool
+}
+
+type docPath []pathElem
+
+func (p docPath) String() string {
+	var b strings.Builder
+	for i, e := range p {
+		if e.isIdx {
+			fmt.Fprintf(&b, "[%d]", e.index)
+			continue
+		}
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(e.name)
+	}
+	return b.String()
+}
+
+func (p docPath) get(it item) *attr {
+	var cur *attr
+	for i, e := range p {
+		switch {
+		case i == 0:
+			cur = it[e.name]
+		case e.isIdx:
+			if cur.typ() != "L" || e.index >= len(cur.L) {
+				return nil
+			}
+			cur = cur.L[e.index]
+		default:
+			if cur.typ() != "M" {
+				return nil
+			}
+			cur = cur.M[e.name]
+		}
+		if cur == nil {
+			return nil
+		}
+	}
+	return cur
+}
+
+// set stores v at the path, which must have an existing parent.
+func (p docPath) set(it item, v *attr) error {
+	if len(p) == 1 {
+		it[p[0].name] = v
+		return nil
+	}
+	parent := p[:len(p)-1].get(it)
+	last := p[len(p)-1]
+	switch {
+	case last.isIdx && parent.typ() == "L":
+		if last.index >= len(parent.L) {
+			parent.L = append(parent.L, v)
+		} else {
+			parent.L[last.index] = v
+		}
+	case !last.isIdx && parent.typ() == "M":
+		parent.M[last.name] = v
+	default:
+		return fmt.Errorf("the document path provided in the update expression is invalid for update")
+	}
+	return nil
+}
+
+func (p docPath) remove(it item) {
+	if len(p) == 1 {
+		delete(it, p[0].name)
+		return
+	}
+	parent := p[:len(p)-1].get(it)
+	last := p[len(p)-1]
+	switch {
+	case last.isIdx && parent.typ() == "L" && last.index < len(parent.L):
+		parent.L = append(parent.L[:last.index], parent.L[last.index+1:]...)
+	case !last.isIdx && parent.typ() == "M":
+		delete(parent.M, last.name)
+	}
+}
+
+// operand is anything that evaluates to an attribute value.
+type operand interface {
+	eval(it item) (*attr, error)
+}
+
+type pathOperand docPath
+
+func (o pathOperand) eval(it item) (*attr, error) { return docPath(o).get(it), nil }
+
+type valueOperand struct{ v *attr }
+
+func (o valueOperand) eval(it item) (*attr, error) { return o.v, nil }
+
+type sizeOperand docPath
+
+func (o sizeOperand) eval(it item) (*attr, error) {
+	a := docPath(o).get(it)
+	n := 0
+	switch a.typ() {
+	case "S":
+		n = len(*a.S)
+	case "B":
+		n = len(a.B)
+	case "SS":
+		n = len(a.SS)
+	case "NS":
+		n = len(a.NS)
+	case "BS":
+		n = len(a.BS)
+	case "L":
+		n = len(a.L)
+	case "M":
+		n = len(a.M)
+	default:
+		return nil, nil
+	}
+	return numAttr(big.NewRat(int64(n), 1)), nil
+}
+
+type arithOperand struct {
+	op   string
+	l, r operand
+}
+
+func (o arithOperand) eval(it item) (*attr, error) {
+	l, err := o.l.eval(it)
+	if err != nil {
+		return nil, err
+	}
+	r, err := o.r.eval(it)
+	if err != nil {
+		return nil, err
+	}
+	if l.typ() != "N" || r.typ() != "N" {
+		return nil, fmt.Errorf("an operand in the update expression has an incorrect data type")
+	}
+	ln, err := parseNum(*l.N)
+	if err != nil {
+		return nil, err
+	}
+	rn, err := parseNum(*r.N)
+	if err != nil {
+		return nil, err
+	}
+	if o.op == "+" {
+		return numAttr(new(big.Rat).Add(ln, rn)), nil
+	}
+	return numAttr(new(big.Rat).Sub(ln, rn)), nil
+}
+
+type ifNotExistsOperand struct {
+	p   docPath
+	def operand
+}
+
+func (o ifNotExistsOperand) eval(it item) (*attr, error) {
+	if v := o.p.get(it); v != nil {
+		return v, nil
+	}
+	return o.def.eval(it)
+}
+
+type listAppendOperand struct{ l, r operand }
+
+func (o listAppendOperand) eval(it item) (*attr, error) {
+	l, err := o.l.eval(it)
+	if err != nil {
+		return nil, err
+	}
+	r, err := o.r.eval(it)
+	if err != nil {
+		return nil, err
+	}
+	if l.typ() != "L" || r.typ() != "L" {
+		return nil, fmt.Errorf("an operand in the update expression has an incorrect data type")
+	}
+	out := &attr{L: []*attr{}}
+	out.L = append(out.L, l.L...)
+	out.L = append(out.L, r.L...)
+	return out, nil
+}
+
+// cond is a boolean condition over an item.
+type cond interface {
+	match(it item) (bool, error)
+}
+
+type andCond struct{ l, r cond }
+
+func (c andCond) match(it item) (bool, error) {
+	ok, err := c.l.match(it)
+	if err != nil || !ok {
+		return false, err
+	}
+	return c.r.match(it)
+}
+
+type orCond struct{ l, r cond }
+
+func (c orCond) match(it item) (bool, error) {
+	ok, err := c.l.match(it)
+	if err != nil || ok {
+		return ok, err
+	}
+	return c.r.match(it)
+}
+
+type notCond struct{ c cond }
+
+func (c notCond) match(it item) (bool, error) {
+	ok, err := c.c.match(it)
+	return !ok, err
+}
+
+type cmpCond struct {
+	op   string
+	l, r operand
+}
+
+func (c cmpCond) match(it item) (bool, error) {
+	l, err := c.l.eval(it)
+	if err != nil {
+		return false, err
+	}
+	r, err := c.r.eval(it)
+	if err != nil {
+		return false, err
+	}
+	if c.op == "<>" {
+		return l == nil || r == nil || !equal(l, r), nil
+	}
+	if l == nil || r == nil {
+		return false, nil
+	}
+	if c.op == "=" {
+		return equal(l, r), nil
+	}
+	n, ok := compare(l, r)
+	if !ok {
+		return false, nil
+	}
+	switch c.op {
+	case "<":
+		return n < 0, nil
+	case "<=":
+		return n <= 0, nil
+	case ">":
+		return n > 0, nil
+	case ">=":
+		return n >= 0, nil
+	}
+	return false, fmt.Errorf("unknown comparator %s", c.op)
+}
+
+type betweenCond struct{ v, lo, hi operand }
+
+func (c betweenCond) match(it item) (bool, error) {
+	ok, err := cmpCond{op: ">=", l: c.v, r: c.lo}.match(it)
+	if err != nil || !ok {
+		return false, err
+	}
+	return cmpCond{op: "<=", l: c.v, r: c.hi}.match(it)
+}
+
+type inCond struct {
+	v    operand
+	list []operand
+}
+
+func (c inCond) match(it item) (bool, error) {
+	for _, o := range c.list {
+		ok, err := cmpCond{op: "=", l: c.v, r: o}.match(it)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+type funcCond struct {
+	name string
+	args []operand
+}
+
+func (c funcCond) match(it item) (bool, error) {
+	vals := make([]*attr, len(c.args))
+	for i, a := range c.args {
+		v, err := a.eval(it)
+		if err != nil {
+			return false, err
+		}
+		vals[i] = v
+	}
+	switch c.name {
+	case "attribute_exists":
+		return vals[0] != nil, nil
+	case "attribute_not_exists":
+		return vals[0] == nil, nil
+	case "attribute_type":
+		return vals[0] != nil && vals[1].typ() == "S" && vals[0].typ() == *vals[1].S, nil
+	case "begins_with":
+		switch {
+		case vals[0].typ() == "S" && vals[1].typ() == "S":
+			return strings.HasPrefix(*vals[0].S, *vals[1].S), nil
+		case vals[0].typ() == "B" && vals[1].typ() == "B":
+			return strings.HasPrefix(string(vals[0].B), string(vals[1].B)), nil
+		}
+		return false, nil
+	case "contains":
+		a, b := vals[0], vals[1]
+		switch a.typ() {
+		case "S":
+			return b.typ() == "S" && strings.Contains(*a.S, *b.S), nil
+		case "B":
+			return b.typ() == "B" && strings.Contains(string(a.B), string(b.B)), nil
+		case "SS", "NS", "BS":
+			return setHas(a, b), nil
+		case "L":
+			for _, v := range a.L {
+				if equal(v, b) {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid function name: %s", c.name)
+}
+
+// setHas reports whether the scalar b is a member of the set a.
+func setHas(a, b *attr) bool {
+	var k string
+	switch {
+	case a.typ() == "SS" && b.typ() == "S":
+		k = *b.S
+	case a.typ() == "BS" && b.typ() == "B":
+		k = string(b.B)
+	case a.typ() == "NS" && b.typ() == "N":
+		r, err := parseNum(*b.N)
+		if err != nil {
+			return false
+		}
+		k = r.RatString()
+	default:
+		return false
+	}
+	return setKeys(a)[k]
+}
+
+// exprParser is a recursive descent parser over the tokens of one
+// expression.
+type exprParser struct {
+	toks   []token
+	pos    int
+	names  map[string]string
+	values map[string]*attr
+
+	usedNames  map[string]bool
+	usedValues map[string]bool
+}
+
+func newExprParser(s string, names map[string]string, values map[string]*attr) (*exprParser, error) {
+	toks, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	return &exprParser{
+		toks:       toks,
+		names:      names,
+		values:     values,
+		usedNames:  make(map[string]bool),
+		usedValues: make(map[string]bool),
+	}, nil
+}
+
+func (p *exprParser) peek() token {
+	if p.pos >= len(p.toks) {
+		return token{}
+	}
+	return p.toks[p.pos]
+}
+
+func (p *exprParser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *exprParser) keyword(kw string) bool {
+	t := p.peek()
+	if t.kind == "ident" && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(kind string) error {
+	if t := p.next(); t.kind != kind {
+		return fmt.Errorf("syntax error; token: %q, expected %q", t.text, kind)
+	}
+	return nil
+}
+
+func (p *exprParser) done() error {
+	if p.pos < len(p.toks) {
+		return fmt.Errorf("syntax error; unexpected token: %q", p.peek().text)
+	}
+	return nil
+}
+
+func (p *exprParser) parseCond() (cond, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = orCond{l, r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseAnd() (cond, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l = andCond{l, r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseNot() (cond, error) {
+	if p.keyword("NOT") {
+		c, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notCond{c}, nil
+	}
+	return p.parsePrimary()
+}
+
+var condFuncs = map[string]int{
+	"attribute_exists":     1,
+	"attribute_not_exists": 1,
+	"attribute_type":       2,
+	"begins_with":          2,
+	"contains":             2,
+}
+
+func (p *exprParser) parsePrimary() (cond, error) {
+	if p.peek().kind == "(" {
+		p.next()
+		c, err := p.parseCond()
+		if err != nil {
+			return nil, err
+		}
+		return c, p.expect(")")
+	}
+	if t := p.peek(); t.kind == "ident" && p.pos+1 < len(p.toks) && p.toks[p.pos+1].kind == "(" {
+		if n, ok := condFuncs[t.text]; ok {
+			p.pos += 2
+			args := make([]operand, n)
+			for i := 0; i < n; i++ {
+				if i > 0 {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+				a, err := p.parseOperand()
+				if err != nil {
+					return nil, err
+				}
+				args[i] = a
+			}
+			return funcCond{name: t.text, args: args}, p.expect(")")
+		}
+	}
+	l, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if p.keyword("BETWEEN") {
+		lo, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword("AND") {
+			return nil, fmt.Errorf("syntax error; BETWEEN without AND")
+		}
+		hi, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return betweenCond{l, lo, hi}, nil
+	}
+	if p.keyword("IN") {
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		c := inCond{v: l}
+		for {
+			o, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			c.list = append(c.list, o)
+			if p.peek().kind != "," {
+				break
+			}
+			p.next()
+		}
+		return c, p.expect(")")
+	}
+	switch op := p.next(); op.kind {
+	case "=", "<>", "<", "<=", ">", ">=":
+		r, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return cmpCond{op: op.kind, l: l, r: r}, nil
+	default:
+		return nil, fmt.Errorf("syntax error; token: %q", op.text)
+	}
+}
+
+func (p *exprParser) parseOperand() (operand, error) {
+	t := p.peek()
+	switch {
+	case t.kind == "value":
+		p.next()
+		v, ok := p.values[t.text]
+		if !ok {
+			return nil, fmt.Errorf("an expression attribute value used in expression is not defined; attribute value: %s", t.text)
+		}
+		p.usedValues[t.text] = true
+		return valueOperand{v}, nil
+	case t.kind == "ident" && t.text == "size" && p.pos+1 < len(p.toks) && p.toks[p.pos+1].kind == "(":
+		p.pos += 2
+		path, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		return sizeOperand(path), p.expect(")")
+	}
+	path, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	return pathOperand(path), nil
+}
+
+func (p *exprParser) parsePathName() (string, error) {
+	t := p.next()
+	switch t.kind {
+	case "ident":
+		return t.text, nil
+	case "name":
+		n, ok := p.names[t.text]
+		if !ok {
+			return "", fmt.Errorf("an expression attribute name used in the document path is not defined; attribute name: %s", t.text)
+		}
+		p.usedNames[t.text] = true
+		return n, nil
+	}
+	return "", fmt.Errorf("syntax error; token: %q", t.text)
+}
+
+func (p *exprParser) parsePath() (docPath, error) {
+	name, err := p.parsePathName()
+	if err != nil {
+		return nil, err
+	}
+	path := docPath{{name: name}}
+	for {
+		switch p.peek().kind {
+		case ".":
+			p.next()
+			name, err := p.parsePathName()
+			if err != nil {
+				return nil, err
+			}
+			path = append(path, pathElem{name: name})
+		case "[":
+			p.next()
+			t := p.next()
+			if t.kind != "num" {
+				return nil, fmt.Errorf("syntax error; token: %q", t.text)
+			}
+			n, _ := strconv.Atoi(t.text)
+			path = append(path, pathElem{index: n, isIdx: true})
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+		default:
+			return path, nil
+		}
+	}
+}
+
+// parseCondition parses a condition, filter or key condition expression.
+func parseCondition(s string, names map[string]string, values map[string]*attr) (cond, *exprParser, error) {
+	p, err := newExprParser(s, names, values)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := p.parseCond()
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, p, p.done()
+}
+
+// updateAction is one clause of an update expression.
+type updateAction struct {
+	verb string // SET, REMOVE, ADD or DELETE
+	path docPath
+	val  operand
+}
+
+// parseUpdate parses an update expression.
+func parseUpdate(s string, names map[string]string, values map[string]*attr) ([]updateAction, *exprParser, error) {
+	p, err := newExprParser(s, names, values)
+	if err != nil {
+		return nil, nil, err
+	}
+	var actions []updateAction
+	seen := map[string]bool{}
+	for p.pos < len(p.toks) {
+		t := p.next()
+		verb := strings.ToUpper(t.text)
+		if t.kind != "ident" || (verb != "SET" && verb != "REMOVE" && verb != "ADD" && verb != "DELETE") {
+			return nil, nil, fmt.Errorf("syntax error; token: %q", t.text)
+		}
+		if seen[verb] {
+			return nil, nil, fmt.Errorf("the %s section can only be used once in an update expression", verb)
+		}
+		seen[verb] = true
+		for {
+			path, err := p.parsePath()
+			if err != nil {
+				return nil, nil, err
+			}
+			a := updateAction{verb: verb, path: path}
+			switch verb {
+			case "SET":
+				if err := p.expect("="); err != nil {
+					return nil, nil, err
+				}
+				if a.val, err = p.parseSetValue(); err != nil {
+					return nil, nil, err
+				}
+			case "ADD", "DELETE":
+				if a.val, err = p.parseOperand(); err != nil {
+					return nil, nil, err
+				}
+			}
+			actions = append(actions, a)
+			if p.peek().kind != "," {
+				break
+			}
+			p.next()
+		}
+	}
+	if len(actions) == 0 {
+		return nil, nil, fmt.Errorf("invalid UpdateExpression: the expression can not be empty")
+	}
+	return actions, p, nil
+}
+
+func (p *exprParser) parseSetValue() (operand, error) {
+	l, err := p.parseSetOperand()
+	if err != nil {
+		return nil, err
+	}
+	if k := p.peek().kind; k == "+" || k == "-" {
+		p.next()
+		r, err := p.parseSetOperand()
+		if err != nil {
+			return nil, err
+		}
+		return arithOperand{op: k, l: l, r: r}, nil
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseSetOperand() (operand, error) {
+	t := p.peek()
+	if t.kind == "ident" && p.pos+1 < len(p.toks) && p.toks[p.pos+1].kind == "(" {
+		switch t.text {
+		case "if_not_exists":
+			p.pos += 2
+			path, err := p.parsePath()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+			def, err := p.parseSetOperand()
+			if err != nil {
+				return nil, err
+			}
+			return ifNotExistsOperand{p: path, def: def}, p.expect(")")
+		case "list_append":
+			p.pos += 2
+			l, err := p.parseSetOperand()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+			r, err := p.parseSetOperand()
+			if err != nil {
+				return nil, err
+			}
+			return listAppendOperand{l, r}, p.expect(")")
+		}
+	}
+	return p.parseOperand()
+}
+
+// applyUpdate returns a copy of old with the update actions applied. All
+// operands are evaluated against old, as DynamoDB does.
+func applyUpdate(old item, actions []updateAction) (item, error) {
+	type change struct {
+		a updateAction
+		v *attr
+	}
+	changes := make([]change, len(actions))
+	for i, a := range actions {
+		if a.val == nil {
+			changes[i] = change{a: a}
+			continue
+		}
+		v, err := a.val.eval(old)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			return nil, fmt.Errorf("the provided expression refers to an attribute that does not exist in the item")
+		}
+		changes[i] = change{a: a, v: v.clone()}
+	}
+	it := old.clone()
+	for _, c := range changes {
+		cur := c.a.path.get(it)
+		switch c.a.verb {
+		case "SET":
+			if err := c.a.path.set(it, c.v); err != nil {
+				return nil, err
+			}
+		case "REMOVE":
+			c.a.path.remove(it)
+		case "ADD":
+			switch {
+			case cur == nil:
+				if t := c.v.typ(); t != "N" && t != "SS" && t != "NS" && t != "BS" {
+					return nil, fmt.Errorf("an operand in the update expression has an incorrect data type")
+				}
+				if err := c.a.path.set(it, c.v); err != nil {
+					return nil, err
+				}
+			case cur.typ() == "N" && c.v.typ() == "N":
+				sum, err := arithOperand{op: "+", l: valueOperand{cur}, r: valueOperand{c.v}}.eval(nil)
+				if err != nil {
+					return nil, err
+				}
+				c.a.path.set(it, sum)
+			case cur.typ() == c.v.typ() && (cur.typ() == "SS" || cur.typ() == "NS" || cur.typ() == "BS"):
+				c.a.path.set(it, setUnion(cur, c.v))
+			default:
+				return nil, fmt.Errorf("an operand in the update expression has an incorrect data type")
+			}
+		case "DELETE":
+			if cur == nil {
+				continue
+			}
+			if cur.typ() != c.v.typ() || (cur.typ() != "SS" && cur.typ() != "NS" && cur.typ() != "BS") {
+				return nil, fmt.Errorf("an operand in the update expression has an incorrect data type")
+			}
+			if rest := setDifference(cur, c.v); rest != nil {
+				c.a.path.set(it, rest)
+			} else {
+				c.a.path.remove(it)
+			}
+		}
+	}
+	return it, nil
+}
+
+// checkUnused mirrors DynamoDB's rejection of expression attribute names and
+// values that no expression refers to.
+func checkUnused(names map[string]string, values map[string]*attr, parsers ...*exprParser) error {
+	usedN, usedV := map[string]bool{}, map[string]bool{}
+	for _, p := range parsers {
+		if p == nil {
+			continue
+		}
+		for k := range p.usedNames {
+			usedN[k] = true
+		}
+		for k := range p.usedValues {
+			usedV[k] = true
+		}
+	}
+	for k := range names {
+		if !usedN[k] {
+			return fmt.Errorf("value provided in ExpressionAttributeNames unused in expressions: keys: {%s}", k)
+		}
+	}
+	for k := range values {
+		if !usedV[k] {
+			return fmt.Errorf("value provided in ExpressionAttributeValues unused in expressions: keys: {%s}", k)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,719 @@
+// Package dynamodbtest provides an in-process fake of the DynamoDB
+// DynamoDB_20120810 JSON API, so that code using the aws package can be
+// tested without DynamoDB Local.
+//
+// Only the operations and expression features this project uses are
+// implemented. Provisioned throughput and consumed capacity are ignored, and
+// every table is ACTIVE as soon as it is created.
+package dynamodbtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const targetPrefix = "DynamoDB_20120810."
+
+// ddbError is an error reported to the client with a DynamoDB error type.
+type ddbError struct {
+	Type    string
+	Message string
+
+	// CancellationReasons is set for TransactionCanceledException.
+	CancellationReasons []cancellationReason
+}
+
+func (e *ddbError) Error() string {
+	return e.Type + ": " + e.Message
+}
+
+func validationError(format string, a ...interface{}) error {
+	return &ddbError{Type: "ValidationException", Message: fmt.Sprintf(format, a...)}
+}
+
+func notFoundError(table string) error {
+	return &ddbError{Type: "ResourceNotFoundException", Message: "Cannot do operations on a non-existent table: " + table}
+}
+
+func conditionFailedError() error {
+	return &ddbError{Type: "ConditionalCheckFailedException", Message: "The conditional request failed"}
+}
+
+type keyElement struct {
+	AttributeName string
+	KeyType       string
+}
+
+type attributeDefinition struct {
+	AttributeName string
+	AttributeType string
+}
+
+type projection struct {
+	ProjectionType   string
+	NonKeyAttributes []string `json:",omitempty"`
+}
+
+type indexDefinition struct {
+	IndexName             string
+	KeySchema             []keyElement
+	Projection            projection
+	ProvisionedThroughput json.RawMessage `json:",omitempty"`
+}
+
+// keySchema names the hash and optional range attribute of a table or index.
+type keySchema struct {
+	hash, rng string
+}
+
+func newKeySchema(elems []keyElement) (keySchema, error) {
+	ks := keySchema{}
+	for _, e := range elems {
+		switch e.KeyType {
+		case "HASH":
+			ks.hash = e.AttributeName
+		case "RANGE":
+			ks.rng = e.AttributeName
+		default:
+			return ks, validationError("invalid KeyType %q", e.KeyType)
+		}
+	}
+	if ks.hash == "" || len(elems) > 2 || (len(elems) == 2 && ks.rng == "") {
+		return ks, validationError("invalid KeySchema")
+	}
+	return ks, nil
+}
+
+func (ks keySchema) names() []string {
+	if ks.rng == "" {
+		return []string{ks.hash}
+	}
+	return []string{ks.hash, ks.rng}
+}
+
+type index struct {
+	def indexDefinition
+	key keySchema
+}
+
+type table struct {
+	name      string
+	created   time.Time
+	key       keySchema
+	attrDefs  []attributeDefinition
+	attrTypes map[string]string
+	indexes   map[string]*index
+	items     map[string]item
+}
+
+func (t *table) description() interface{} {
+	indexes := []interface{}{}
+	for _, ix := range t.indexes {
+		indexes = append(indexes, struct {
+			indexDefinition
+			IndexStatus string
+			ItemCount   int
+		}{ix.def, "ACTIVE", len(t.indexItems(ix))})
+	}
+	return struct {
+		TableName              string
+		TableStatus            string
+		CreationDateTime       float64
+		AttributeDefinitions   []attributeDefinition
+		KeySchema              []keyElement
+		GlobalSecondaryIndexes []interface{} `json:",omitempty"`
+		ItemCount              int
+	}{
+		TableName:              t.name,
+		TableStatus:            "ACTIVE",
+		CreationDateTime:       float64(t.created.UnixNano()) / 1e9,
+		AttributeDefinitions:   t.attrDefs,
+		KeySchema:              keyElements(t.key),
+		GlobalSecondaryIndexes: indexes,
+		ItemCount:              len(t.items),
+	}
+}
+
+func keyElements(ks keySchema) []keyElement {
+	elems := []keyElement{{ks.hash, "HASH"}}
+	if ks.rng != "" {
+		elems = append(elems, keyElement{ks.rng, "RANGE"})
+	}
+	return elems
+}
+
+// primaryKey validates that key holds exactly the table's key attributes and
+// returns the string identifying the item.
+func (t *table) primaryKey(key item) (string, error) {
+	if len(key) != len(t.key.names()) {
+		return "", validationError("The provided key element does not match the schema")
+	}
+	for _, n := range t.key.names() {
+		if key[n].typ() != t.attrTypes[n] {
+			return "", validationError("The provided key element does not match the schema")
+		}
+	}
+	return key.keyString(t.key.names()...), nil
+}
+
+// checkItem validates the key and index attributes of an item about to be
+// stored.
+func (t *table) checkItem(it item) error {
+	for _, n := range t.key.names() {
+		v := it[n]
+		if v == nil {
+			return validationError("One of the required keys was not given a value")
+		}
+		if v.typ() != t.attrTypes[n] {
+			return validationError("One or more parameter values were invalid: Type mismatch for key %s expected: %s actual: %s", n, t.attrTypes[n], v.typ())
+		}
+		if (v.typ() == "S" && *v.S == "") || (v.typ() == "B" && len(v.B) == 0) {
+			return validationError("One or more parameter values are not valid. The AttributeValue for a key attribute cannot contain an empty value. Key: %s", n)
+		}
+	}
+	for _, ix := range t.indexes {
+		for _, n := range ix.key.names() {
+			if v := it[n]; v != nil && v.typ() != t.attrTypes[n] {
+				return validationError("One or more parameter values were invalid: Type mismatch for Index Key %s Expected: %s Actual: %s IndexName: %s", n, t.attrTypes[n], v.typ(), ix.def.IndexName)
+			}
+		}
+	}
+	return nil
+}
+
+// indexItems returns the items present in a secondary index. Items missing
+// any of the index key attributes are left out, like DynamoDB's sparse
+// indexes.
+func (t *table) indexItems(ix *index) []item {
+	var items []item
+	for _, it := range t.items {
+		ok := true
+		for _, n := range ix.key.names() {
+			if it[n] == nil {
+				ok = false
+			}
+		}
+		if ok {
+			items = append(items, it)
+		}
+	}
+	return items
+}
+
+// DB is an in-memory DynamoDB that serves the DynamoDB JSON protocol.
+type DB struct {
+	mu     sync.Mutex
+	tables map[string]*table
+
+	// batchGetLimit, when positive, caps the keys a BatchGetItem request
+	// processes, returning the rest as UnprocessedKeys.
+	batchGetLimit int
+}
+
+// SetBatchGetLimit makes BatchGetItem process at most n keys per request and
+// return the rest as UnprocessedKeys, as DynamoDB does when a request exceeds
+// its size limits or provisioned throughput. Zero removes the limit.
+func (db *DB) SetBatchGetLimit(n int) {
+	db.mu.Lock()
+	db.batchGetLimit = n
+	db.mu.Unlock()
+}
+
+// New returns an empty DB.
+func New() *DB {
+	return &DB{tables: make(map[string]*table)}
+}
+
+// NewServer starts an httptest.Server serving an empty DB. The caller should
+// call Close when finished.
+func NewServer() *httptest.Server {
+	return httptest.NewServer(New())
+}
+
+type operation func(db *DB, body []byte) (interface{}, error)
+
+var operations = map[string]operation{
+	"CreateTable":        (*DB).createTable,
+	"DeleteTable":        (*DB).deleteTable,
+	"ListTables":         (*DB).listTables,
+	"PutItem":            (*DB).putItem,
+	"GetItem":            (*DB).getItem,
+	"BatchGetItem":       (*DB).batchGetItem,
+	"UpdateItem":         (*DB).updateItem,
+	"DeleteItem":         (*DB).deleteItem,
+	"TransactWriteItems": (*DB).transactWriteItems,
+	"Query":              (*DB).query,
+}
+
+func (db *DB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	target := r.Header.Get("X-Amz-Target")
+	op, ok := operations[strings.TrimPrefix(target, targetPrefix)]
+	if r.Method != "POST" || !strings.HasPrefix(target, targetPrefix) || !ok {
+		writeError(w, &ddbError{Type: "UnknownOperationException", Message: "Unknown operation " + target})
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, &ddbError{Type: "SerializationException", Message: err.Error()})
+		return
+	}
+
+	db.mu.Lock()
+	resp, err := op(db, body)
+	db.mu.Unlock()
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	derr, ok := err.(*ddbError)
+	if !ok {
+		derr = &ddbError{Type: "ValidationException", Message: err.Error()}
+	}
+	code := http.StatusBadRequest
+	if derr.Type == "InternalServerError" {
+		code = http.StatusInternalServerError
+	}
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Type                string               `json:"__type"`
+		Message             string               `json:"message"`
+		CancellationReasons []cancellationReason `json:",omitempty"`
+	}{"com.amazonaws.dynamodb.v20120810#" + derr.Type, derr.Message, derr.CancellationReasons})
+}
+
+func decode(body []byte, req interface{}) error {
+	if err := json.Unmarshal(body, req); err != nil {
+		return &ddbError{Type: "SerializationException", Message: err.Error()}
+	}
+	return nil
+}
+
+func (db *DB) table(name string) (*table, error) {
+	t, ok := db.tables[name]
+	if !ok {
+		return nil, notFoundError(name)
+	}
+	return t, nil
+}
+
+func (db *DB) createTable(body []byte) (interface{}, error) {
+	req := struct {
+		TableName              string
+		AttributeDefinitions   []attributeDefinition
+		KeySchema              []keyElement
+		GlobalSecondaryIndexes []indexDefinition
+		LocalSecondaryIndexes  []indexDefinition
+	}{}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+	if len(req.TableName) < 3 {
+		return nil, validationError("TableName must be at least 3 characters long")
+	}
+	if _, ok := db.tables[req.TableName]; ok {
+		return nil, &ddbError{Type: "ResourceInUseException", Message: "Cannot create preexisting table"}
+	}
+	t := &table{
+		name:      req.TableName,
+		created:   time.Now(),
+		attrDefs:  req.AttributeDefinitions,
+		attrTypes: make(map[string]string),
+		indexes:   make(map[string]*index),
+		items:     make(map[string]item),
+	}
+	for _, d := range req.AttributeDefinitions {
+		if d.AttributeType != "S" && d.AttributeType != "N" && d.AttributeType != "B" {
+			return nil, validationError("invalid AttributeType %q for %s", d.AttributeType, d.AttributeName)
+		}
+		t.attrTypes[d.AttributeName] = d.AttributeType
+	}
+	ks, err := newKeySchema(req.KeySchema)
+	if err != nil {
+		return nil, err
+	}
+	t.key = ks
+	used := map[string]bool{}
+	for _, n := range ks.names() {
+		used[n] = true
+	}
+	for _, def := range append(req.GlobalSecondaryIndexes, req.LocalSecondaryIndexes...) {
+		if _, ok := t.indexes[def.IndexName]; ok {
+			return nil, validationError("Duplicate index name: %s", def.IndexName)
+		}
+		iks, err := newKeySchema(def.KeySchema)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range iks.names() {
+			used[n] = true
+		}
+		t.indexes[def.IndexName] = &index{def: def, key: iks}
+	}
+	for n := range used {
+		if t.attrTypes[n] == "" {
+			return nil, validationError("One or more parameter values were invalid: Some index key attributes are not defined in AttributeDefinitions. Keys: [%s]", n)
+		}
+	}
+	if len(used) != len(t.attrTypes) {
+		return nil, validationError("One or more parameter values were invalid: Number of attributes in KeySchema does not exactly match number of attributes defined in AttributeDefinitions")
+	}
+	db.tables[t.name] = t
+	return struct{ TableDescription interface{} }{t.description()}, nil
+}
+
+func (db *DB) deleteTable(body []byte) (interface{}, error) {
+	req := struct{ TableName string }{}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+	t, err := db.table(req.TableName)
+	if err != nil {
+		return nil, err
+	}
+	delete(db.tables, req.TableName)
+	return struct{ TableDescription interface{} }{t.description()}, nil
+}
+
+func (db *DB) listTables(body []byte) (interface{}, error) {
+	req := struct {
+		ExclusiveStartTableName string
+		Limit                   int
+	}{}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for n := range db.tables {
+		if n > req.ExclusiveStartTableName {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	resp := struct {
+		TableNames             []string
+		LastEvaluatedTableName string `json:",omitempty"`
+	}{TableNames: names}
+	if req.Limit > 0 && len(names) > req.Limit {
+		resp.TableNames = names[:req.Limit]
+		resp.LastEvaluatedTableName = names[req.Limit-1]
+	}
+	return resp, nil
+}
+
+func (db *DB) getItem(body []byte) (interface{}, error) {
+	req := struct {
+		TableName      string
+		Key            item
+		ConsistentRead bool
+	}{}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+	t, err := db.table(req.TableName)
+	if err != nil {
+		return nil, err
+	}
+	pk, err := t.primaryKey(req.Key)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		Item item `json:",omitempty"`
+	}{t.items[pk].clone()}, nil
+}
+
+func (db *DB) batchGetItem(body []byte) (interface{}, error) {
+	req := struct {
+		RequestItems map[string]struct {
+			Keys           []item
+			ConsistentRead bool
+		}
+	}{}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+	total := 0
+	for _, ri := range req.RequestItems {
+		total += len(ri.Keys)
+	}
+	if total == 0 || total > 100 {
+		return nil, validationError("Too many items requested for the BatchGetItem call")
+	}
+	resp := struct {
+		Responses       map[string][]item
+		UnprocessedKeys map[string]struct{ Keys []item }
+	}{
+		Responses:       make(map[string][]item),
+		UnprocessedKeys: make(map[string]struct{ Keys []item }),
+	}
+	names := make([]string, 0, len(req.RequestItems))
+	for n := range req.RequestItems {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	processed := 0
+	for _, n := range names {
+		t, err := db.table(n)
+		if err != nil {
+			return nil, err
+		}
+		seen := map[string]bool{}
+		resp.Responses[n] = []item{}
+		for _, k := range req.RequestItems[n].Keys {
+			pk, err := t.primaryKey(k)
+			if err != nil {
+				return nil, err
+			}
+			if seen[pk] {
+				return nil, validationError("Provided list of item keys contains duplicates")
+			}
+			seen[pk] = true
+			if db.batchGetLimit > 0 && processed >= db.batchGetLimit {
+				u := resp.UnprocessedKeys[n]
+				u.Keys = append(u.Keys, k)
+				resp.UnprocessedKeys[n] = u
+				continue
+			}
+			processed++
+			if it, ok := t.items[pk]; ok {
+				resp.Responses[n] = append(resp.Responses[n], it.clone())
+			}
+		}
+	}
+	return resp, nil
+}
+
+// keyCondition is one entry of the legacy KeyConditions parameter.
+type keyCondition struct {
+	AttributeValueList []*attr
+	ComparisonOperator string
+}
+
+// legacyKeyCondition converts KeyConditions to the condition they express.
+func legacyKeyCondition(kcs map[string]keyCondition) (cond, error) {
+	var c cond
+	names := make([]string, 0, len(kcs))
+	for n := range kcs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		kc := kcs[n]
+		want := 1
+		if kc.ComparisonOperator == "BETWEEN" {
+			want = 2
+		}
+		if len(kc.AttributeValueList) != want {
+			return nil, validationError("One or more parameter values were invalid: Invalid number of argument(s) for the %s ComparisonOperator", kc.ComparisonOperator)
+		}
+		path := pathOperand(docPath{{name: n}})
+		vals := kc.AttributeValueList
+		var kcond cond
+		switch kc.ComparisonOperator {
+		case "EQ":
+			kcond = cmpCond{op: "=", l: path, r: valueOperand{vals[0]}}
+		case "LE":
+			kcond = cmpCond{op: "<=", l: path, r: valueOperand{vals[0]}}
+		case "LT":
+			kcond = cmpCond{op: "<", l: path, r: valueOperand{vals[0]}}
+		case "GE":
+			kcond = cmpCond{op: ">=", l: path, r: valueOperand{vals[0]}}
+		case "GT":
+			kcond = cmpCond{op: ">", l: path, r: valueOperand{vals[0]}}
+		case "BEGINS_WITH":
+			kcond = funcCond{name: "begins_with", args: []operand{path, valueOperand{vals[0]}}}
+		case "BETWEEN":
+			kcond = betweenCond{path, valueOperand{vals[0]}, valueOperand{vals[1]}}
+		default:
+			return nil, validationError("Unsupported operator on KeyCondition: %s", kc.ComparisonOperator)
+		}
+		if c == nil {
+			c = kcond
+		} else {
+			c = andCond{c, kcond}
+		}
+	}
+	return c, nil
+}
+
+func (db *DB) query(body []byte) (interface{}, error) {
+	req := struct {
+		TableName                 string
+		IndexName                 string
+		KeyConditions             map[string]keyCondition
+		KeyConditionExpression    string
+		FilterExpression          string
+		ExpressionAttributeNames  map[string]string
+		ExpressionAttributeValues map[string]*attr
+		ExclusiveStartKey         item
+		Limit                     int
+		ScanIndexForward          *bool
+		Select                    string
+	}{}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+	t, err := db.table(req.TableName)
+	if err != nil {
+		return nil, err
+	}
+	ks := t.key
+	var items []item
+	var ix *index
+	if req.IndexName != "" {
+		var ok bool
+		if ix, ok = t.indexes[req.IndexName]; !ok {
+			return nil, validationError("The table does not have the specified index: %s", req.IndexName)
+		}
+		ks = ix.key
+		items = t.indexItems(ix)
+	} else {
+		for _, it := range t.items {
+			items = append(items, it)
+		}
+	}
+
+	var keyCond, filter cond
+	var parsers []*exprParser
+	switch {
+	case req.KeyConditionExpression != "" && req.KeyConditions != nil:
+		return nil, validationError("Can not use both expression and non-expression parameters in the same request")
+	case req.KeyConditionExpression != "":
+		c, p, err := parseCondition(req.KeyConditionExpression, req.ExpressionAttributeNames, req.ExpressionAttributeValues)
+		if err != nil {
+			return nil, validationError("Invalid KeyConditionExpression: %v", err)
+		}
+		keyCond = c
+		parsers = append(parsers, p)
+	case req.KeyConditions != nil:
+		kc, ok := req.KeyConditions[ks.hash]
+		if !ok || kc.ComparisonOperator != "EQ" {
+			return nil, validationError("Query condition missed key schema element: %s", ks.hash)
+		}
+		if keyCond, err = legacyKeyCondition(req.KeyConditions); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, validationError("Either the KeyConditions or KeyConditionExpression parameter must be specified in the request.")
+	}
+	if req.FilterExpression != "" {
+		c, p, err := parseCondition(req.FilterExpression, req.ExpressionAttributeNames, req.ExpressionAttributeValues)
+		if err != nil {
+			return nil, validationError("Invalid FilterExpression: %v", err)
+		}
+		filter = c
+		parsers = append(parsers, p)
+	}
+	if err := checkUnused(req.ExpressionAttributeNames, req.ExpressionAttributeValues, parsers...); err != nil {
+		return nil, validationError("%v", err)
+	}
+
+	var matched []item
+	for _, it := range items {
+		ok, err := keyCond.match(it)
+		if err != nil {
+			return nil, validationError("%v", err)
+		}
+		if ok {
+			matched = append(matched, it)
+		}
+	}
+
+	// Order by the range key, then by the table's primary key so that items
+	// with equal index keys have a stable position for pagination.
+	less := func(a, b item) bool {
+		if ks.rng != "" {
+			if c, ok := compare(a[ks.rng], b[ks.rng]); ok && c != 0 {
+				return c < 0
+			}
+		}
+		return a.keyString(t.key.names()...) < b.keyString(t.key.names()...)
+	}
+	forward := req.ScanIndexForward == nil || *req.ScanIndexForward
+	sort.Slice(matched, func(i, j int) bool {
+		if forward {
+			return less(matched[i], matched[j])
+		}
+		return less(matched[j], matched[i])
+	})
+
+	keyNames := t.key.names()
+	if ix != nil {
+		keyNames = append(keyNames, ix.key.names()...)
+	}
+	if req.ExclusiveStartKey != nil {
+		for _, n := range keyNames {
+			if req.ExclusiveStartKey[n] == nil {
+				return nil, validationError("The provided starting key is invalid: The provided key element does not match the schema")
+			}
+		}
+		start := 0
+		for start < len(matched) {
+			after := less(req.ExclusiveStartKey, matched[start])
+			if !forward {
+				after = less(matched[start], req.ExclusiveStartKey)
+			}
+			if after {
+				break
+			}
+			start++
+		}
+		matched = matched[start:]
+	}
+
+	resp := struct {
+		Count            int
+		ScannedCount     int
+		Items            []item
+		LastEvaluatedKey item `json:",omitempty"`
+	}{}
+	if req.Limit > 0 && len(matched) >= req.Limit {
+		matched = matched[:req.Limit]
+		resp.LastEvaluatedKey = matched[len(matched)-1].project(keyNames)
+	}
+	resp.ScannedCount = len(matched)
+	for _, it := range matched {
+		if filter != nil {
+			ok, err := filter.match(it)
+			if err != nil {
+				return nil, validationError("%v", err)
+			}
+			if !ok {
+				continue
+			}
+		}
+		resp.Count++
+		if req.Select != "COUNT" {
+			resp.Items = append(resp.Items, projectIndex(t, ix, it))
+		}
+	}
+	if req.Select != "COUNT" && resp.Items == nil {
+		resp.Items = []item{}
+	}
+	return resp, nil
+}
+
+// projectIndex returns the attributes of it that an index projects.
+func projectIndex(t *table, ix *index, it item) item {
+	if ix == nil || ix.def.Projection.ProjectionType == "ALL" {
+		return it.clone()
+	}
+	names := append(t.key.names(), ix.key.names()...)
+	if ix.def.Projection.ProjectionType == "INCLUDE" {
+		names = append(names, ix.def.Projection.NonKeyAttributes...)
+	}
+	return it.project(names)
+}
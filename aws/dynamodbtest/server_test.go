@@ -0,0 +1,177 @@
+package dynamodbtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func call(t *testing.T, db *DB, op, body string, resp interface{}) error {
+	out, err := operations[op](db, []byte(body))
+	if err != nil {
+		return err
+	}
+	if resp != nil {
+		b, _ := json.Marshal(out)
+		if err := json.Unmarshal(b, resp); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	return nil
+}
+
+func newTestDB(t *testing.T) *DB {
+	db := New()
+	err := call(t, db, "CreateTable", `{
+  "TableName": "Post",
+  "AttributeDefinitions": [
+    { "AttributeName": "I", "AttributeType": "S" },
+    { "AttributeName": "K", "AttributeType": "B" },
+    { "AttributeName": "S", "AttributeType": "N" } ],
+  "KeySchema": [
+    { "AttributeName": "I", "KeyType": "HASH" },
+    { "AttributeName": "K", "KeyType": "RANGE" } ],
+  "GlobalSecondaryIndexes":[{
+      "IndexName": "Score",
+      "KeySchema": [
+        { "AttributeName": "I", "KeyType": "HASH" },
+        { "AttributeName": "S", "KeyType": "RANGE" } ],
+      "Projection": { "ProjectionType": "ALL" }
+  }]
+}`, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return db
+}
+
+func TestConditionalPut(t *testing.T) {
+	db := newTestDB(t)
+	put := `{"TableName":"Post","Item":{"I":{"S":"gif"},"K":{"B":"AQ=="},"S":{"N":"0"}},
+	  "ConditionExpression":"I <> :i and K <> :k",
+	  "ExpressionAttributeValues":{":i":{"S":"gif"},":k":{"B":"AQ=="}}}`
+	if err := call(t, db, "PutItem", put, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	err := call(t, db, "PutItem", put, nil)
+	if derr, ok := err.(*ddbError); !ok || derr.Type != "ConditionalCheckFailedException" {
+		t.Fatalf("%v", err)
+	}
+}
+
+func TestUpdateAdd(t *testing.T) {
+	db := newTestDB(t)
+	resp := struct {
+		Attributes struct{ S struct{ N string } }
+	}{}
+	for i := 0; i < 2; i++ {
+		err := call(t, db, "UpdateItem", `{"TableName":"Post","Key":{"I":{"S":"gif"},"K":{"B":"AQ=="}},
+		  "UpdateExpression":"ADD S :s","ExpressionAttributeValues":{":s":{"N":"2"}},"ReturnValues":"ALL_NEW"}`, &resp)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	if resp.Attributes.S.N != "4" {
+		t.Fatalf("%+v", resp)
+	}
+}
+
+func TestQueryIndexPaginate(t *testing.T) {
+	db := newTestDB(t)
+	for i := 0; i < 5; i++ {
+		err := call(t, db, "PutItem", fmt.Sprintf(`{"TableName":"Post","Item":{"I":{"S":"gif"},"K":{"B":"%s"},"S":{"N":"%d"}}}`,
+			[]string{"AQ==", "Ag==", "Aw==", "BA==", "BQ=="}[i], i%3), nil)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	var scores []string
+	var esk json.RawMessage
+	for {
+		body := `{"TableName":"Post","IndexName":"Score","Limit":2,"ScanIndexForward":false,
+		  "KeyConditions":{"I":{"AttributeValueList":[{"S":"gif"}],"ComparisonOperator":"EQ"}}`
+		if esk != nil {
+			body += `,"ExclusiveStartKey":` + string(esk)
+		}
+		resp := struct {
+			Items            []struct{ S struct{ N string } }
+			LastEvaluatedKey json.RawMessage
+		}{}
+		if err := call(t, db, "Query", body+"}", &resp); err != nil {
+			t.Fatalf("%v", err)
+		}
+		for _, it := range resp.Items {
+			scores = append(scores, it.S.N)
+		}
+		if resp.LastEvaluatedKey == nil {
+			break
+		}
+		esk = resp.LastEvaluatedKey
+	}
+	if fmt.Sprint(scores) != "[2 1 1 0 0]" {
+		t.Fatalf("%v", scores)
+	}
+}
+
+func TestTransactWriteCanceled(t *testing.T) {
+	db := newTestDB(t)
+	put := `{"Put":{"TableName":"Post","Item":{"I":{"S":"gif"},"K":{"B":"AQ=="},"S":{"N":"0"}},
+	  "ConditionExpression":"attribute_not_exists(K)"}}`
+	update := `{"Update":{"TableName":"Post","Key":{"I":{"S":"gif"},"K":{"B":"Ag=="}},
+	  "UpdateExpression":"ADD S :s","ConditionExpression":"attribute_exists(K)",
+	  "ExpressionAttributeValues":{":s":{"N":"1"}}}}`
+
+	// The update fails, so the put must not happen either.
+	err := call(t, db, "TransactWriteItems", `{"TransactItems":[`+put+`,`+update+`]}`, nil)
+	derr, ok := err.(*ddbError)
+	if !ok || derr.Type != "TransactionCanceledException" {
+		t.Fatalf("%v", err)
+	}
+	if fmt.Sprint(derr.CancellationReasons) != "[{None } {ConditionalCheckFailed The conditional request failed}]" {
+		t.Fatalf("%v", derr.CancellationReasons)
+	}
+	resp := struct{ Item map[string]interface{} }{}
+	call(t, db, "GetItem", `{"TableName":"Post","Key":{"I":{"S":"gif"},"K":{"B":"AQ=="}}}`, &resp)
+	if resp.Item != nil {
+		t.Fatalf("%v", resp.Item)
+	}
+
+	if err := call(t, db, "TransactWriteItems", `{"TransactItems":[`+put+`]}`, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	call(t, db, "GetItem", `{"TableName":"Post","Key":{"I":{"S":"gif"},"K":{"B":"AQ=="}}}`, &resp)
+	if resp.Item == nil {
+		t.Fatalf("put was not applied")
+	}
+}
+
+func TestTransactWriteValidationError(t *testing.T) {
+	db := newTestDB(t)
+	if err := call(t, db, "PutItem", `{"TableName":"Post","Item":{"I":{"S":"gif"},"K":{"B":"AQ=="},"S":{"N":"0"}}}`, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	update := `{"TableName":"Post","Key":{"I":{"S":"gif"},"K":{"B":"AQ=="}},
+	  "UpdateExpression":"ADD RE.#r :one","ExpressionAttributeNames":{"#r":"x"},
+	  "ExpressionAttributeValues":{":one":{"N":"1"}}}`
+
+	// Alone, the update of a map the item lacks fails the request.
+	err := call(t, db, "UpdateItem", update, nil)
+	if derr, ok := err.(*ddbError); !ok || derr.Type != "ValidationException" {
+		t.Fatalf("%v", err)
+	}
+	// In a transaction, it cancels it.
+	put := `{"Put":{"TableName":"Post","Item":{"I":{"S":"gif"},"K":{"B":"Ag=="},"S":{"N":"0"}}}}`
+	err = call(t, db, "TransactWriteItems", `{"TransactItems":[`+put+`,{"Update":`+update+`}]}`, nil)
+	derr, ok := err.(*ddbError)
+	if !ok || derr.Type != "TransactionCanceledException" {
+		t.Fatalf("%v", err)
+	}
+	if len(derr.CancellationReasons) != 2 || derr.CancellationReasons[0].Code != "None" || derr.CancellationReasons[1].Code != "ValidationError" {
+		t.Fatalf("%v", derr.CancellationReasons)
+	}
+	resp := struct{ Item map[string]interface{} }{}
+	call(t, db, "GetItem", `{"TableName":"Post","Key":{"I":{"S":"gif"},"K":{"B":"Ag=="}}}`, &resp)
+	if resp.Item != nil {
+		t.Fatalf("%v", resp.Item)
+	}
+}
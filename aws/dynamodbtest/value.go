@@ -0,0 +1,321 @@
+package dynamodbtest
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// attr is a DynamoDB AttributeValue in its JSON wire form.
+type attr struct {
+	B    []byte           `json:",omitempty"`
+	BOOL *bool            `json:",omitempty"`
+	BS   [][]byte         `json:",omitempty"`
+	L    []*attr          `json:",omitempty"`
+	M    map[string]*attr `json:",omitempty"`
+	N    *string          `json:",omitempty"`
+	NS   []string         `json:",omitempty"`
+	NULL *bool            `json:",omitempty"`
+	S    *string          `json:",omitempty"`
+	SS   []string         `json:",omitempty"`
+}
+
+// item is a DynamoDB item, or a key.
+type item map[string]*attr
+
+func (a *attr) typ() string {
+	switch {
+	case a == nil:
+		return ""
+	case a.S != nil:
+		return "S"
+	case a.N != nil:
+		return "N"
+	case a.B != nil:
+		return "B"
+	case a.BOOL != nil:
+		return "BOOL"
+	case a.NULL != nil:
+		return "NULL"
+	case a.SS != nil:
+		return "SS"
+	case a.NS != nil:
+		return "NS"
+	case a.BS != nil:
+		return "BS"
+	case a.L != nil:
+		return "L"
+	case a.M != nil:
+		return "M"
+	}
+	return ""
+}
+
+func strAttr(s string) *attr { return &attr{S: &s} }
+
+func numAttr(r *big.Rat) *attr {
+	s := formatNum(r)
+	return &attr{N: &s}
+}
+
+func parseNum(s string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return nil, fmt.Errorf("invalid number %q", s)
+	}
+	return r, nil
+}
+
+func formatNum(r *big.Rat) string {
+	if r.IsInt() {
+		return r.Num().String()
+	}
+	s := r.FloatString(38)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// compare orders two scalar attributes of the same type. ok is false when the
+// attributes are not comparable.
+func compare(a, b *attr) (c int, ok bool) {
+	if a.typ() != b.typ() {
+		return 0, false
+	}
+	switch a.typ() {
+	case "S":
+		return strings.Compare(*a.S, *b.S), true
+	case "B":
+		return bytes.Compare(a.B, b.B), true
+	case "N":
+		ra, err := parseNum(*a.N)
+		if err != nil {
+			return 0, false
+		}
+		rb, err := parseNum(*b.N)
+		if err != nil {
+			return 0, false
+		}
+		return ra.Cmp(rb), true
+	}
+	return 0, false
+}
+
+// equal reports whether two attributes hold the same value.
+func equal(a, b *attr) bool {
+	if a.typ() != b.typ() {
+		return false
+	}
+	switch a.typ() {
+	case "S", "B", "N":
+		c, ok := compare(a, b)
+		return ok && c == 0
+	case "BOOL":
+		return *a.BOOL == *b.BOOL
+	case "NULL":
+		return true
+	case "SS", "NS", "BS":
+		ka, kb := setKeys(a), setKeys(b)
+		if len(ka) != len(kb) {
+			return false
+		}
+		for k := range ka {
+			if !kb[k] {
+				return false
+			}
+		}
+		return true
+	case "L":
+		if len(a.L) != len(b.L) {
+			return false
+		}
+		for i := range a.L {
+			if !equal(a.L[i], b.L[i]) {
+				return false
+			}
+		}
+		return true
+	case "M":
+		if len(a.M) != len(b.M) {
+			return false
+		}
+		for k, v := range a.M {
+			if !equal(v, b.M[k]) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// setKeys returns the members of a set attribute in a comparable form.
+func setKeys(a *attr) map[string]bool {
+	m := make(map[string]bool)
+	switch a.typ() {
+	case "SS":
+		for _, s := range a.SS {
+			m[s] = true
+		}
+	case "BS":
+		for _, b := range a.BS {
+			m[string(b)] = true
+		}
+	case "NS":
+		for _, n := range a.NS {
+			if r, err := parseNum(n); err == nil {
+				m[r.RatString()] = true
+			}
+		}
+	}
+	return m
+}
+
+// setUnion adds the members of b to the set a, returning a new set.
+func setUnion(a, b *attr) *attr {
+	out := &attr{}
+	has := setKeys(a)
+	switch b.typ() {
+	case "SS":
+		out.SS = append([]string{}, a.SS...)
+		for _, s := range b.SS {
+			if !has[s] {
+				out.SS = append(out.SS, s)
+				has[s] = true
+			}
+		}
+	case "BS":
+		out.BS = append([][]byte{}, a.BS...)
+		for _, v := range b.BS {
+			if !has[string(v)] {
+				out.BS = append(out.BS, v)
+				has[string(v)] = true
+			}
+		}
+	case "NS":
+		out.NS = append([]string{}, a.NS...)
+		for _, n := range b.NS {
+			r, _ := parseNum(n)
+			if r != nil && !has[r.RatString()] {
+				out.NS = append(out.NS, n)
+				has[r.RatString()] = true
+			}
+		}
+	}
+	return out
+}
+
+// setDifference removes the members of b from the set a. It returns nil if
+// the result is empty, since DynamoDB does not store empty sets.
+func setDifference(a, b *attr) *attr {
+	drop := setKeys(b)
+	out := &attr{}
+	switch a.typ() {
+	case "SS":
+		for _, s := range a.SS {
+			if !drop[s] {
+				out.SS = append(out.SS, s)
+			}
+		}
+	case "BS":
+		for _, v := range a.BS {
+			if !drop[string(v)] {
+				out.BS = append(out.BS, v)
+			}
+		}
+	case "NS":
+		for _, n := range a.NS {
+			r, _ := parseNum(n)
+			if r == nil || !drop[r.RatString()] {
+				out.NS = append(out.NS, n)
+			}
+		}
+	}
+	if out.typ() == "" {
+		return nil
+	}
+	return out
+}
+
+// clone deep copies an attribute so stored items never alias request data.
+func (a *attr) clone() *attr {
+	if a == nil {
+		return nil
+	}
+	c := *a
+	if a.B != nil {
+		c.B = append([]byte{}, a.B...)
+	}
+	if a.SS != nil {
+		c.SS = append([]string{}, a.SS...)
+	}
+	if a.NS != nil {
+		c.NS = append([]string{}, a.NS...)
+	}
+	if a.BS != nil {
+		c.BS = make([][]byte, len(a.BS))
+		for i, b := range a.BS {
+			c.BS[i] = append([]byte{}, b...)
+		}
+	}
+	if a.L != nil {
+		c.L = make([]*attr, len(a.L))
+		for i, v := range a.L {
+			c.L[i] = v.clone()
+		}
+	}
+	if a.M != nil {
+		c.M = make(map[string]*attr, len(a.M))
+		for k, v := range a.M {
+			c.M[k] = v.clone()
+		}
+	}
+	return &c
+}
+
+func (it item) clone() item {
+	if it == nil {
+		return nil
+	}
+	c := make(item, len(it))
+	for k, v := range it {
+		c[k] = v.clone()
+	}
+	return c
+}
+
+// project returns a copy of it restricted to the named attributes.
+func (it item) project(names []string) item {
+	c := make(item, len(names))
+	for _, n := range names {
+		if v, ok := it[n]; ok {
+			c[n] = v.clone()
+		}
+	}
+	return c
+}
+
+// keyString encodes the values of the named attributes into a string that
+// uniquely identifies them.
+func (it item) keyString(names ...string) string {
+	var b bytes.Buffer
+	for _, n := range names {
+		if n == "" {
+			continue
+		}
+		v := it[n]
+		var s string
+		switch v.typ() {
+		case "S":
+			s = *v.S
+		case "B":
+			s = string(v.B)
+		case "N":
+			if r, _ := parseNum(*v.N); r != nil {
+				s = r.RatString()
+			}
+		}
+		fmt.Fprintf(&b, "%s%d:%s", v.typ(), len(s), s)
+	}
+	return b.String()
+}
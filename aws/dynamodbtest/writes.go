@@ -0,0 +1,375 @@
+package dynamodbtest
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// expressionRequest holds the expression fields shared by item operations.
+type expressionRequest struct {
+	ConditionExpression       string
+	ExpressionAttributeNames  map[string]string
+	ExpressionAttributeValues map[string]*attr
+}
+
+// condition parses the request's ConditionExpression, which may be empty.
+func (r expressionRequest) condition() (cond, *exprParser, error) {
+	if r.ConditionExpression == "" {
+		return nil, nil, nil
+	}
+	c, p, err := parseCondition(r.ConditionExpression, r.ExpressionAttributeNames, r.ExpressionAttributeValues)
+	if err != nil {
+		return nil, nil, validationError("Invalid ConditionExpression: %v", err)
+	}
+	return c, p, nil
+}
+
+// checkCondition evaluates c against the current item, which may be nil.
+func checkCondition(c cond, cur item) error {
+	if c == nil {
+		return nil
+	}
+	if cur == nil {
+		cur = item{}
+	}
+	ok, err := c.match(cur)
+	if err != nil {
+		return validationError("%v", err)
+	}
+	if !ok {
+		return conditionFailedError()
+	}
+	return nil
+}
+
+// write is a validated change to one item. It is prepared before being
+// committed so that a transaction can check all its conditions first.
+type write struct {
+	t   *table
+	pk  string
+	old item
+	new item // nil deletes the item
+	// cond is checked against old before committing.
+	cond cond
+	// updated names the attributes an update expression touches.
+	updated map[string]bool
+	// err is a validation error that depends on the item, like an update of
+	// a path it lacks. Transactions report it as a cancellation reason.
+	err error
+}
+
+func (w *write) check() error {
+	if err := checkCondition(w.cond, w.old); err != nil {
+		return err
+	}
+	return w.err
+}
+
+func (w *write) commit() {
+	if w.new == nil {
+		delete(w.t.items, w.pk)
+		return
+	}
+	w.t.items[w.pk] = w.new
+}
+
+type putRequest struct {
+	TableName    string
+	Item         item
+	ReturnValues string
+	expressionRequest
+}
+
+func (db *DB) preparePut(req putRequest) (*write, error) {
+	t, err := db.table(req.TableName)
+	if err != nil {
+		return nil, err
+	}
+	c, p, err := req.condition()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkUnused(req.ExpressionAttributeNames, req.ExpressionAttributeValues, p); err != nil {
+		return nil, validationError("%v", err)
+	}
+	if err := t.checkItem(req.Item); err != nil {
+		return nil, err
+	}
+	pk := req.Item.keyString(t.key.names()...)
+	return &write{t: t, pk: pk, old: t.items[pk], new: req.Item.clone(), cond: c}, nil
+}
+
+type deleteRequest struct {
+	TableName    string
+	Key          item
+	ReturnValues string
+	expressionRequest
+}
+
+func (db *DB) prepareDelete(req deleteRequest) (*write, error) {
+	t, err := db.table(req.TableName)
+	if err != nil {
+		return nil, err
+	}
+	pk, err := t.primaryKey(req.Key)
+	if err != nil {
+		return nil, err
+	}
+	c, p, err := req.condition()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkUnused(req.ExpressionAttributeNames, req.ExpressionAttributeValues, p); err != nil {
+		return nil, validationError("%v", err)
+	}
+	return &write{t: t, pk: pk, old: t.items[pk], cond: c}, nil
+}
+
+type updateRequest struct {
+	TableName        string
+	Key              item
+	UpdateExpression string
+	ReturnValues     string
+	expressionRequest
+}
+
+func (db *DB) prepareUpdate(req updateRequest) (*write, error) {
+	t, err := db.table(req.TableName)
+	if err != nil {
+		return nil, err
+	}
+	pk, err := t.primaryKey(req.Key)
+	if err != nil {
+		return nil, err
+	}
+	c, cp, err := req.condition()
+	if err != nil {
+		return nil, err
+	}
+	actions, up, err := parseUpdate(req.UpdateExpression, req.ExpressionAttributeNames, req.ExpressionAttributeValues)
+	if err != nil {
+		return nil, validationError("Invalid UpdateExpression: %v", err)
+	}
+	if err := checkUnused(req.ExpressionAttributeNames, req.ExpressionAttributeValues, cp, up); err != nil {
+		return nil, validationError("%v", err)
+	}
+	w := &write{t: t, pk: pk, old: t.items[pk], cond: c, updated: map[string]bool{}}
+	for _, a := range actions {
+		for _, n := range t.key.names() {
+			if a.path[0].name == n {
+				return nil, validationError("One or more parameter values were invalid: Cannot update attribute %s. This attribute is part of the key", n)
+			}
+		}
+		w.updated[a.path[0].name] = true
+	}
+	base := w.old
+	if base == nil {
+		base = req.Key
+	}
+	if w.new, err = applyUpdate(base, actions); err != nil {
+		w.new, w.err = w.old, validationError("%v", err)
+		return w, nil
+	}
+	if err := t.checkItem(w.new); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+type conditionCheckRequest struct {
+	TableName string
+	Key       item
+	expressionRequest
+}
+
+func (db *DB) prepareConditionCheck(req conditionCheckRequest) (*write, error) {
+	if req.ConditionExpression == "" {
+		return nil, validationError("ConditionCheck requires a ConditionExpression")
+	}
+	w, err := db.prepareDelete(deleteRequest{TableName: req.TableName, Key: req.Key, expressionRequest: req.expressionRequest})
+	if err != nil {
+		return nil, err
+	}
+	// A condition check leaves the item as it is.
+	w.new = w.old
+	return w, nil
+}
+
+// returnValues builds the Attributes of a write's response.
+func (w *write) returnValues(rv string) (interface{}, error) {
+	resp := struct {
+		Attributes item `json:",omitempty"`
+	}{}
+	onlyUpdated := func(src item) item {
+		out := item{}
+		for n := range w.updated {
+			if v, ok := src[n]; ok {
+				out[n] = v.clone()
+			}
+		}
+		return out
+	}
+	switch rv {
+	case "", "NONE":
+	case "ALL_OLD":
+		resp.Attributes = w.old.clone()
+	case "ALL_NEW", "UPDATED_OLD", "UPDATED_NEW":
+		if w.updated == nil {
+			return nil, validationError("ReturnValues can only be ALL_OLD or NONE")
+		}
+		switch rv {
+		case "ALL_NEW":
+			resp.Attributes = w.new.clone()
+		case "UPDATED_OLD":
+			resp.Attributes = onlyUpdated(w.old)
+		case "UPDATED_NEW":
+			resp.Attributes = onlyUpdated(w.new)
+		}
+	default:
+		return nil, validationError("invalid ReturnValues %q", rv)
+	}
+	return resp, nil
+}
+
+func (db *DB) putItem(body []byte) (interface{}, error) {
+	req := putRequest{}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+	w, err := db.preparePut(req)
+	if err != nil {
+		return nil, err
+	}
+	return db.commitOne(w, req.ReturnValues)
+}
+
+func (db *DB) deleteItem(body []byte) (interface{}, error) {
+	req := deleteRequest{}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+	w, err := db.prepareDelete(req)
+	if err != nil {
+		return nil, err
+	}
+	return db.commitOne(w, req.ReturnValues)
+}
+
+func (db *DB) updateItem(body []byte) (interface{}, error) {
+	req := updateRequest{}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+	w, err := db.prepareUpdate(req)
+	if err != nil {
+		return nil, err
+	}
+	return db.commitOne(w, req.ReturnValues)
+}
+
+func (db *DB) commitOne(w *write, rv string) (interface{}, error) {
+	resp, err := w.returnValues(rv)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.check(); err != nil {
+		return nil, err
+	}
+	w.commit()
+	return resp, nil
+}
+
+// cancellationReason is the outcome of one operation of a canceled
+// transaction.
+type cancellationReason struct {
+	Code    string
+	Message string `json:",omitempty"`
+}
+
+func (db *DB) transactWriteItems(body []byte) (interface{}, error) {
+	req := struct {
+		TransactItems []struct {
+			Put            *putRequest
+			Update         *updateRequest
+			Delete         *deleteRequest
+			ConditionCheck *conditionCheckRequest
+		}
+		ClientRequestToken string
+	}{}
+	if err := decode(body, &req); err != nil {
+		return nil, err
+	}
+	if len(req.TransactItems) == 0 || len(req.TransactItems) > 100 {
+		return nil, validationError("Member must have length less than or equal to 100 and greater than or equal to 1")
+	}
+	writes := make([]*write, len(req.TransactItems))
+	seen := map[string]bool{}
+	for i, ti := range req.TransactItems {
+		var w *write
+		var err error
+		n := 0
+		if ti.Put != nil {
+			w, err = db.preparePut(*ti.Put)
+			n++
+		}
+		if ti.Update != nil {
+			w, err = db.prepareUpdate(*ti.Update)
+			n++
+		}
+		if ti.Delete != nil {
+			w, err = db.prepareDelete(*ti.Delete)
+			n++
+		}
+		if ti.ConditionCheck != nil {
+			w, err = db.prepareConditionCheck(*ti.ConditionCheck)
+			n++
+		}
+		if n != 1 {
+			return nil, validationError("TransactItems can only contain one of Check, Put, Update or Delete")
+		}
+		if err != nil {
+			return nil, err
+		}
+		id := w.t.name + "\x00" + w.pk
+		if seen[id] {
+			return nil, validationError("Transaction request cannot include multiple operations on one item")
+		}
+		seen[id] = true
+		writes[i] = w
+	}
+
+	reasons := make([]cancellationReason, len(writes))
+	failed := false
+	for i, w := range writes {
+		reasons[i].Code = "None"
+		if err := w.check(); err != nil {
+			derr, ok := err.(*ddbError)
+			switch {
+			case ok && derr.Type == "ConditionalCheckFailedException":
+				reasons[i] = cancellationReason{Code: "ConditionalCheckFailed", Message: derr.Message}
+			case ok && derr.Type == "ValidationException":
+				// Like DynamoDB, which cancels the transaction rather than
+				// failing the request.
+				reasons[i] = cancellationReason{Code: "ValidationError", Message: derr.Message}
+			default:
+				return nil, err
+			}
+			failed = true
+		}
+	}
+	if failed {
+		codes := make([]string, len(reasons))
+		for i, r := range reasons {
+			codes[i] = r.Code
+		}
+		return nil, &ddbError{
+			Type:                "TransactionCanceledException",
+			Message:             "Transaction cancelled, please refer cancellation reasons for specific reasons [" + strings.Join(codes, ", ") + "]",
+			CancellationReasons: reasons,
+		}
+	}
+	for _, w := range writes {
+		w.commit()
+	}
+	return json.RawMessage("{}"), nil
+}
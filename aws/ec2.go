@@ -76,7 +76,7 @@ type InstanceNetworkInterfaceSetItemType struct {
 	NetworkInterfaceID    string                                  `xml:"networkInterfaceId"`
 	SubnetID              string                                  `xml:"subnetId"`
 	VPCID                 string                                  `xml:"vpcId"`
-	description           string                                  `xml:"description"`
+	Description           string                                  `xml:"description"`
 	OwnerID               string                                  `xml:"ownerId"`
 	Status                string                                  `xml:"status"`
 	MacAddress            string                                  `xml:"macAddress"`
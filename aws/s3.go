@@ -0,0 +1,90 @@
+package aws
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/smartystreets/go-aws-auth"
+)
+
+// ErrS3 is an error returned by S3.
+type ErrS3 struct {
+	XMLName    xml.Name `xml:"Error"`
+	Code       string
+	Message    string
+	StatusCode int `xml:"-"`
+}
+
+func (e *ErrS3) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+var s3Endpoint *url.URL
+
+// SetS3Endpoint points all S3 requests at rawurl, for example an in-process
+// fake from the s3test package. Buckets are addressed path-style, so any
+// S3-compatible server works.
+func SetS3Endpoint(rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+	s3Endpoint = u
+	return nil
+}
+
+// S3ObjectURL returns the path-style URL of an object.
+func S3ObjectURL(bucket, key string) string {
+	u := *s3Endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + bucket + "/" + key
+	return u.String()
+}
+
+// S3Put stores body as the object key in bucket.
+func S3Put(bucket, key, contentType string, body []byte) error {
+	req, err := http.NewRequest("PUT", S3ObjectURL(bucket, key), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	_, err = s3Do(req)
+	return err
+}
+
+// S3Delete deletes the object key in bucket. Deleting a missing object is
+// not an error.
+func S3Delete(bucket, key string) error {
+	req, err := http.NewRequest("DELETE", S3ObjectURL(bucket, key), nil)
+	if err != nil {
+		return err
+	}
+	_, err = s3Do(req)
+	return err
+}
+
+func s3Do(req *http.Request) ([]byte, error) {
+	awsauth.Sign4(req, Credentials())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		serr := &ErrS3{StatusCode: resp.StatusCode}
+		if err := xml.Unmarshal(body, serr); err != nil {
+			return nil, fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+		}
+		return nil, serr
+	}
+	return body, nil
+}
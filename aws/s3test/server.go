@@ -0,0 +1,128 @@
+// Package s3test provides an in-process stand-in for S3, so that code using
+// the aws package can store objects in tests without an S3-compatible server.
+//
+// Only path-style bucket and object PUT, GET, HEAD and DELETE are
+// implemented. Requests must carry a Signature Version 4 Authorization
+// header and a matching X-Amz-Content-Sha256, but the signature itself is
+// not checked. Objects are readable without a signature, as from a public
+// bucket.
+package s3test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+type object struct {
+	contentType string
+	data        []byte
+}
+
+// Server is an in-memory S3. The zero value is not usable, use New.
+type Server struct {
+	mu      sync.Mutex
+	buckets map[string]map[string]object
+}
+
+// New returns a Server without buckets.
+func New() *Server {
+	return &Server{buckets: map[string]map[string]object{}}
+}
+
+// NewServer starts an httptest.Server serving a Server with the given
+// buckets. The caller should call Close when finished.
+func NewServer(buckets ...string) *httptest.Server {
+	s := New()
+	for _, b := range buckets {
+		s.CreateBucket(b)
+	}
+	return httptest.NewServer(s)
+}
+
+// CreateBucket creates an empty bucket unless it already exists.
+func (s *Server) CreateBucket(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buckets[name] == nil {
+		s.buckets[name] = map[string]object{}
+	}
+}
+
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string
+	Message string
+	status  int
+}
+
+func writeError(w http.ResponseWriter, e s3Error) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(e.status)
+	xml.NewEncoder(w).Encode(e)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, s3Error{Code: "IncompleteBody", Message: err.Error(), status: http.StatusBadRequest})
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+			writeError(w, s3Error{Code: "AccessDenied", Message: "Access Denied", status: http.StatusForbidden})
+			return
+		}
+		sum := sha256.Sum256(body)
+		if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+			writeError(w, s3Error{Code: "XAmzContentSHA256Mismatch", Message: "The provided 'x-amz-content-sha256' header does not match what was computed.", status: http.StatusBadRequest})
+			return
+		}
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket := parts[0]
+	if len(parts) == 1 || parts[1] == "" {
+		if r.Method != "PUT" {
+			writeError(w, s3Error{Code: "NotImplemented", Message: r.Method + " on a bucket is not implemented", status: http.StatusNotImplemented})
+			return
+		}
+		s.CreateBucket(bucket)
+		return
+	}
+	key := parts[1]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects := s.buckets[bucket]
+	if objects == nil {
+		writeError(w, s3Error{Code: "NoSuchBucket", Message: "The specified bucket does not exist", status: http.StatusNotFound})
+		return
+	}
+	switch r.Method {
+	case "PUT":
+		objects[key] = object{contentType: r.Header.Get("Content-Type"), data: body}
+	case "GET", "HEAD":
+		o, ok := objects[key]
+		if !ok {
+			writeError(w, s3Error{Code: "NoSuchKey", Message: "The specified key does not exist.", status: http.StatusNotFound})
+			return
+		}
+		if o.contentType != "" {
+			w.Header().Set("Content-Type", o.contentType)
+		}
+		if r.Method == "GET" {
+			w.Write(o.data)
+		}
+	case "DELETE":
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, s3Error{Code: "MethodNotAllowed", Message: "The specified method is not allowed against this resource.", status: http.StatusMethodNotAllowed})
+	}
+}
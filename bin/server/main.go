@@ -7,21 +7,34 @@ import (
 
 	"github.com/golang/glog"
 
-	_ "github.com/cardinalblue/burstbooth"
+	"github.com/cardinalblue/burstbooth"
 )
 
 var (
-	port int
+	port  int
+	store string
 )
 
 func init() {
 	flag.IntVar(&port, "port", 8080, "port to bind to")
+	flag.StringVar(&store, "store", "ddb", `where to keep posts and votes, "ddb" or "memory"`)
 }
 
 func main() {
 	flag.Parse()
 
-	err := http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
+	var handler http.Handler
+	switch store {
+	case "ddb":
+	case "memory":
+		mux := http.NewServeMux()
+		burstbooth.NewServer(burstbooth.NewMemStore(), burstbooth.BlobStoreFromEnv()).Register(mux)
+		handler = mux
+	default:
+		glog.Fatalf("unknown store %q", store)
+	}
+
+	err := http.ListenAndServe(fmt.Sprintf(":%d", port), handler)
 	if err != nil {
 		glog.Fatalf("%v", err)
 	}
@@ -8,8 +8,18 @@ import (
 	"github.com/cardinalblue/burstbooth"
 )
 
+var backfillHot = flag.Bool("backfill_hot", false, "set the hot score of posts that have none instead of creating tables")
+
 func main() {
 	flag.Parse()
+	if *backfillHot {
+		n, err := burstbooth.BackfillDDBHot()
+		if err != nil {
+			glog.Fatalf(err.Error())
+		}
+		glog.Infof("set the hot score of %d posts", n)
+		return
+	}
 	err := burstbooth.CreateDDBTables()
 	if err != nil {
 		glog.Fatalf(err.Error())
@@ -0,0 +1,131 @@
+package burstbooth
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cardinalblue/burstbooth/aws"
+	"github.com/golang/glog"
+)
+
+// blobPath is where the server serves the blobs of stores that are also an
+// http.Handler.
+const blobPath = "/blob/"
+
+// BlobStore keeps uploaded images.
+type BlobStore interface {
+	// Put stores data under name and returns the URL it is served from. A
+	// URL starting with / is relative to the API server.
+	Put(name, contentType string, data []byte) (string, error)
+	// Delete deletes the blob name. Deleting a missing blob is not an error.
+	Delete(name string) error
+}
+
+// deletePostBlobs deletes the image of a post, if it was uploaded, and its
+// thumbnails from the blob store. Failures are logged, the post is gone
+// already.
+func (s *Server) deletePostBlobs(postType string, key []byte) {
+	name := postType + "/" + hex.EncodeToString(key)
+	names := []string{name + ".gif", name + ".jpg", name + "/poster.jpg"}
+	for _, w := range thumbWidths {
+		names = append(names, name+"/"+strconv.Itoa(w)+".jpg")
+	}
+	for _, n := range names {
+		if err := s.blobs.Delete(n); err != nil {
+			glog.Errorf("delete blob %s: %v", n, err)
+		}
+	}
+}
+
+// BlobStoreFromEnv returns an S3 BlobStore if BLOB_S3_BUCKET is set and a
+// filesystem BlobStore in BLOB_DIR otherwise.
+func BlobStoreFromEnv() BlobStore {
+	if bucket := os.Getenv("BLOB_S3_BUCKET"); bucket != "" {
+		return NewS3BlobStore(bucket, os.Getenv("BLOB_BASE_URL"))
+	}
+	dir := os.Getenv("BLOB_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "burstbooth")
+	}
+	return NewFSBlobStore(dir)
+}
+
+type fsBlobStore struct {
+	dir string
+}
+
+// NewFSBlobStore returns a BlobStore that keeps blobs as files under dir.
+// The blobs are served by the Server under /blob/.
+func NewFSBlobStore(dir string) BlobStore {
+	return &fsBlobStore{dir: dir}
+}
+
+func (s *fsBlobStore) Put(name, contentType string, data []byte) (string, error) {
+	path := filepath.Join(s.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	// Write to a temporary file first so that readers never see half a blob.
+	f, err := ioutil.TempFile(filepath.Dir(path), ".upload")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return blobPath + name, nil
+}
+
+func (s *fsBlobStore) Delete(name string) error {
+	err := os.Remove(filepath.Join(s.dir, filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *fsBlobStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	http.StripPrefix(blobPath, http.FileServer(http.Dir(s.dir))).ServeHTTP(w, r)
+}
+
+type s3BlobStore struct {
+	bucket  string
+	baseURL string
+}
+
+// NewS3BlobStore returns a BlobStore that keeps blobs in an S3 bucket. The
+// URL of a blob is baseURL followed by its name, for example to serve the
+// bucket through a CDN, or the object URL if baseURL is empty.
+func NewS3BlobStore(bucket, baseURL string) BlobStore {
+	return &s3BlobStore{bucket: bucket, baseURL: baseURL}
+}
+
+func (s *s3BlobStore) Put(name, contentType string, data []byte) (string, error) {
+	if err := aws.S3Put(s.bucket, name, contentType, data); err != nil {
+		return "", err
+	}
+	if s.baseURL == "" {
+		return aws.S3ObjectURL(s.bucket, name), nil
+	}
+	return strings.TrimSuffix(s.baseURL, "/") + "/" + name, nil
+}
+
+func (s *s3BlobStore) Delete(name string) error {
+	return aws.S3Delete(s.bucket, name)
+}
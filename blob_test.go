@@ -0,0 +1,144 @@
+package burstbooth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/cardinalblue/burstbooth/aws"
+	"github.com/cardinalblue/burstbooth/aws/s3test"
+	"github.com/cardinalblue/burstbooth/util"
+)
+
+func TestPostImgUpload(t *testing.T) {
+	ts := newMemTestServer()
+	defer ts.Close()
+
+	img := encodeTestGIF(4, 4, 1, 0)
+	p := PostDDB{}
+	resp, _, err := postImgFile(ts.URL, img, &p)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("%v %+v", err, resp)
+	}
+	if !strings.HasPrefix(p.URL.S, ts.URL+blobPath) || !strings.HasSuffix(p.URL.S, ".gif") {
+		t.Fatalf("%s", p.URL.S)
+	}
+	assertServed(t, p.URL.S, img)
+
+	resp, _, err = postImgFile(ts.URL, []byte("not an image"), nil)
+	if err != nil || resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Fatalf("%v %+v", err, resp)
+	}
+}
+
+func TestS3BlobStore(t *testing.T) {
+	s3 := s3test.NewServer("booth")
+	defer s3.Close()
+	if err := aws.SetS3Endpoint(s3.URL); err != nil {
+		t.Fatalf("%v", err)
+	}
+	mux := http.NewServeMux()
+	NewServer(NewMemStore(), NewS3BlobStore("booth", "")).Register(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	img := encodeTestGIF(4, 4, 1, 0)
+	p := PostDDB{}
+	resp, _, err := postImgFile(ts.URL, img, &p)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("%v %+v", err, resp)
+	}
+	if !strings.HasPrefix(p.URL.S, s3.URL+"/booth/gif/") {
+		t.Fatalf("%s", p.URL.S)
+	}
+	assertServed(t, p.URL.S, img)
+	name := strings.TrimPrefix(p.URL.S, s3.URL+"/booth/")
+	for i := 0; i < 2; i++ {
+		if err := NewS3BlobStore("booth", "").Delete(name); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	if resp, err := http.Get(p.URL.S); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("%v %+v", err, resp)
+	}
+
+	b, err := NewS3BlobStore("booth", "https://cdn.example.com/").Put("a.gif", "image/gif", img)
+	if err != nil || b != "https://cdn.example.com/a.gif" {
+		t.Fatalf("%v %s", err, b)
+	}
+	if _, err := NewS3BlobStore("nobucket", "").Put("a.gif", "image/gif", img); err == nil {
+		t.Fatalf("no error for a missing bucket")
+	}
+}
+
+func TestDeletePostBlobs(t *testing.T) {
+	s := NewServer(NewMemStore(), NewFSBlobStore(testBlobDir))
+	s.admins = []adminToken{{name: "alice", token: "a"}}
+	mux := http.NewServeMux()
+	s.Register(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	p := PostDDB{}
+	resp, _, err := postImgFile(ts.URL, encodeTestGIF(400, 400, 3, 10), &p)
+	if err != nil || resp.StatusCode != http.StatusOK || p.PF == nil || p.T == nil {
+		t.Fatalf("%v %+v %+v", err, resp, p)
+	}
+	urls := []string{p.URL.S, p.PF.S}
+	for _, u := range p.T.M {
+		urls = append(urls, u.S)
+	}
+	if len(urls) != 4 {
+		t.Fatalf("%v", urls)
+	}
+	v := url.Values{"key": {base64.StdEncoding.EncodeToString(p.K.B)}}
+	header := http.Header{"Authorization": {"Bearer a"}}
+	resp, _, err = util.JSONReq5("POST", ts.URL+"/admin/DeletePost?"+v.Encode(), nil, header, nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("%v %+v", err, resp)
+	}
+	for _, u := range urls {
+		resp, err := http.Get(u)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("%s: %d", u, resp.StatusCode)
+		}
+	}
+}
+
+func postImgFile(serverURL string, img []byte, res interface{}) (*http.Response, []byte, error) {
+	body := bytes.NewBuffer(nil)
+	mw := multipart.NewWriter(body)
+	fw, err := mw.CreateFormFile("img", "a.gif")
+	if err != nil {
+		return nil, nil, err
+	}
+	fw.Write(img)
+	mw.Close()
+	header := http.Header{"Content-Type": {mw.FormDataContentType()}}
+	return util.JSONReq5("POST", serverURL+"/PostImg", body, header, res)
+}
+
+func assertServed(t *testing.T, url string, want []byte) {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer resp.Body.Close()
+	got, err := ioutil.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != http.StatusOK || !bytes.Equal(got, want) {
+		t.Fatalf("%v %d %q", err, resp.StatusCode, got)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "image/gif" {
+		t.Fatalf("%s", ct)
+	}
+}
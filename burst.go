@@ -0,0 +1,293 @@
+package burstbooth
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxBurstUploadBytes bounds the size of a PostBurst request.
+	maxBurstUploadBytes = 4 * maxImgBytes
+	// maxBurstSide bounds the width and height of burst frames.
+	maxBurstSide = 1280
+	// maxBurstPixels bounds the area of all the frames of a burst together,
+	// which are decoded at once.
+	maxBurstPixels = 40 << 20
+	// defaultBurstDelay is how long a frame shows by default, in
+	// milliseconds.
+	defaultBurstDelay = 100
+	// maxBurstDelay bounds how long a frame shows, in milliseconds.
+	maxBurstDelay = 10000
+	// paletteSamples bounds how many pixels the adaptive palette is
+	// computed from.
+	paletteSamples = 1 << 16
+)
+
+// PostBurst assembles an ordered set of JPEG or PNG frames, uploaded as the
+// multipart files frame, into an animated GIF and posts it as a burst. All
+// frames must be the same size. Optional parameters:
+//   delay: milliseconds each frame shows, 100 by default
+//   delays: comma separated milliseconds per frame, instead of delay
+//   loop: times the animation plays, 0 for forever, the default
+//   palette: adaptive, computed from the frames, the default, plan9 or websafe
+//   dither: floydsteinberg, the default, or none
+//   caption: as in PostImg
+//   curl -F frame=@1.jpg -F frame=@2.jpg -F delay=150 http://localhost:8080/PostBurst
+func (s *Server) PostBurst(w http.ResponseWriter, r *http.Request) *appError {
+	if r.ContentLength > maxBurstUploadBytes {
+		return &appError{Message: "request too large", Code: http.StatusRequestEntityTooLarge}
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBurstUploadBytes)
+	if err := r.ParseMultipartForm(maxBurstUploadBytes); err != nil {
+		return &appError{Message: err.Error(), Code: http.StatusBadRequest}
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	files := r.MultipartForm.File["frame"]
+	if len(files) < minBurstFrames || len(files) > maxBurstFrames {
+		return &appError{Message: fmt.Sprintf("a burst needs %d to %d frames, not %d", minBurstFrames, maxBurstFrames, len(files)), Code: http.StatusBadRequest}
+	}
+	opts, appErr := burstOptionsFromForm(r, len(files))
+	if appErr != nil {
+		return appErr
+	}
+	frames, appErr := readBurstFrames(files)
+	if appErr != nil {
+		return appErr
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := gif.EncodeAll(buf, encodeBurst(frames, opts)); err != nil {
+		return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+	}
+	if buf.Len() > maxImgBytes {
+		return &appError{Message: errImgTooBig.Error(), Code: http.StatusRequestEntityTooLarge}
+	}
+	return s.createPost(w, r, postTypeBurst, buf.Bytes(), "")
+}
+
+// burstOptions controls how frames are encoded into a GIF.
+type burstOptions struct {
+	delays    []int // per frame, in hundredths of a second
+	loopCount int   // as in gif.GIF
+	palette   color.Palette
+	drawer    draw.Drawer
+}
+
+func burstOptionsFromForm(r *http.Request, frames int) (burstOptions, *appError) {
+	opts := burstOptions{}
+
+	var delays []string
+	if v := r.FormValue("delays"); v != "" {
+		delays = strings.Split(v, ",")
+		if len(delays) != frames {
+			return opts, &appError{Message: fmt.Sprintf("%d delays for %d frames", len(delays), frames), Code: http.StatusBadRequest}
+		}
+	} else {
+		delay := r.FormValue("delay")
+		if delay == "" {
+			delay = strconv.Itoa(defaultBurstDelay)
+		}
+		for i := 0; i < frames; i++ {
+			delays = append(delays, delay)
+		}
+	}
+	for _, d := range delays {
+		ms, err := strconv.Atoi(strings.TrimSpace(d))
+		if err != nil || ms < 10 || ms > maxBurstDelay {
+			return opts, &appError{Message: fmt.Sprintf("delay must be 10 to %d milliseconds", maxBurstDelay), Code: http.StatusBadRequest}
+		}
+		opts.delays = append(opts.delays, (ms+5)/10)
+	}
+
+	plays := 0
+	if v := r.FormValue("loop"); v != "" {
+		var err error
+		if plays, err = strconv.Atoi(v); err != nil || plays < 0 || plays > 0xffff {
+			return opts, &appError{Message: "loop must be 0 to 65535", Code: http.StatusBadRequest}
+		}
+	}
+	switch plays {
+	case 0:
+		opts.loopCount = 0
+	case 1:
+		opts.loopCount = -1
+	default:
+		opts.loopCount = plays - 1
+	}
+
+	switch r.FormValue("palette") {
+	case "", "adaptive":
+	case "plan9":
+		opts.palette = palette.Plan9
+	case "websafe":
+		opts.palette = palette.WebSafe
+	default:
+		return opts, &appError{Message: "palette must be adaptive, plan9 or websafe", Code: http.StatusBadRequest}
+	}
+
+	switch r.FormValue("dither") {
+	case "", "floydsteinberg":
+		opts.drawer = draw.FloydSteinberg
+	case "none":
+		opts.drawer = draw.Src
+	default:
+		return opts, &appError{Message: "dither must be floydsteinberg or none", Code: http.StatusBadRequest}
+	}
+	return opts, nil
+}
+
+// readBurstFrames decodes the uploaded frames of a burst. Their sizes are
+// checked before any is decoded.
+func readBurstFrames(files []*multipart.FileHeader) ([]image.Image, *appError) {
+	var datas [][]byte
+	var size image.Point
+	for i, fh := range files {
+		f, err := fh.Open()
+		if err != nil {
+			return nil, &appError{Message: err.Error(), Code: http.StatusBadRequest}
+		}
+		data, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, &appError{Message: err.Error(), Code: http.StatusBadRequest}
+		}
+		if len(data) > maxImgBytes {
+			return nil, &appError{Message: errImgTooBig.Error(), Code: http.StatusRequestEntityTooLarge}
+		}
+		if ct := http.DetectContentType(data); ct != "image/jpeg" && ct != "image/png" {
+			return nil, &appError{Message: fmt.Sprintf("frame %d is %s, not a JPEG or PNG image", i, ct), Code: http.StatusUnsupportedMediaType}
+		}
+		c, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, &appError{Message: fmt.Sprintf("frame %d: %v", i, err), Code: http.StatusUnsupportedMediaType}
+		}
+		if c.Width > maxBurstSide || c.Height > maxBurstSide {
+			return nil, &appError{Message: fmt.Sprintf("frames must be at most %d pixels wide and high", maxBurstSide), Code: http.StatusBadRequest}
+		}
+		if i == 0 {
+			size = image.Pt(c.Width, c.Height)
+			if len(files)*size.X*size.Y > maxBurstPixels {
+				return nil, &appError{Message: fmt.Sprintf("frames must be at most %d pixels together", maxBurstPixels), Code: http.StatusBadRequest}
+			}
+		} else if c.Width != size.X || c.Height != size.Y {
+			return nil, &appError{Message: fmt.Sprintf("frame %d is %v, not %v like frame 0", i, image.Pt(c.Width, c.Height), size), Code: http.StatusBadRequest}
+		}
+		datas = append(datas, data)
+	}
+
+	var frames []image.Image
+	for i, data := range datas {
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, &appError{Message: fmt.Sprintf("frame %d: %v", i, err), Code: http.StatusUnsupportedMediaType}
+		}
+		if img.Bounds().Size() != size {
+			return nil, &appError{Message: fmt.Sprintf("frame %d is %v, not %v like frame 0", i, img.Bounds().Size(), size), Code: http.StatusBadRequest}
+		}
+		frames = append(frames, img)
+	}
+	return frames, nil
+}
+
+// encodeBurst quantizes frames into the frames of an animated GIF.
+func encodeBurst(frames []image.Image, opts burstOptions) *gif.GIF {
+	pal := opts.palette
+	if pal == nil {
+		pal = medianCutPalette(frames, 256)
+	}
+	size := frames[0].Bounds().Size()
+	g := &gif.GIF{LoopCount: opts.loopCount}
+	for i, frame := range frames {
+		p := image.NewPaletted(image.Rectangle{Max: size}, pal)
+		opts.drawer.Draw(p, p.Bounds(), frame, frame.Bounds().Min)
+		g.Image = append(g.Image, p)
+		g.Delay = append(g.Delay, opts.delays[i])
+	}
+	g.Config.Width, g.Config.Height = size.X, size.Y
+	return g
+}
+
+// medianCutPalette returns a palette of at most n colors for imgs. It
+// repeatedly splits the box of colors with the widest channel at the median
+// of that channel, over a sample of the pixels of imgs.
+func medianCutPalette(imgs []image.Image, n int) color.Palette {
+	total := 0
+	for _, img := range imgs {
+		total += img.Bounds().Dx() * img.Bounds().Dy()
+	}
+	step := 1
+	for total/(step*step) > paletteSamples {
+		step++
+	}
+	var pixels [][3]uint8
+	for _, img := range imgs {
+		b := img.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y += step {
+			for x := b.Min.X; x < b.Max.X; x += step {
+				c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+				pixels = append(pixels, [3]uint8{c.R, c.G, c.B})
+			}
+		}
+	}
+
+	boxes := [][][3]uint8{pixels}
+	for len(boxes) < n {
+		// Split the box whose widest channel is widest.
+		best, bestChannel, bestRange := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			for ch := 0; ch < 3; ch++ {
+				lo, hi := uint8(255), uint8(0)
+				for _, p := range box {
+					if p[ch] < lo {
+						lo = p[ch]
+					}
+					if p[ch] > hi {
+						hi = p[ch]
+					}
+				}
+				if r := int(hi) - int(lo); r > bestRange {
+					best, bestChannel, bestRange = i, ch, r
+				}
+			}
+		}
+		if best < 0 {
+			// Every box holds a single color.
+			break
+		}
+		box := boxes[best]
+		sort.Slice(box, func(i, j int) bool { return box[i][bestChannel] < box[j][bestChannel] })
+		mid := len(box) / 2
+		boxes[best] = box[:mid]
+		boxes = append(boxes, box[mid:])
+	}
+
+	pal := make(color.Palette, 0, len(boxes))
+	for _, box := range boxes {
+		if len(box) == 0 {
+			continue
+		}
+		var r, g, b int
+		for _, p := range box {
+			r, g, b = r+int(p[0]), g+int(p[1]), b+int(p[2])
+		}
+		pal = append(pal, color.RGBA{uint8(r / len(box)), uint8(g / len(box)), uint8(b / len(box)), 0xff})
+	}
+	return pal
+}
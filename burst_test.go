@@ -0,0 +1,143 @@
+package burstbooth
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"testing"
+
+	"github.com/cardinalblue/burstbooth/util"
+)
+
+func TestPostBurst(t *testing.T) {
+	ts := newMemTestServer()
+	defer ts.Close()
+
+	colors := []color.RGBA{{0xff, 0, 0, 0xff}, {0, 0x80, 0, 0xff}, {0x10, 0x20, 0xf0, 0xff}}
+	var frames [][]byte
+	for _, c := range colors {
+		frames = append(frames, encodeTestPNG(6, 4, c))
+	}
+	fields := map[string]string{"delays": "100,200,300", "loop": "2", "caption": "hi"}
+	p := PostDDB{}
+	resp, _, err := postBurst(ts.URL, frames, fields, &p)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("%v %+v", err, resp)
+	}
+	if p.I.S != postTypeBurst || p.F.N != "3" || p.Ms.N != "600" || p.W.N != "6" || p.C.S != "hi" {
+		t.Fatalf("%+v", p)
+	}
+
+	gifResp, err := http.Get(p.URL.S)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	g, err := gif.DecodeAll(gifResp.Body)
+	gifResp.Body.Close()
+	if err != nil || g.LoopCount != 1 || len(g.Image) != 3 || g.Delay[1] != 20 {
+		t.Fatalf("%v %+v", err, g)
+	}
+	for i, c := range colors {
+		if got := color.RGBAModel.Convert(g.Image[i].At(3, 2)); got != c {
+			t.Fatalf("frame %d: %v, not %v", i, got, c)
+		}
+	}
+
+	imgs := FeedJSON{}
+	util.JSONReq3("GET", ts.URL+"/New?type=burst", &imgs)
+	if len(imgs.Posts) != 1 || imgs.Posts[0].URL.S != p.URL.S {
+		t.Fatalf("%+v", imgs)
+	}
+
+	var bigFrames [][]byte
+	for i := 0; i < maxBurstFrames; i++ {
+		bigFrames = append(bigFrames, resizeTestPNG(frames[0], maxBurstSide, maxBurstSide))
+	}
+	for _, c := range []struct {
+		frames [][]byte
+		fields map[string]string
+		code   int
+	}{
+		{frames[:1], nil, http.StatusBadRequest},
+		{[][]byte{frames[0], encodeTestPNG(4, 6, colors[0])}, nil, http.StatusBadRequest},
+		{[][]byte{frames[0], []byte("not an image")}, nil, http.StatusUnsupportedMediaType},
+		// Sizes are checked before decoding.
+		{[][]byte{frames[0], resizeTestPNG(frames[0], 2000, 4)}, nil, http.StatusBadRequest},
+		{bigFrames, nil, http.StatusBadRequest},
+		{frames, map[string]string{"delays": "100,200"}, http.StatusBadRequest},
+		{frames, map[string]string{"dither": "ordered"}, http.StatusBadRequest},
+		{frames, map[string]string{"palette": "plan9", "dither": "none", "loop": "1"}, http.StatusOK},
+	} {
+		resp, _, err := postBurst(ts.URL, c.frames, c.fields, nil)
+		if err != nil || resp.StatusCode != c.code {
+			t.Fatalf("%+v: %v %+v", c.fields, err, resp)
+		}
+	}
+}
+
+func TestMedianCutPalette(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for x := 0; x < 16; x++ {
+		for y := 0; y < 16; y++ {
+			img.Set(x, y, color.RGBA{uint8(x * 16), uint8(y * 16), 0, 0xff})
+		}
+	}
+	if pal := medianCutPalette([]image.Image{img}, 256); len(pal) != 256 {
+		t.Fatalf("%d colors", len(pal))
+	}
+	if pal := medianCutPalette([]image.Image{img}, 4); len(pal) != 4 {
+		t.Fatalf("%d colors", len(pal))
+	}
+	// There are never more colors than pixels have.
+	if pal := medianCutPalette([]image.Image{image.NewRGBA(image.Rect(0, 0, 4, 4))}, 256); len(pal) != 1 {
+		t.Fatalf("%d colors", len(pal))
+	}
+}
+
+func encodeTestPNG(width, height int, c color.Color) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, c)
+		}
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := png.Encode(buf, img); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// resizeTestPNG returns a copy of a PNG whose header claims it is width by
+// height. It does not decode.
+func resizeTestPNG(data []byte, width, height int) []byte {
+	b := append([]byte(nil), data...)
+	binary.BigEndian.PutUint32(b[16:], uint32(width))
+	binary.BigEndian.PutUint32(b[20:], uint32(height))
+	binary.BigEndian.PutUint32(b[29:], crc32.ChecksumIEEE(b[12:29]))
+	return b
+}
+
+func postBurst(serverURL string, frames [][]byte, fields map[string]string, res interface{}) (*http.Response, []byte, error) {
+	body := bytes.NewBuffer(nil)
+	mw := multipart.NewWriter(body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	for _, f := range frames {
+		fw, err := mw.CreateFormFile("frame", "frame.png")
+		if err != nil {
+			return nil, nil, err
+		}
+		fw.Write(f)
+	}
+	mw.Close()
+	header := http.Header{"Content-Type": {mw.FormDataContentType()}}
+	return util.JSONReq5("POST", serverURL+"/PostBurst", body, header, res)
+}
@@ -4,35 +4,46 @@ import (
 	"bytes"
 	"compress/gzip"
 	"encoding/base64"
-	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
-	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
-	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/golang/glog"
-
-	"github.com/cardinalblue/burstbooth/aws"
-)
-
-const (
-	postTypeGIF = "gif"
 )
 
 type PostDDB struct {
 	I   struct{ S string } // just an index
 	K   struct{ B []byte } // a unique key for this post
 	S   struct{ N string } // score
+	H   struct{ N string } // hot score, see hotRanker
 	URL struct{ S string } // url of the image
 
 	// Optional Attributes
-	C *struct{ S string } `json:",omitempty"` // caption
+	C  *struct{ S string } `json:",omitempty"` // caption
+	X  *struct{ S string } `json:",omitempty"` // moderation state, posts with one are left out of feeds
+	RC *struct{ N string } `json:",omitempty"` // number of reports, see ReportDDB
+	A  *struct{ B []byte } `json:",omitempty"` // device ID of the author, absent on posts made without one
+	CC *struct{ N string } `json:",omitempty"` // number of comments, see CommentDDB
+	RE *struct {
+		M map[string]struct{ N string }
+	} `json:",omitempty"` // number of reactions by name, see ReactionDDB
+	TG *struct{ SS []string } `json:",omitempty"` // hashtags of the caption, see TagDDB
+
+	// Image metadata, absent on posts made before it was recorded
+	W  *struct{ N string } `json:",omitempty"` // width in pixels
+	Ht *struct{ N string } `json:",omitempty"` // height in pixels
+	F  *struct{ N string } `json:",omitempty"` // number of frames
+	Ms *struct{ N string } `json:",omitempty"` // animation duration in milliseconds
+
+	// Thumbnails, absent on posts made before they were generated
+	PF *struct{ S string }                        `json:",omitempty"` // url of the poster frame
+	T  *struct{ M map[string]struct{ S string } } `json:",omitempty"` // urls of downscaled poster frames by width
 }
 
 func postPK(index string, key []byte) []byte {
@@ -41,15 +52,40 @@ func postPK(index string, key []byte) []byte {
 	return b
 }
 
+// splitPostPK returns the index and key that make up a postPK.
+func splitPostPK(pk []byte) (string, []byte) {
+	i := bytes.IndexByte(pk, '\x00')
+	if i < 0 {
+		return "", nil
+	}
+	return string(pk[:i]), pk[i+1:]
+}
+
 type PostJSON struct {
 	I   struct{ S string }
 	K   struct{ B []byte }
 	S   struct{ N string }
+	H   struct{ N string }
 	URL struct{ S string }
 
-	C struct{ S string }
+	C  struct{ S string }
+	CC struct{ N string } // number of comments, "0" if there are none
+	TG []string           // hashtags of the caption, without #
+
+	// Image metadata, all "0" if the post predates it
+	W  struct{ N string }
+	Ht struct{ N string }
+	F  struct{ N string }
+	Ms struct{ N string }
 
-	V bool
+	// Thumbnails, PF is "" and T empty if the post predates them
+	PF struct{ S string }
+	T  map[string]string // url of the poster frame downscaled to each width in thumbWidths
+
+	V int // the calling device's vote: 1, -1, or 0 if it has not voted
+
+	RE map[string]int // number of reactions by name, those nobody used left out
+	VR []string       // the calling device's reactions
 }
 
 func postDDBToJSON(p PostDDB) PostJSON {
@@ -57,342 +93,696 @@ func postDDBToJSON(p PostDDB) PostJSON {
 	pj.I = p.I
 	pj.K = p.K
 	pj.S = p.S
+	pj.H = p.H
 	pj.URL = p.URL
 	if p.C != nil {
 		pj.C.S = p.C.S
 	}
+	pj.CC.N = "0"
+	if p.CC != nil {
+		pj.CC.N = p.CC.N
+	}
+	pj.TG = []string{}
+	if p.TG != nil {
+		pj.TG = p.TG.SS
+	}
+	pj.W.N, pj.Ht.N, pj.F.N, pj.Ms.N = "0", "0", "0", "0"
+	if p.W != nil && p.Ht != nil && p.F != nil && p.Ms != nil {
+		pj.W.N, pj.Ht.N, pj.F.N, pj.Ms.N = p.W.N, p.Ht.N, p.F.N, p.Ms.N
+	}
+	if p.PF != nil {
+		pj.PF.S = p.PF.S
+	}
+	pj.T = map[string]string{}
+	if p.T != nil {
+		for w, u := range p.T.M {
+			pj.T[w] = u.S
+		}
+	}
+	pj.RE = reactionCounts(p)
+	pj.VR = deviceReactions(nil)
 	return pj
 }
 
-// postJSONByKDesc implements sort.Interface for PostJSON.
-type postJSONByKDesc []PostJSON
+type VoteDDB struct {
+	D struct{ B []byte } // device ID
+	P struct{ B []byte } // post ID
 
-func (a postJSONByKDesc) Len() int      { return len(a) }
-func (a postJSONByKDesc) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
-func (a postJSONByKDesc) Less(i, j int) bool {
-	is, _ := strconv.Atoi(a[i].S.N)
-	js, _ := strconv.Atoi(a[j].S.N)
-	if is != js {
-		return is > js
+	// Optional Attributes
+	V *struct{ N string } `json:",omitempty"` // vote value, 1 or -1; upvote if absent
+}
+
+// Value returns the direction of the vote, 1 or -1.
+func (v *VoteDDB) Value() int {
+	if v.V == nil {
+		// Votes cast before downvotes existed are upvotes.
+		return 1
 	}
-	return bytes.Compare(a[i].K.B, a[j].K.B) > 0
+	n, _ := strconv.Atoi(v.V.N)
+	return n
 }
 
-type VoteDDB struct {
-	D struct{ B []byte } // device ID
-	P struct{ B []byte } // post ID
+func newVoteDDB(deviceID, postPK []byte, value int) VoteDDB {
+	vote := VoteDDB{}
+	vote.D.B = deviceID
+	vote.P.B = postPK
+	vote.V = &struct{ N string }{N: strconv.Itoa(value)}
+	return vote
 }
 
-var (
-	ddbTablePost = os.Getenv("DDB_TABLE_POST")
-	ddbTableVote = os.Getenv("DDB_TABLE_VOTE")
-)
+var ddbTables = DDBTables{
+	Post:     os.Getenv("DDB_TABLE_POST"),
+	Vote:     os.Getenv("DDB_TABLE_VOTE"),
+	Report:   os.Getenv("DDB_TABLE_REPORT"),
+	Device:   os.Getenv("DDB_TABLE_DEVICE"),
+	Audit:    os.Getenv("DDB_TABLE_AUDIT"),
+	Comment:  os.Getenv("DDB_TABLE_COMMENT"),
+	Reaction: os.Getenv("DDB_TABLE_REACTION"),
+	Tag:      os.Getenv("DDB_TABLE_TAG"),
+	Word:     os.Getenv("DDB_TABLE_WORD"),
+
+	RateLimit: os.Getenv("DDB_TABLE_RATELIMIT"),
+}
 
 func init() {
-	jsonAPI("/PostImg", PostImg)
-	jsonAPI("/Hot", Hot)
-	jsonAPI("/Vote", Vote)
-	http.HandleFunc("/", root)
+	NewServer(NewDDBStore(ddbTables), BlobStoreFromEnv()).Register(http.DefaultServeMux)
+}
+
+// Server serves the JSON API on top of a Store.
+type Server struct {
+	store     Store
+	blobs     BlobStore
+	hot       hotRanker
+	cursors   cursorCodec
+	captions  *captionModerator
+	devices   deviceTokens
+	reports   reportPolicy
+	admins    []adminToken
+	limiter   *rateLimiter
+	reactions reactionSet
 }
 
-// PostImg posts an image URL to the server
-//   curl 'http://localhost:8080/PostImg?url=http%3A%2F%2F127.0.0.1%2Fa.jpg'
-func PostImg(w http.ResponseWriter, r *http.Request) *appError {
+// NewServer returns a Server that persists to store and keeps uploaded
+// images in blobs.
+func NewServer(store Store, blobs BlobStore) *Server {
+	return &Server{
+		store:     store,
+		blobs:     blobs,
+		hot:       defaultHotRanker,
+		cursors:   defaultCursorCodec,
+		captions:  defaultCaptionModerator,
+		devices:   defaultDeviceTokens,
+		reports:   defaultReportPolicy,
+		admins:    defaultAdminTokens,
+		limiter:   defaultRateLimiter,
+		reactions: defaultReactions,
+	}
+}
+
+// Register installs the API handlers on mux.
+func (s *Server) Register(mux *http.ServeMux) {
+	s.jsonAPI(mux, "/Register", s.RegisterDevice)
+	s.jsonAPI(mux, "/PostImg", s.PostImg)
+	s.jsonAPI(mux, "/PostBurst", s.PostBurst)
+	s.jsonAPI(mux, "/Hot", s.Hot)
+	s.jsonAPI(mux, "/New", s.New)
+	s.jsonAPI(mux, "/Tag", s.Tag)
+	s.jsonAPI(mux, "/Search", s.Search)
+	s.jsonAPI(mux, "/Post", s.Post)
+	s.jsonAPI(mux, "/Vote", s.Vote)
+	s.jsonAPI(mux, "/Unvote", s.Unvote)
+	s.jsonAPI(mux, "/React", s.React)
+	s.jsonAPI(mux, "/Unreact", s.Unreact)
+	s.jsonAPI(mux, "/Report", s.Report)
+	s.jsonAPI(mux, "/Comment", s.Comment)
+	s.jsonAPI(mux, "/Comments", s.Comments)
+	s.jsonAPI(mux, "/MyPosts", s.MyPosts)
+	s.jsonAPI(mux, "/DeleteMyPost", s.DeleteMyPost)
+	s.jsonAPI(mux, "/admin/Queue", s.adminAPI(s.Queue))
+	s.jsonAPI(mux, "/admin/DeletePost", s.adminAPI(s.DeletePost))
+	s.jsonAPI(mux, "/admin/EditCaption", s.adminAPI(s.EditCaption))
+	s.jsonAPI(mux, "/admin/Hide", s.adminAPI(s.Hide))
+	s.jsonAPI(mux, "/admin/Unhide", s.adminAPI(s.Unhide))
+	s.jsonAPI(mux, "/admin/ResetScore", s.adminAPI(s.ResetScore))
+	s.jsonAPI(mux, "/admin/Ban", s.adminAPI(s.Ban))
+	s.jsonAPI(mux, "/admin/Unban", s.adminAPI(s.Unban))
+	s.jsonAPI(mux, "/admin/Audit", s.adminAPI(s.Audit))
+	if h, ok := s.blobs.(http.Handler); ok {
+		mux.Handle(blobPath, h)
+	}
+	mux.HandleFunc("/", root)
+}
+
+// jsonAPI installs an API handler on mux behind the rate limits of its path.
+func (s *Server) jsonAPI(mux *http.ServeMux, path string, fn func(w http.ResponseWriter, r *http.Request) *appError) {
+	jsonAPI(mux, path, s.rateLimit(path, fn))
+}
+
+// PostImg posts an image to the server, either uploaded as the multipart
+// file img or as the URL of an image hosted elsewhere. Only GIF and JPEG
+// images are accepted. type is one of postTypes, gif by default.
+//   curl -F img=@a.gif http://localhost:8080/PostImg
+//   curl 'http://localhost:8080/PostImg?type=photo&url=http%3A%2F%2F127.0.0.1%2Fa.jpg'
+func (s *Server) PostImg(w http.ResponseWriter, r *http.Request) *appError {
+	data, uploaded, appErr := readImg(w, r)
+	if appErr != nil {
+		return appErr
+	}
+	postType, appErr := formPostType(r)
+	if appErr != nil {
+		return appErr
+	}
 	url := r.FormValue("url")
-	caption := r.FormValue("caption")
+	if uploaded {
+		url = ""
+	}
+	return s.createPost(w, r, postType, data, url)
+}
 
-	t := time.Now().UnixNano()
-	buf := bytes.NewBuffer([]byte{})
-	if err := binary.Write(buf, binary.BigEndian, t); err != nil {
+// createPost validates an image and posts it as postType, with the caption
+// form value of r after moderation. url is where the image is hosted, or
+// empty to keep data in the blob store.
+func (s *Server) createPost(w http.ResponseWriter, r *http.Request, postType string, data []byte, url string) *appError {
+	var deviceID string
+	var appErr *appError
+	if s.devices.mode == deviceAuthRequired {
+		// Anonymous posts would escape bans and per-device rate limits.
+		deviceID, appErr = s.requireDevice(r)
+	} else if deviceID, appErr = s.formDeviceID(r); appErr == nil && deviceID != "" {
+		appErr = s.checkDevice(deviceID)
+	}
+	if appErr != nil {
+		return appErr
+	}
+	key, err := postKey(time.Now())
+	if err != nil {
 		return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
 	}
+	meta, err := decodeImg(data)
+	if err == errImgTooLarge {
+		return &appError{Message: err.Error(), Code: http.StatusRequestEntityTooLarge}
+	}
+	if err != nil {
+		return &appError{Message: err.Error(), Code: http.StatusUnsupportedMediaType}
+	}
+	if err := postTypes[postType].validate(meta); err != nil {
+		return &appError{Message: err.Error(), Code: http.StatusBadRequest}
+	}
+	caption, held, err := s.captions.moderate(r.FormValue("caption"))
+	if err != nil {
+		return &appError{Message: err.Error(), Code: http.StatusBadRequest}
+	}
+	name := postType + "/" + hex.EncodeToString(key)
+	if url == "" {
+		u, err := s.blobs.Put(name+meta.Ext, meta.ContentType, data)
+		if err != nil {
+			glog.Errorf("%v", err)
+			return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+		}
+		url = blobURL(r, u)
+	}
+
 	post := PostDDB{}
-	post.I.S = postTypeGIF
-	post.K.B = buf.Bytes()
+	post.I.S = postType
+	post.K.B = key
 	post.S.N = "0"
+	post.H.N = s.hot.scoreN(0, key)
 	post.URL.S = url
 	if caption != "" {
 		post.C = &struct{ S string }{S: caption}
 	}
-	bodyj := struct {
-		TableName                 string
-		Item                      PostDDB
-		ConditionExpression       string
-		ExpressionAttributeValues struct {
-			I struct{ S string } `json:":i"`
-			K struct{ B []byte } `json:":k"`
-		}
-	}{}
-	bodyj.TableName = ddbTablePost
-	bodyj.Item = post
-	bodyj.ConditionExpression = "I <> :i and K <> :k"
-	bodyj.ExpressionAttributeValues.I.S = postTypeGIF
-	bodyj.ExpressionAttributeValues.K.B = post.K.B
-	if err := aws.DynamoDBPost("PutItem", bodyj, nil); err != nil {
+	if held {
+		post.X = &struct{ S string }{S: postStateHeld}
+	}
+	if deviceID != "" {
+		post.A = &struct{ B []byte }{B: []byte(deviceID)}
+	}
+	post.RE = &struct {
+		M map[string]struct{ N string }
+	}{M: map[string]struct{ N string }{}}
+	meta.setOn(&post)
+	if err := s.storeThumbs(r, name+"/", meta, &post); err != nil {
 		glog.Errorf("%v", err)
 		return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
 	}
+	if err := s.store.CreatePost(post); err != nil {
+		glog.Errorf("%v", err)
+		return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+	}
+	s.tagPost(&post, caption)
+	s.indexCaption(postPK(postType, key), "", caption)
 	json.NewEncoder(w).Encode(post)
 	return nil
 }
 
-// Hot returns the hottest images.
-//  curl http://localhost:8080/Hot?device_id=ddd
-func Hot(w http.ResponseWriter, r *http.Request) *appError {
-	var key []byte = nil
-	var score int
-	if keyStr := r.FormValue("key"); keyStr != "" {
-		k, err := base64.StdEncoding.DecodeString(r.FormValue("key"))
+// maxUploadBytes bounds the size of a PostImg request, leaving room for the
+// form around the image.
+const maxUploadBytes = maxImgBytes + 64<<10
+
+// readImg returns the image uploaded to PostImg, or else fetches the image
+// at its url. uploaded tells which.
+func readImg(w http.ResponseWriter, r *http.Request) (data []byte, uploaded bool, appErr *appError) {
+	if r.ContentLength > maxUploadBytes {
+		return nil, false, &appError{Message: errImgTooBig.Error(), Code: http.StatusRequestEntityTooLarge}
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+	err := r.ParseMultipartForm(maxUploadBytes)
+	if err != nil && err != http.ErrNotMultipart {
+		return nil, false, &appError{Message: err.Error(), Code: http.StatusBadRequest}
+	}
+	if err == nil {
+		f, _, err := r.FormFile("img")
+		if err != nil && err != http.ErrMissingFile {
+			return nil, false, &appError{Message: err.Error(), Code: http.StatusBadRequest}
+		}
+		if err == nil {
+			defer f.Close()
+			data, err := ioutil.ReadAll(f)
+			if err != nil {
+				return nil, false, &appError{Message: err.Error(), Code: http.StatusBadRequest}
+			}
+			if len(data) > maxImgBytes {
+				return nil, false, &appError{Message: errImgTooBig.Error(), Code: http.StatusRequestEntityTooLarge}
+			}
+			return data, true, nil
+		}
+	}
+
+	if r.FormValue("url") == "" {
+		return nil, false, &appError{Message: "no img or url", Code: http.StatusBadRequest}
+	}
+	data, err = fetchImg(r.FormValue("url"))
+	if err == errImgTooBig {
+		return nil, false, &appError{Message: err.Error(), Code: http.StatusRequestEntityTooLarge}
+	}
+	if err != nil {
+		return nil, false, &appError{Message: err.Error(), Code: http.StatusBadRequest}
+	}
+	return data, false, nil
+}
+
+// blobURL returns the URL clients fetch a blob from, given the URL its
+// BlobStore returned.
+func blobURL(r *http.Request, u string) string {
+	if strings.HasPrefix(u, "/") {
+		return requestBaseURL(r) + u
+	}
+	return u
+}
+
+// requestBaseURL returns the scheme and host the client reached us at.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// Hot returns the hottest images of a type, gif by default. To get an
+// adjacent page, pass the Next or Prev cursor of a response as cursor.
+//  curl http://localhost:8080/Hot?device_id=ddd&type=burst
+func (s *Server) Hot(w http.ResponseWriter, r *http.Request) *appError {
+	postType, appErr := formPostType(r)
+	if appErr != nil {
+		return appErr
+	}
+	feed := "Hot/" + postType
+	q, appErr := s.feedQuery(r, feed, postType)
+	if appErr != nil {
+		return appErr
+	}
+	if q.Start == nil && r.FormValue("key") != "" {
+		// Clients that predate cursors pass the key and hot score of a post,
+		// or its score before there were hot scores.
+		key, err := base64.StdEncoding.DecodeString(r.FormValue("key"))
 		if err != nil {
 			return &appError{Message: err.Error(), Code: http.StatusBadRequest}
 		}
-		key = k
-		score, err = strconv.Atoi(r.FormValue("score"))
-		if err != nil {
+		hot := r.FormValue("hot")
+		if hot == "" {
+			if hot, appErr = s.scoreHot(postType, key, r.FormValue("score")); appErr != nil {
+				return appErr
+			}
+		}
+		if _, err := strconv.ParseFloat(hot, 64); err != nil {
 			return &appError{Message: err.Error(), Code: http.StatusBadRequest}
 		}
+		q.Start = hotIndexKey(postType, key, hot)
+		q.Forward = r.FormValue("forward") == "true"
 	}
-	forward := false
-	if r.FormValue("forward") == "true" {
-		forward = true
+	deviceID, appErr := s.formDeviceID(r)
+	if appErr != nil {
+		return appErr
 	}
-	limit := 20
-	if limitStr := r.FormValue("limit"); limitStr != "" {
-		l, err := strconv.Atoi(limitStr)
+
+	page, err := s.store.HotPosts(q)
+	if err != nil {
+		glog.Errorf("%v", err)
+		return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+	}
+	resp, appErr := s.feedJSON(feed, q, page, []byte(deviceID))
+	if appErr != nil {
+		return appErr
+	}
+
+	json.NewEncoder(w).Encode(resp)
+	return nil
+}
+
+// scoreHot returns the hot score to page from for clients that pass the
+// score of a post: the current hot score of the post, which is where it is in
+// the Hot index, or if it is gone, the hot score of score.
+func (s *Server) scoreHot(postType string, key []byte, score string) (string, *appError) {
+	post, err := s.store.GetPost(postType, key)
+	if err != nil {
+		glog.Errorf("%v", err)
+		return "", &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+	}
+	if post != nil && post.H.N != "" {
+		return post.H.N, nil
+	}
+	votes, err := strconv.Atoi(score)
+	if err != nil {
+		return "", &appError{Message: err.Error(), Code: http.StatusBadRequest}
+	}
+	return s.hot.scoreN(votes, key), nil
+}
+
+// New returns the latest images of a type, gif by default, newest first. To
+// get an adjacent page, pass the Next or Prev cursor of a response as cursor.
+//   curl http://localhost:8080/New?device_id=ddd&type=photo
+func (s *Server) New(w http.ResponseWriter, r *http.Request) *appError {
+	postType, appErr := formPostType(r)
+	if appErr != nil {
+		return appErr
+	}
+	feed := "New/" + postType
+	q, appErr := s.feedQuery(r, feed, postType)
+	if appErr != nil {
+		return appErr
+	}
+	if q.Start == nil && r.FormValue("key") != "" {
+		// Clients that predate cursors pass the key of a post.
+		key, err := base64.StdEncoding.DecodeString(r.FormValue("key"))
 		if err != nil {
 			return &appError{Message: err.Error(), Code: http.StatusBadRequest}
 		}
-		limit = l
+		q.Start = postTableKey(postType, key)
+		q.Forward = r.FormValue("forward") == "true"
+	}
+	deviceID, appErr := s.formDeviceID(r)
+	if appErr != nil {
+		return appErr
 	}
-	deviceID := []byte(r.FormValue("device_id"))
 
-	posts, err := getPostsByScore(postTypeGIF, key, score, forward, limit)
+	page, err := s.store.NewPosts(q)
 	if err != nil {
 		glog.Errorf("%v", err)
 		return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
 	}
-	c := make(chan PostJSON)
-	var wg sync.WaitGroup
-	wg.Add(len(posts))
-	for _, p := range posts {
-		go func(p PostDDB) {
-			defer wg.Done()
-			pj := postDDBToJSON(p)
-			if len(deviceID) > 0 {
-				bodyj := struct {
-					TableName string
-					Key       struct {
-						D struct{ B []byte }
-						P struct{ B []byte }
-					}
-				}{}
-				bodyj.TableName = ddbTableVote
-				bodyj.Key.D.B = deviceID
-				bodyj.Key.P.B = postPK(postTypeGIF, p.K.B)
-				v := struct{ Item *VoteDDB }{}
-				if err := aws.DynamoDBPost("GetItem", bodyj, &v); err != nil {
-					glog.Errorf("%v", err)
-				} else {
-					if v.Item != nil {
-						pj.V = true
-					}
-				}
-			}
-			c <- pj
-		}(p)
-	}
-	go func() {
-		wg.Wait()
-		close(c)
-	}()
-	resp := struct {
-		Posts []PostJSON
-	}{}
-	for pj := range c {
-		resp.Posts = append(resp.Posts, pj)
+	resp, appErr := s.feedJSON(feed, q, page, []byte(deviceID))
+	if appErr != nil {
+		return appErr
 	}
-	sort.Sort(postJSONByKDesc(resp.Posts))
 
 	json.NewEncoder(w).Encode(resp)
 	return nil
 }
 
-// Vote votes for an image.
-//   curl 'http://localhost:8080/Vote?device_id=ddd&key=E7MySUSwyFQ%3D'
-func Vote(w http.ResponseWriter, r *http.Request) *appError {
-	deviceID := r.FormValue("device_id")
-	if deviceID == "" {
-		return &appError{Message: "no device_id", Code: http.StatusBadRequest}
+// Post returns a post, identified by its type, gif by default, and key, with
+// how the device voted for it. Posts under moderation are not found.
+//   curl 'http://localhost:8080/Post?device_id=ddd&key=E7MySUSwyFQ%3D'
+func (s *Server) Post(w http.ResponseWriter, r *http.Request) *appError {
+	deviceID, appErr := s.formDeviceID(r)
+	if appErr != nil {
+		return appErr
+	}
+	post, appErr := s.formPost(r)
+	if appErr != nil {
+		return appErr
+	}
+	if post.X != nil {
+		return &appError{Message: ErrNoPost.Error(), Code: http.StatusNotFound}
+	}
+	pjs, err := s.postsJSON([]PostDDB{*post}, []byte(deviceID))
+	if err != nil {
+		glog.Errorf("%v", err)
+		return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+	}
+	json.NewEncoder(w).Encode(pjs[0])
+	return nil
+}
+
+// Vote votes for an image, identified by its type, gif by default, and key.
+// value is 1 for an upvote, the default, or -1 for a downvote. Voting the
+// other way changes the device's vote.
+//   curl 'http://localhost:8080/Vote?device_id=ddd&key=E7MySUSwyFQ%3D&value=-1'
+func (s *Server) Vote(w http.ResponseWriter, r *http.Request) *appError {
+	deviceID, appErr := s.requireDevice(r)
+	if appErr != nil {
+		return appErr
+	}
+	postType, appErr := formPostType(r)
+	if appErr != nil {
+		return appErr
 	}
 	key, err := base64.StdEncoding.DecodeString(r.FormValue("key"))
 	if err != nil {
 		return &appError{Message: err.Error(), Code: http.StatusBadRequest}
 	}
-
-	vote := VoteDDB{}
-	vote.D.B = []byte(deviceID)
-	vote.P.B = postPK(postTypeGIF, key)
-	bodyj := struct {
-		TableName                 string
-		Item                      VoteDDB
-		ConditionExpression       string
-		ExpressionAttributeValues struct {
-			D struct{ B []byte } `json:":d"`
-			P struct{ B []byte } `json:":p"`
-		}
-	}{}
-	bodyj.TableName = ddbTableVote
-	bodyj.Item = vote
-	bodyj.ConditionExpression = "D <> :d and P <> :p"
-	bodyj.ExpressionAttributeValues.D.B = vote.D.B
-	bodyj.ExpressionAttributeValues.P.B = vote.P.B
-	if err := aws.DynamoDBPost("PutItem", bodyj, nil); err != nil {
-		if derr, ok := err.(*aws.ErrDynamoDB); ok && derr.Type == "ConditionalCheckFailedException" {
-			return &appError{Message: derr.Error(), Code: http.StatusBadRequest}
+	value := 1
+	if valueStr := r.FormValue("value"); valueStr != "" {
+		value, err = strconv.Atoi(valueStr)
+		if err != nil || (value != 1 && value != -1) {
+			return &appError{Message: "value must be 1 or -1", Code: http.StatusBadRequest}
 		}
-		glog.Errorf("%v", err)
-		return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
 	}
 
-	bj := struct {
-		TableName string
-		Key       struct {
-			I struct{ S string }
-			K struct{ B []byte }
-		}
-		UpdateExpression          string
-		ExpressionAttributeValues struct {
-			S struct{ N string } `json:":s"`
+	vote := newVoteDDB([]byte(deviceID), postPK(postType, key), value)
+	err = s.store.CastVote(vote)
+	if err == ErrVoteExists {
+		// Switch the existing vote if it goes the other way. SwitchVote only
+		// succeeds once per switch, so the score moves by 2 exactly once.
+		old, gerr := s.store.GetVote(vote.D.B, vote.P.B)
+		switch {
+		case gerr != nil:
+			err = gerr
+		case old == nil:
+			err = ErrVoteChanged
+		case old.Value() == value:
+			err = ErrVoteExists
+		default:
+			err = s.store.SwitchVote(vote)
 		}
-		ReturnValues string
-	}{}
-	bj.TableName = ddbTablePost
-	bj.Key.I.S = postTypeGIF
-	bj.Key.K.B = key
-	bj.UpdateExpression = "ADD S :s"
-	bj.ExpressionAttributeValues.S.N = "1"
-	bj.ReturnValues = "ALL_NEW"
-	ur := struct{ Attributes PostDDB }{}
-	if err := aws.DynamoDBPost("UpdateItem", bj, &ur); err != nil {
-		glog.Errorf("%v", err)
+	}
+	if appErr := voteError(err); appErr != nil {
+		return appErr
 	}
 
-	pj := postDDBToJSON(ur.Attributes)
-	pj.V = true
+	pj, appErr := s.votedPostJSON(postType, key)
+	if appErr != nil {
+		return appErr
+	}
+	pj.V = value
 	json.NewEncoder(w).Encode(pj)
 	return nil
 }
 
-func root(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("hello world!"))
+// voteError converts the errors of vote changes, reactions and reports to
+// their HTTP form.
+func voteError(err error) *appError {
+	switch err {
+	case nil:
+		return nil
+	case ErrVoteExists, ErrNoVote, ErrReportExists, ErrReactionExists, ErrNoReaction:
+		return &appError{Message: err.Error(), Code: http.StatusBadRequest}
+	case ErrNoPost:
+		return &appError{Message: err.Error(), Code: http.StatusNotFound}
+	case ErrVoteChanged:
+		return &appError{Message: err.Error(), Code: http.StatusConflict}
+	}
+	glog.Errorf("%v", err)
+	return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
 }
 
-func getPostsByScore(postType string, key []byte, score int, forward bool, limit int) ([]PostDDB, error) {
-	var body []byte
-	if key == nil {
-		bodyj := struct {
-			TableName     string
-			IndexName     string
-			KeyConditions struct {
-				I struct {
-					AttributeValueList []struct{ S string }
-					ComparisonOperator string
-				}
-			}
-			Limit            int
-			ScanIndexForward bool
-		}{}
-		bodyj.TableName = ddbTablePost
-		bodyj.IndexName = "Score"
-		bodyj.KeyConditions.I.AttributeValueList = []struct{ S string }{struct{ S string }{S: postType}}
-		bodyj.KeyConditions.I.ComparisonOperator = "EQ"
-		bodyj.Limit = limit
-		bodyj.ScanIndexForward = false
-		body, _ = json.Marshal(bodyj)
-	} else {
-		bodyj := struct {
-			TableName     string
-			IndexName     string
-			KeyConditions struct {
-				I struct {
-					AttributeValueList []struct{ S string }
-					ComparisonOperator string
-				}
-			}
-			ExclusiveStartKey struct {
-				I struct{ S string }
-				K struct{ B []byte }
-				S struct{ N string }
-			}
-			Limit            int
-			ScanIndexForward bool
-		}{}
-		bodyj.TableName = ddbTablePost
-		bodyj.IndexName = "Score"
-		bodyj.KeyConditions.I.AttributeValueList = []struct{ S string }{struct{ S string }{S: postType}}
-		bodyj.KeyConditions.I.ComparisonOperator = "EQ"
-		bodyj.ExclusiveStartKey.I.S = postType
-		bodyj.ExclusiveStartKey.K.B = key
-		bodyj.ExclusiveStartKey.S.N = fmt.Sprintf("%d", score)
-		bodyj.Limit = limit
-		bodyj.ScanIndexForward = forward
-		body, _ = json.Marshal(bodyj)
-	}
-	ddbResp := struct {
-		Count            int
-		LastEvaluatedKey struct {
-			I struct{ S string }
-			K struct{ B []byte }
+// votedPostJSON returns a post whose score was just changed by a vote,
+// updating its hot score.
+func (s *Server) votedPostJSON(postType string, key []byte) (PostJSON, *appError) {
+	post, err := s.store.GetPost(postType, key)
+	if err != nil {
+		glog.Errorf("%v", err)
+		return PostJSON{}, &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+	}
+	if post == nil {
+		return PostJSON{}, &appError{Message: ErrNoPost.Error(), Code: http.StatusNotFound}
+	}
+	s.updateHot(post)
+	return postDDBToJSON(*post), nil
+}
+
+// postsJSON converts posts to their JSON form, filling in how deviceID voted
+// for and reacted to each of them.
+func (s *Server) postsJSON(posts []PostDDB, deviceID []byte) ([]PostJSON, error) {
+	pjs := make([]PostJSON, len(posts))
+	pks := make([][]byte, len(posts))
+	for i, p := range posts {
+		pjs[i] = postDDBToJSON(p)
+		pks[i] = postPK(p.I.S, p.K.B)
+	}
+	if len(deviceID) == 0 || len(posts) == 0 {
+		return pjs, nil
+	}
+	votes, err := s.store.GetVotes(deviceID, pks)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range votes {
+		if v != nil {
+			pjs[i].V = v.Value()
 		}
-		Items []PostDDB
-	}{}
-	if err := aws.DynamoDBPostBytes("Query", body, &ddbResp); err != nil {
+	}
+	reactions, err := s.store.GetReactions(deviceID, pks)
+	if err != nil {
 		return nil, err
 	}
-	return ddbResp.Items, nil
-}
-
-func CreateDDBTables() error {
-	bodies := []string{
-		fmt.Sprintf(`{
-  "TableName": "%s",
-  "AttributeDefinitions": [
-    { "AttributeName": "I", "AttributeType": "S" },
-    { "AttributeName": "K", "AttributeType": "B" },
-    { "AttributeName": "S", "AttributeType": "N" } ],
-  "KeySchema": [
-    { "AttributeName": "I", "KeyType": "HASH" },
-    { "AttributeName": "K", "KeyType": "RANGE" } ],
-  "GlobalSecondaryIndexes":[{
-      "IndexName": "Score",
-      "KeySchema": [
-        { "AttributeName": "I", "KeyType": "HASH" },
-        { "AttributeName": "S", "KeyType": "RANGE" } ],
-      "Projection": { "ProjectionType": "ALL" },
-      "ProvisionedThroughput": {"ReadCapacityUnits":1, "WriteCapacityUnits":1}
-  }],
-  "ProvisionedThroughput": { "ReadCapacityUnits": 1, "WriteCapacityUnits": 1 }
-}`, ddbTablePost),
-		fmt.Sprintf(`{
-  "TableName": "%s",
-  "AttributeDefinitions": [
-    { "AttributeName": "D", "AttributeType": "B" },
-    { "AttributeName": "P", "AttributeType": "B" } ],
-  "KeySchema": [
-    { "AttributeName": "D", "KeyType": "HASH" },
-    { "AttributeName": "P", "KeyType": "RANGE" } ],
-  "ProvisionedThroughput": { "ReadCapacityUnits": 1, "WriteCapacityUnits": 1 }
-}`, ddbTableVote),
-	}
-	for _, b := range bodies {
-		if err := aws.DynamoDBPostBytes("CreateTable", []byte(b), nil); err != nil {
-			glog.Fatalf("%v", err)
-			return err
+	for i, r := range reactions {
+		pjs[i].VR = deviceReactions(r)
+	}
+	return pjs, nil
+}
+
+// FeedJSON is a page of a feed as returned by the feed endpoints.
+type FeedJSON struct {
+	Posts []PostJSON
+	// Next is the cursor of the page further down the feed, Prev of the
+	// page further up. They are omitted when there is no such page.
+	Next string `json:",omitempty"`
+	Prev string `json:",omitempty"`
+}
+
+// feedQuery parses the pagination parameters of a request to feed.
+func (s *Server) feedQuery(r *http.Request, feed, postType string) (FeedQuery, *appError) {
+	q := FeedQuery{Type: postType, Limit: 20}
+	if limitStr := r.FormValue("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil {
+			return q, &appError{Message: err.Error(), Code: http.StatusBadRequest}
+		}
+		q.Limit = l
+	}
+	if token := r.FormValue("cursor"); token != "" {
+		c, err := s.cursors.decode(token)
+		if err != nil {
+			return q, &appError{Message: err.Error(), Code: http.StatusBadRequest}
+		}
+		if c.Feed != feed {
+			return q, &appError{Message: "cursor is for another feed", Code: http.StatusBadRequest}
+		}
+		q.Start = c.Key
+		q.Forward = c.Forward
+	}
+	return q, nil
+}
+
+// feedJSON converts a page read by q to its JSON form, top of the feed first,
+// with cursors to the pages before and after it.
+func (s *Server) feedJSON(feed string, q FeedQuery, page FeedPage, deviceID []byte) (FeedJSON, *appError) {
+	resp := FeedJSON{}
+	posts, err := s.postsJSON(page.Posts, deviceID)
+	if err != nil {
+		glog.Errorf("%v", err)
+		return resp, &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+	}
+	resp.Posts = posts
+	forward := q.Forward && q.Start != nil
+	if forward {
+		// Pages read towards the top of the feed come bottom first.
+		for i, j := 0, len(posts)-1; i < j; i, j = i+1, j-1 {
+			posts[i], posts[j] = posts[j], posts[i]
 		}
 	}
+	// Continue in the direction of q from the last key, or turn around at
+	// the first post.
+	ahead, back := &resp.Next, &resp.Prev
+	if forward {
+		ahead, back = back, ahead
+	}
+	if page.Last != nil {
+		token, err := s.cursors.encode(cursor{Feed: feed, Forward: forward, Key: page.Last})
+		if err != nil {
+			return resp, &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+		}
+		*ahead = token
+	}
+	if page.First != nil {
+		token, err := s.cursors.encode(cursor{Feed: feed, Forward: !forward, Key: page.First})
+		if err != nil {
+			return resp, &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+		}
+		*back = token
+	}
+	return resp, nil
+}
+
+// updateHot recomputes the hot score of a post after its score changed, and
+// the scores of its tags.
+func (s *Server) updateHot(post *PostDDB) {
+	score, err := strconv.Atoi(post.S.N)
+	if err != nil {
+		glog.Errorf("%v", err)
+		return
+	}
+	hot := s.hot.scoreN(score, post.K.B)
+	if err := s.store.SetHot(post.I.S, post.K.B, post.S.N, hot); err != nil {
+		glog.Errorf("%v", err)
+		return
+	}
+	post.H.N = hot
+	s.updateTagScores(post)
+}
+
+// Unvote retracts a vote for an image, identified like in Vote.
+//   curl 'http://localhost:8080/Unvote?device_id=ddd&key=E7MySUSwyFQ%3D'
+func (s *Server) Unvote(w http.ResponseWriter, r *http.Request) *appError {
+	deviceID, appErr := s.requireDevice(r)
+	if appErr != nil {
+		return appErr
+	}
+	postType, appErr := formPostType(r)
+	if appErr != nil {
+		return appErr
+	}
+	key, err := base64.StdEncoding.DecodeString(r.FormValue("key"))
+	if err != nil {
+		return &appError{Message: err.Error(), Code: http.StatusBadRequest}
+	}
+
+	// Only the request that actually deletes the vote changes the score, so
+	// retrying an unvote can not move the score twice.
+	vote, err := s.store.GetVote([]byte(deviceID), postPK(postType, key))
+	if err == nil && vote == nil {
+		err = ErrNoVote
+	}
+	if err == nil {
+		err = s.store.RetractVote(*vote)
+	}
+	if appErr := voteError(err); appErr != nil {
+		return appErr
+	}
+
+	pj, appErr := s.votedPostJSON(postType, key)
+	if appErr != nil {
+		return appErr
+	}
+	json.NewEncoder(w).Encode(pj)
 	return nil
 }
 
+func root(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("hello world!"))
+}
+
 type appError struct {
 	Message string
 	Code    int
@@ -402,8 +792,8 @@ func (a *appError) Error() string {
 	return a.Message
 }
 
-func jsonAPI(path string, fn func(w http.ResponseWriter, r *http.Request) *appError) {
-	http.DefaultServeMux.HandleFunc(path, makeGzipHandler(makeJSONErrorHandler(fn)))
+func jsonAPI(mux *http.ServeMux, path string, fn func(w http.ResponseWriter, r *http.Request) *appError) {
+	mux.HandleFunc(path, makeGzipHandler(makeJSONErrorHandler(fn)))
 }
 
 type gzipResponseWriter struct {
@@ -37,6 +37,176 @@ func TestPostAndVote(t *testing.T) {
 	}
 }
 
+func TestUnvote(t *testing.T) {
+	setup(t)
+	ddb := httptest.NewServer(http.DefaultServeMux)
+	defer ddb.Close()
+	mem := newMemTestServer()
+	defer mem.Close()
+
+	for _, ts := range []*httptest.Server{ddb, mem} {
+		p := postAndVoteNTimes(ts, "http://127.0.0.1/a.jpg", 2)
+
+		// Unvote twice with the same deviceID, the score should only drop once.
+		v := url.Values{"device_id": {"0"}, "key": {base64.StdEncoding.EncodeToString(p.K.B)}}
+		pj := PostJSON{}
+		resp, _, err := util.JSONReq3("POST", ts.URL+"/Unvote?"+v.Encode(), &pj)
+		if err != nil || resp.StatusCode != http.StatusOK || pj.S.N != "1" || pj.V != 0 {
+			t.Fatalf("%v %+v", err, pj)
+		}
+		resp, _, err = util.JSONReq3("POST", ts.URL+"/Unvote?"+v.Encode(), nil)
+		if err != nil || resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%v %+v", err, resp)
+		}
+		imgs := struct{ Posts []PostJSON }{}
+		util.JSONReq3("GET", ts.URL+"/Hot?device_id=0", &imgs)
+		if imgs.Posts[0].S.N != "1" || imgs.Posts[0].V != 0 {
+			t.Fatalf("%+v", imgs.Posts[0])
+		}
+
+		// The device can vote again.
+		util.JSONReq3("POST", ts.URL+"/Vote?"+v.Encode(), &pj)
+		if pj.S.N != "2" || pj.V != 1 {
+			t.Fatalf("%+v", pj)
+		}
+	}
+}
+
+func TestDownvote(t *testing.T) {
+	setup(t)
+	ddb := httptest.NewServer(http.DefaultServeMux)
+	defer ddb.Close()
+	mem := newMemTestServer()
+	defer mem.Close()
+
+	for _, ts := range []*httptest.Server{ddb, mem} {
+		p := postAndVoteNTimes(ts, "http://127.0.0.1/a.jpg", 3)
+		k := base64.StdEncoding.EncodeToString(p.K.B)
+
+		vote := func(device, value string) (int, PostJSON) {
+			pj := PostJSON{}
+			v := url.Values{"device_id": {device}, "key": {k}, "value": {value}}
+			resp, _, err := util.JSONReq3("POST", ts.URL+"/Vote?"+v.Encode(), &pj)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			return resp.StatusCode, pj
+		}
+		if code, pj := vote("down", "-1"); code != http.StatusOK || pj.S.N != "2" || pj.V != -1 {
+			t.Fatalf("%d %+v", code, pj)
+		}
+		if code, _ := vote("down", "-1"); code != http.StatusBadRequest {
+			t.Fatalf("%d", code)
+		}
+		// Switching moves the score by 2, once.
+		if code, pj := vote("0", "-1"); code != http.StatusOK || pj.S.N != "0" || pj.V != -1 {
+			t.Fatalf("%d %+v", code, pj)
+		}
+		if code, _ := vote("0", "-1"); code != http.StatusBadRequest {
+			t.Fatalf("%d", code)
+		}
+		if code, pj := vote("0", "1"); code != http.StatusOK || pj.S.N != "2" || pj.V != 1 {
+			t.Fatalf("%d %+v", code, pj)
+		}
+		if code, _ := vote("0", "2"); code != http.StatusBadRequest {
+			t.Fatalf("%d", code)
+		}
+
+		imgs := struct{ Posts []PostJSON }{}
+		util.JSONReq3("GET", ts.URL+"/Hot?device_id=down", &imgs)
+		if imgs.Posts[0].S.N != "2" || imgs.Posts[0].V != -1 {
+			t.Fatalf("%+v", imgs.Posts[0])
+		}
+
+		// Retracting a downvote raises the score.
+		pj := PostJSON{}
+		v := url.Values{"device_id": {"down"}, "key": {k}}
+		util.JSONReq3("POST", ts.URL+"/Unvote?"+v.Encode(), &pj)
+		if pj.S.N != "3" {
+			t.Fatalf("%+v", pj)
+		}
+	}
+}
+
+func TestVoteMissingPost(t *testing.T) {
+	setup(t)
+	ddb := httptest.NewServer(http.DefaultServeMux)
+	defer ddb.Close()
+	mem := newMemTestServer()
+	defer mem.Close()
+
+	for _, ts := range []*httptest.Server{ddb, mem} {
+		// The vote must not be recorded when the post's score can not move.
+		v := url.Values{"device_id": {"ddd"}, "key": {base64.StdEncoding.EncodeToString([]byte("nopost"))}}
+		for i := 0; i < 2; i++ {
+			resp, _, err := util.JSONReq3("POST", ts.URL+"/Vote?"+v.Encode(), nil)
+			if err != nil || resp.StatusCode != http.StatusNotFound {
+				t.Fatalf("%v %+v", err, resp)
+			}
+		}
+		resp, _, err := util.JSONReq3("POST", ts.URL+"/Unvote?"+v.Encode(), nil)
+		if err != nil || resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%v %+v", err, resp)
+		}
+	}
+}
+
+func TestGetPost(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *Server, ts *httptest.Server) {
+		p := postAndVoteNTimes(ts, "http://127.0.0.1/a.gif", 2)
+		key := base64.StdEncoding.EncodeToString(p.K.B)
+		for _, c := range []struct {
+			device string
+			v      int
+		}{{"1", 1}, {"9", 0}, {"", 0}} {
+			pj := PostJSON{}
+			v := url.Values{"device_id": {c.device}, "key": {key}}
+			resp, _, err := util.JSONReq3("GET", ts.URL+"/Post?"+v.Encode(), &pj)
+			if err != nil || resp.StatusCode != http.StatusOK || pj.URL.S != p.URL.S || pj.S.N != "2" || pj.V != c.v {
+				t.Fatalf("%+v: %v %+v %+v", c, err, resp, pj)
+			}
+		}
+
+		// Missing and hidden posts are not found.
+		if err := s.store.SetState(p.I.S, p.K.B, postStateHidden); err != nil {
+			t.Fatalf("%v", err)
+		}
+		for _, k := range []string{key, base64.StdEncoding.EncodeToString([]byte("nopost"))} {
+			resp, _, err := util.JSONReq3("GET", ts.URL+"/Post?key="+url.QueryEscape(k), nil)
+			if err != nil || resp.StatusCode != http.StatusNotFound {
+				t.Fatalf("%v %+v", err, resp)
+			}
+		}
+	})
+}
+
+func TestHotVoteState(t *testing.T) {
+	if fakeDDB == nil {
+		t.Skip("needs the DynamoDB fake")
+	}
+	setup(t)
+	ts := httptest.NewServer(http.DefaultServeMux)
+	defer ts.Close()
+
+	for i := 0; i < 5; i++ {
+		postAndVoteNTimes(ts, fmt.Sprintf("http://127.0.0.1/%d.jpg", i), i)
+	}
+	// Make BatchGetItem leave keys unprocessed, so they have to be retried.
+	fakeDDB.SetBatchGetLimit(2)
+	defer fakeDDB.SetBatchGetLimit(0)
+
+	imgs := struct{ Posts []PostJSON }{}
+	util.JSONReq3("GET", ts.URL+"/Hot?device_id=1", &imgs)
+	var got []string
+	for _, p := range imgs.Posts {
+		got = append(got, fmt.Sprintf("%s:%d", p.S.N, p.V))
+	}
+	// Device "1" voted for every post with at least 2 votes.
+	if fmt.Sprint(got) != "[4:1 3:1 2:1 1:0 0:0]" {
+		t.Fatalf("%v", got)
+	}
+}
+
 func TestHotPaginate(t *testing.T) {
 	setup(t)
 	ts := httptest.NewServer(http.DefaultServeMux)
@@ -72,7 +242,135 @@ func TestHotPaginate(t *testing.T) {
 	}
 }
 
-func postAndVoteNTimes(ts *httptest.Server, imgurl string, voteNum int) {
+func TestNewPaginate(t *testing.T) {
+	setup(t)
+	ts := httptest.NewServer(http.DefaultServeMux)
+	defer ts.Close()
+
+	postAndVoteNTimes(ts, "http://127.0.0.1/first.jpg", 2)
+	postAndVoteNTimes(ts, "http://127.0.0.1/second.jpg", 0)
+	postAndVoteNTimes(ts, "http://127.0.0.1/third.jpg", 1)
+
+	// Paginate towards older posts
+	imgs := struct{ Posts []PostJSON }{}
+	util.JSONReq3("GET", ts.URL+"/New?limit=2&device_id=1", &imgs)
+	if len(imgs.Posts) != 2 || imgs.Posts[0].URL.S != "http://127.0.0.1/third.jpg" || imgs.Posts[1].URL.S != "http://127.0.0.1/second.jpg" {
+		t.Fatalf("%+v", imgs)
+	}
+	v := url.Values{"key": {base64.StdEncoding.EncodeToString(imgs.Posts[1].K.B)}, "device_id": {"1"}}
+	imgs2 := struct{ Posts []PostJSON }{}
+	util.JSONReq3("GET", ts.URL+"/New?"+v.Encode(), &imgs2)
+	if len(imgs2.Posts) != 1 || imgs2.Posts[0].URL.S != "http://127.0.0.1/first.jpg" || imgs2.Posts[0].V != 1 {
+		t.Fatalf("%+v", imgs2)
+	}
+
+	// Paginate towards newer posts
+	postAndVoteNTimes(ts, "http://127.0.0.1/fourth.jpg", 0)
+	v = url.Values{"key": {base64.StdEncoding.EncodeToString(imgs.Posts[0].K.B)}, "forward": {"true"}}
+	imgs = struct{ Posts []PostJSON }{}
+	util.JSONReq3("GET", ts.URL+"/New?"+v.Encode(), &imgs)
+	if len(imgs.Posts) != 1 || imgs.Posts[0].URL.S != "http://127.0.0.1/fourth.jpg" {
+		t.Fatalf("%+v", imgs)
+	}
+}
+
+func TestFeedCursors(t *testing.T) {
+	setup(t)
+	ddb := httptest.NewServer(http.DefaultServeMux)
+	defer ddb.Close()
+	mem := newMemTestServer()
+	defer mem.Close()
+
+	for _, ts := range []*httptest.Server{ddb, mem} {
+		for i := 0; i < 5; i++ {
+			postAndVoteNTimes(ts, fmt.Sprintf("http://127.0.0.1/%d.jpg", i), i)
+		}
+
+		// Walk down the feed, then back up from the last page.
+		var urls []string
+		var pages []FeedJSON
+		v := url.Values{"limit": {"2"}}
+		for {
+			page := FeedJSON{}
+			util.JSONReq3("GET", ts.URL+"/Hot?"+v.Encode(), &page)
+			for _, p := range page.Posts {
+				urls = append(urls, p.URL.S)
+			}
+			pages = append(pages, page)
+			if page.Next == "" {
+				break
+			}
+			v.Set("cursor", page.Next)
+		}
+		if fmt.Sprint(urls) != "[http://127.0.0.1/4.jpg http://127.0.0.1/3.jpg http://127.0.0.1/2.jpg http://127.0.0.1/1.jpg http://127.0.0.1/0.jpg]" {
+			t.Fatalf("%v", urls)
+		}
+		v.Set("cursor", pages[2].Prev)
+		prev := FeedJSON{}
+		util.JSONReq3("GET", ts.URL+"/Hot?"+v.Encode(), &prev)
+		if len(prev.Posts) != 2 || prev.Posts[0].URL.S != "http://127.0.0.1/2.jpg" || prev.Posts[1].URL.S != "http://127.0.0.1/1.jpg" {
+			t.Fatalf("%+v", prev)
+		}
+
+		// Cursors can not be altered or used with another feed.
+		v.Set("cursor", "f"+pages[0].Next[1:])
+		resp, _, err := util.JSONReq3("GET", ts.URL+"/Hot?"+v.Encode(), nil)
+		if err != nil || resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%v %+v", err, resp)
+		}
+		v.Set("cursor", pages[0].Next)
+		resp, _, err = util.JSONReq3("GET", ts.URL+"/New?"+v.Encode(), nil)
+		if err != nil || resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%v %+v", err, resp)
+		}
+	}
+}
+
+func TestMemStoreHot(t *testing.T) {
+	ts := newMemTestServer()
+	defer ts.Close()
+
+	postAndVoteNTimes(ts, "http://127.0.0.1/1vote.jpg", 1)
+	postAndVoteNTimes(ts, "http://127.0.0.1/2votes.jpg", 2)
+
+	imgs := struct{ Posts []PostJSON }{}
+	util.JSONReq3("GET", ts.URL+"/Hot?device_id=0", &imgs)
+	if len(imgs.Posts) != 2 {
+		t.Fatalf("%+v", imgs)
+	}
+	if imgs.Posts[0].URL.S != "http://127.0.0.1/2votes.jpg" || imgs.Posts[0].S.N != "2" || imgs.Posts[0].V != 1 {
+		t.Fatalf("%+v", imgs.Posts[0])
+	}
+
+	// A duplicate vote is rejected and does not change the score.
+	v := url.Values{"device_id": {"0"}, "key": {base64.StdEncoding.EncodeToString(imgs.Posts[1].K.B)}}
+	resp, _, err := util.JSONReq3("POST", ts.URL+"/Vote?"+v.Encode(), nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("%d", resp.StatusCode)
+	}
+	imgs = struct{ Posts []PostJSON }{}
+	util.JSONReq3("GET", ts.URL+"/Hot", &imgs)
+	if imgs.Posts[1].S.N != "1" {
+		t.Fatalf("%+v", imgs.Posts[1])
+	}
+
+	imgs = struct{ Posts []PostJSON }{}
+	util.JSONReq3("GET", ts.URL+"/New?limit=1", &imgs)
+	if len(imgs.Posts) != 1 || imgs.Posts[0].URL.S != "http://127.0.0.1/2votes.jpg" {
+		t.Fatalf("%+v", imgs)
+	}
+}
+
+func newMemTestServer() *httptest.Server {
+	mux := http.NewServeMux()
+	NewServer(NewMemStore(), NewFSBlobStore(testBlobDir)).Register(mux)
+	return httptest.NewServer(mux)
+}
+
+func postAndVoteNTimes(ts *httptest.Server, imgurl string, voteNum int) PostDDB {
 	v := url.Values{"url": {imgurl}}
 	p := PostDDB{}
 	util.JSONReq3("POST", ts.URL+"/PostImg?"+v.Encode(), &p)
@@ -80,6 +378,7 @@ func postAndVoteNTimes(ts *httptest.Server, imgurl string, voteNum int) {
 		v := url.Values{"device_id": {fmt.Sprintf("%d", i)}, "key": {base64.StdEncoding.EncodeToString(p.K.B)}}
 		util.JSONReq3("POST", ts.URL+"/Vote?"+v.Encode(), nil)
 	}
+	return p
 }
 
 func setup(t *testing.T) {
@@ -0,0 +1,139 @@
+package burstbooth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+type CommentDDB struct {
+	P struct{ B []byte } // post ID
+	K struct{ B []byte } // a unique key for this comment, ordered by time like post keys
+	D struct{ B []byte } // device ID
+	T struct{ S string } // text
+
+	// Optional Attributes
+	X *struct{ S string } `json:",omitempty"` // moderation state, comments with one are left out
+}
+
+// CommentJSON is a comment as returned by the comment endpoints.
+type CommentJSON struct {
+	Key  []byte
+	Time time.Time
+	Text string
+	// Held is true if the comment is kept from the post until it is
+	// reviewed. Only the response to Comment can have it.
+	Held bool `json:",omitempty"`
+}
+
+func commentDDBToJSON(c CommentDDB) CommentJSON {
+	return CommentJSON{Key: c.K.B, Time: postTime(c.K.B), Text: c.T.S, Held: c.X != nil}
+}
+
+// CommentsJSON is a page of the comments on a post.
+type CommentsJSON struct {
+	Comments []CommentJSON
+	// Next is the cursor of the page of older comments, omitted when
+	// there is none.
+	Next string `json:",omitempty"`
+}
+
+// Comment comments on a post, identified like in Vote, with text. The text
+// is moderated like captions.
+//   curl 'http://localhost:8080/Comment?device_id=ddd&key=E7MySUSwyFQ%3D&text=nice'
+func (s *Server) Comment(w http.ResponseWriter, r *http.Request) *appError {
+	deviceID, appErr := s.requireDevice(r)
+	if appErr != nil {
+		return appErr
+	}
+	post, appErr := s.formPost(r)
+	if appErr != nil {
+		return appErr
+	}
+	if post.X != nil {
+		return &appError{Message: ErrNoPost.Error(), Code: http.StatusNotFound}
+	}
+	text, held, err := s.captions.moderate(r.FormValue("text"))
+	if err != nil {
+		return &appError{Message: err.Error(), Code: http.StatusBadRequest}
+	}
+	if text == "" {
+		return &appError{Message: "no text", Code: http.StatusBadRequest}
+	}
+	key, err := postKey(time.Now())
+	if err != nil {
+		return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+	}
+
+	c := CommentDDB{}
+	c.P.B = postPK(post.I.S, post.K.B)
+	c.K.B = key
+	c.D.B = []byte(deviceID)
+	c.T.S = text
+	if held {
+		c.X = &struct{ S string }{S: postStateHeld}
+	}
+	if appErr := voteError(s.store.AddComment(c)); appErr != nil {
+		return appErr
+	}
+	json.NewEncoder(w).Encode(commentDDBToJSON(c))
+	return nil
+}
+
+// Comments returns the comments on a post, identified like in Vote, newest
+// first, up to limit, 20 by default. To get older comments, pass the Next
+// cursor of a response as cursor.
+//   curl 'http://localhost:8080/Comments?key=E7MySUSwyFQ%3D'
+func (s *Server) Comments(w http.ResponseWriter, r *http.Request) *appError {
+	post, appErr := s.formPost(r)
+	if appErr != nil {
+		return appErr
+	}
+	if post.X != nil {
+		return &appError{Message: ErrNoPost.Error(), Code: http.StatusNotFound}
+	}
+	pk := postPK(post.I.S, post.K.B)
+	feed := "Comments/" + base64.RawURLEncoding.EncodeToString(pk)
+	limit := 20
+	if v := r.FormValue("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l <= 0 {
+			return &appError{Message: "limit must be a positive number", Code: http.StatusBadRequest}
+		}
+		limit = l
+	}
+	var start json.RawMessage
+	if token := r.FormValue("cursor"); token != "" {
+		c, err := s.cursors.decode(token)
+		if err != nil {
+			return &appError{Message: err.Error(), Code: http.StatusBadRequest}
+		}
+		if c.Feed != feed {
+			return &appError{Message: "cursor is for another feed", Code: http.StatusBadRequest}
+		}
+		start = c.Key
+	}
+
+	comments, last, err := s.store.Comments(pk, start, limit)
+	if err != nil {
+		glog.Errorf("%v", err)
+		return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+	}
+	resp := CommentsJSON{Comments: make([]CommentJSON, len(comments))}
+	for i, c := range comments {
+		resp.Comments[i] = commentDDBToJSON(c)
+	}
+	if last != nil {
+		token, err := s.cursors.encode(cursor{Feed: feed, Key: last})
+		if err != nil {
+			return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+		}
+		resp.Next = token
+	}
+	json.NewEncoder(w).Encode(resp)
+	return nil
+}
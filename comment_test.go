@@ -0,0 +1,83 @@
+package burstbooth
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/cardinalblue/burstbooth/util"
+)
+
+func TestComments(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *Server, ts *httptest.Server) {
+		m, err := newCaptionModerator(20, captionHold, []string{"bad"}, nil)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		s.captions = m
+
+		p := postAndVoteNTimes(ts, "http://127.0.0.1/a.gif", 0)
+		key := base64.StdEncoding.EncodeToString(p.K.B)
+		for _, c := range []struct {
+			key, text string
+			code      int
+			held      bool
+		}{
+			{key, "  one ", http.StatusOK, false},
+			{key, "two", http.StatusOK, false},
+			{key, "so bad", http.StatusOK, true},
+			{key, "three", http.StatusOK, false},
+			{key, " \n", http.StatusBadRequest, false},
+			{key, strings.Repeat("x", 21), http.StatusBadRequest, false},
+			{base64.StdEncoding.EncodeToString([]byte("nopost")), "hi", http.StatusNotFound, false},
+		} {
+			cj := CommentJSON{}
+			v := url.Values{"device_id": {"ddd"}, "key": {c.key}, "text": {c.text}}
+			resp, _, err := util.JSONReq3("POST", ts.URL+"/Comment?"+v.Encode(), &cj)
+			if err != nil || resp.StatusCode != c.code || cj.Held != c.held {
+				t.Fatalf("%+v: %v %+v %+v", c, err, resp, cj)
+			}
+		}
+
+		// Held comments are neither counted nor listed.
+		pj := PostJSON{}
+		util.JSONReq3("GET", ts.URL+"/Post?key="+url.QueryEscape(key), &pj)
+		if pj.CC.N != "3" {
+			t.Fatalf("%+v", pj)
+		}
+		var got []string
+		cursor := ""
+		for {
+			page := CommentsJSON{}
+			v := url.Values{"key": {key}, "limit": {"2"}, "cursor": {cursor}}
+			resp, _, err := util.JSONReq3("GET", ts.URL+"/Comments?"+v.Encode(), &page)
+			if err != nil || resp.StatusCode != http.StatusOK {
+				t.Fatalf("%v %+v", err, resp)
+			}
+			for _, c := range page.Comments {
+				got = append(got, c.Text)
+			}
+			if cursor = page.Next; cursor == "" {
+				break
+			}
+		}
+		if fmt.Sprint(got) != "[three two one]" {
+			t.Fatalf("%v", got)
+		}
+
+		// Comments go with their post.
+		s.admins = []adminToken{{name: "alice", token: "a"}}
+		header := http.Header{"Authorization": {"Bearer a"}}
+		resp, _, err := util.JSONReq5("POST", ts.URL+"/admin/DeletePost?key="+url.QueryEscape(key), nil, header, nil)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("%v %+v", err, resp)
+		}
+		if comments, _, err := s.store.Comments(postPK(p.I.S, p.K.B), nil, 0); err != nil || len(comments) != 0 {
+			t.Fatalf("%v %+v", err, comments)
+		}
+	})
+}
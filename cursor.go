@@ -0,0 +1,88 @@
+package burstbooth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// errBadCursor is returned for cursors that were not issued by us.
+var errBadCursor = errors.New("invalid cursor")
+
+// cursor is a position in a feed. Clients get it as an opaque token and pass
+// it back unchanged to fetch the adjacent page.
+type cursor struct {
+	// Feed names the feed and index the key belongs to, so a cursor can not
+	// be replayed against another feed.
+	Feed string
+	// Forward is the direction to continue in, see FeedQuery.
+	Forward bool
+	// Key is the DynamoDB key to start after.
+	Key json.RawMessage
+}
+
+// cursorCodec signs and verifies cursor tokens.
+type cursorCodec struct {
+	secret []byte
+}
+
+// defaultCursorCodec signs with the CURSOR_SECRET environment variable. When
+// it is unset a random secret is used, which means cursors stop working when
+// the server restarts and are not shared between instances.
+var defaultCursorCodec = newCursorCodec(os.Getenv("CURSOR_SECRET"))
+
+func newCursorCodec(secret string) cursorCodec {
+	if secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			glog.Fatalf("%v", err)
+		}
+		glog.Warningf("CURSOR_SECRET not set, using a random secret")
+		return cursorCodec{secret: b}
+	}
+	return cursorCodec{secret: []byte(secret)}
+}
+
+func (cc cursorCodec) mac(payload string) []byte {
+	m := hmac.New(sha256.New, cc.secret)
+	m.Write([]byte(payload))
+	return m.Sum(nil)
+}
+
+// encode returns the token for c.
+func (cc cursorCodec) encode(c cursor) (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(cc.mac(payload)), nil
+}
+
+// decode verifies a token and returns its cursor.
+func (cc cursorCodec) decode(token string) (cursor, error) {
+	c := cursor{}
+	z := strings.SplitN(token, ".", 2)
+	if len(z) != 2 {
+		return c, errBadCursor
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(z[1])
+	if err != nil || !hmac.Equal(sig, cc.mac(z[0])) {
+		return c, errBadCursor
+	}
+	b, err := base64.RawURLEncoding.DecodeString(z[0])
+	if err != nil {
+		return c, errBadCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, errBadCursor
+	}
+	return c, nil
+}
@@ -0,0 +1,232 @@
+package burstbooth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// ErrDeviceExists is returned by DeviceStore.RegisterDevice when the device
+// has already been registered.
+var ErrDeviceExists = errors.New("device already registered")
+
+// errBadDeviceToken is returned for device tokens that were not issued by us.
+var errBadDeviceToken = errors.New("invalid device_token")
+
+type DeviceDDB struct {
+	D struct{ B []byte } // device ID
+
+	// Optional Attributes
+	R  *struct{ N string } `json:",omitempty"` // when the device registered, in Unix seconds, absent unless it did
+	BN *struct{ S string } `json:",omitempty"` // reason the device is banned, absent unless it is
+}
+
+// Device authentication modes.
+const (
+	// deviceAuthCompat accepts a raw device_id from clients that predate
+	// device tokens, and lets them register it.
+	deviceAuthCompat = "compat"
+	// deviceAuthRequired only accepts device tokens.
+	deviceAuthRequired = "required"
+)
+
+// registeredIDPrefix starts the IDs of devices registered without a
+// device_id. A raw device_id can not have it, so it can not pass for one.
+const registeredIDPrefix = "reg-"
+
+// deviceKey is a key device tokens are signed with.
+type deviceKey struct {
+	id     string // named in the tokens it signs
+	secret []byte
+}
+
+// deviceTokens issues and verifies the signed tokens that identify devices.
+// A token is the ID of its key, the device ID and an HMAC of both.
+type deviceTokens struct {
+	// keys verify tokens. The first also signs new ones, so a key is
+	// rotated by putting a new one first and dropping the old one once
+	// every device has registered again.
+	keys []deviceKey
+	mode string
+}
+
+var defaultDeviceTokens = deviceTokensFromEnv()
+
+// deviceTokensFromEnv configures deviceTokens from the environment:
+//
+//	DEVICE_TOKEN_KEYS: comma separated id:secret pairs, the signing key first
+//	DEVICE_AUTH: compat, the default, or required
+//
+// When DEVICE_TOKEN_KEYS is unset a random key is used, which means tokens
+// stop working when the server restarts and are not shared between
+// instances.
+func deviceTokensFromEnv() deviceTokens {
+	dt := deviceTokens{mode: os.Getenv("DEVICE_AUTH")}
+	switch dt.mode {
+	case "":
+		dt.mode = deviceAuthCompat
+	case deviceAuthCompat, deviceAuthRequired:
+	default:
+		glog.Fatalf("bad DEVICE_AUTH %q, want compat or required", dt.mode)
+	}
+	for _, pair := range strings.Split(os.Getenv("DEVICE_TOKEN_KEYS"), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		i := strings.Index(pair, ":")
+		if i <= 0 || i == len(pair)-1 || strings.Contains(pair[:i], ".") {
+			glog.Fatalf("bad DEVICE_TOKEN_KEYS entry %q, want id:secret", pair)
+		}
+		dt.keys = append(dt.keys, deviceKey{id: strings.TrimSpace(pair[:i]), secret: []byte(pair[i+1:])})
+	}
+	if len(dt.keys) == 0 {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			glog.Fatalf("%v", err)
+		}
+		glog.Warningf("DEVICE_TOKEN_KEYS not set, using a random key")
+		dt.keys = []deviceKey{{id: "random", secret: b}}
+	}
+	return dt
+}
+
+func (k deviceKey) mac(payload string) []byte {
+	m := hmac.New(sha256.New, k.secret)
+	m.Write([]byte(payload))
+	return m.Sum(nil)
+}
+
+// issue returns a token for deviceID, signed with the first key.
+func (dt deviceTokens) issue(deviceID string) string {
+	k := dt.keys[0]
+	payload := k.id + "." + base64.RawURLEncoding.EncodeToString([]byte(deviceID))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(k.mac(payload))
+}
+
+// verify checks a token and returns its device ID.
+func (dt deviceTokens) verify(token string) (string, error) {
+	z := strings.Split(token, ".")
+	if len(z) != 3 {
+		return "", errBadDeviceToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(z[2])
+	if err != nil {
+		return "", errBadDeviceToken
+	}
+	for _, k := range dt.keys {
+		if k.id != z[0] {
+			continue
+		}
+		if !hmac.Equal(sig, k.mac(z[0]+"."+z[1])) {
+			return "", errBadDeviceToken
+		}
+		id, err := base64.RawURLEncoding.DecodeString(z[1])
+		if err != nil || len(id) == 0 {
+			return "", errBadDeviceToken
+		}
+		return string(id), nil
+	}
+	// Signed with a key that has been rotated out.
+	return "", errBadDeviceToken
+}
+
+// formDeviceID returns the device a request comes from, identified by the
+// device_token form value, or in compat mode by a raw device_id. It returns
+// "" if the request names no device.
+func (s *Server) formDeviceID(r *http.Request) (string, *appError) {
+	if token := r.FormValue("device_token"); token != "" {
+		id, err := s.devices.verify(token)
+		if err != nil {
+			return "", &appError{Message: err.Error(), Code: http.StatusUnauthorized}
+		}
+		return id, nil
+	}
+	id := r.FormValue("device_id")
+	if id == "" {
+		return "", nil
+	}
+	if s.devices.mode != deviceAuthCompat {
+		return "", &appError{Message: "device_id must be signed, pass the device_token from Register", Code: http.StatusUnauthorized}
+	}
+	if strings.HasPrefix(id, registeredIDPrefix) {
+		return "", &appError{Message: "registered devices must pass their device_token", Code: http.StatusUnauthorized}
+	}
+	return id, nil
+}
+
+// requireDevice returns the device a request comes from, like formDeviceID,
+// and refuses requests from no device or a banned one.
+func (s *Server) requireDevice(r *http.Request) (string, *appError) {
+	deviceID, appErr := s.formDeviceID(r)
+	if appErr != nil {
+		return "", appErr
+	}
+	if deviceID == "" {
+		return "", &appError{Message: "no device_token or device_id", Code: http.StatusBadRequest}
+	}
+	if appErr := s.checkDevice(deviceID); appErr != nil {
+		return "", appErr
+	}
+	return deviceID, nil
+}
+
+// checkDevice refuses requests from banned devices.
+func (s *Server) checkDevice(deviceID string) *appError {
+	d, err := s.store.GetDevice([]byte(deviceID))
+	if err != nil {
+		glog.Errorf("%v", err)
+		return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+	}
+	if d != nil && d.BN != nil {
+		return &appError{Message: "device is banned", Code: http.StatusForbidden}
+	}
+	return nil
+}
+
+// DeviceTokenJSON is a registered device.
+type DeviceTokenJSON struct {
+	DeviceID string
+	Token    string // pass as device_token
+}
+
+// RegisterDevice registers a new device and returns its token, which the
+// device passes as device_token from then on. In compat mode, a client that
+// predates tokens can pass its device_id, to keep it and its votes. Each
+// device_id can only be registered once.
+//   curl http://localhost:8080/Register
+//   curl 'http://localhost:8080/Register?device_id=ddd'
+func (s *Server) RegisterDevice(w http.ResponseWriter, r *http.Request) *appError {
+	deviceID := r.FormValue("device_id")
+	switch {
+	case deviceID == "":
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+		}
+		deviceID = registeredIDPrefix + base64.RawURLEncoding.EncodeToString(b)
+	case s.devices.mode != deviceAuthCompat:
+		return &appError{Message: "device_id can no longer be registered, register without one", Code: http.StatusForbidden}
+	case strings.HasPrefix(deviceID, registeredIDPrefix):
+		return &appError{Message: "device_id can not start with " + registeredIDPrefix, Code: http.StatusBadRequest}
+	}
+
+	err := s.store.RegisterDevice([]byte(deviceID), time.Now())
+	if err == ErrDeviceExists {
+		return &appError{Message: err.Error(), Code: http.StatusConflict}
+	}
+	if err != nil {
+		glog.Errorf("%v", err)
+		return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+	}
+	json.NewEncoder(w).Encode(DeviceTokenJSON{DeviceID: deviceID, Token: s.devices.issue(deviceID)})
+	return nil
+}
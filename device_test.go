@@ -0,0 +1,123 @@
+package burstbooth
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/cardinalblue/burstbooth/util"
+)
+
+func TestDeviceTokenRotation(t *testing.T) {
+	old := deviceTokens{keys: []deviceKey{{id: "k1", secret: []byte("one")}}}
+	token := old.issue("ddd")
+	if id, err := old.verify(token); err != nil || id != "ddd" {
+		t.Fatalf("%v %q", err, id)
+	}
+	rotated := deviceTokens{keys: []deviceKey{{id: "k2", secret: []byte("two")}, old.keys[0]}}
+	if id, err := rotated.verify(token); err != nil || id != "ddd" {
+		t.Fatalf("%v %q", err, id)
+	}
+	if rotated.issue("ddd") == token {
+		t.Fatalf("issued with the old key")
+	}
+	dropped := deviceTokens{keys: rotated.keys[:1]}
+	for _, bad := range []string{token, token + "x", "k1.ZWVl." + token[len("k1.ZGRk."):], "k1.ZGRk", ""} {
+		if _, err := dropped.verify(bad); err != errBadDeviceToken {
+			t.Fatalf("%q: %v", bad, err)
+		}
+	}
+}
+
+func TestRegisterDevice(t *testing.T) {
+	s := NewServer(NewMemStore(), NewFSBlobStore(testBlobDir))
+	s.devices = deviceTokens{keys: []deviceKey{{id: "k1", secret: []byte("one")}}, mode: deviceAuthCompat}
+	mux := http.NewServeMux()
+	s.Register(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	p := postAndVoteNTimes(ts, "http://127.0.0.1/a.gif", 0)
+	key := base64.StdEncoding.EncodeToString(p.K.B)
+	vote := func(v url.Values) int {
+		v.Set("key", key)
+		resp, _, err := util.JSONReq3("POST", ts.URL+"/Vote?"+v.Encode(), nil)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		return resp.StatusCode
+	}
+	register := func() DeviceTokenJSON {
+		dev := DeviceTokenJSON{}
+		resp, _, err := util.JSONReq3("POST", ts.URL+"/Register", &dev)
+		if err != nil || resp.StatusCode != http.StatusOK || dev.Token == "" {
+			t.Fatalf("%v %+v %+v", err, resp, dev)
+		}
+		return dev
+	}
+
+	dev := register()
+
+	// Old clients can register the device_id they have, once.
+	legacy := DeviceTokenJSON{}
+	resp, _, err := util.JSONReq3("POST", ts.URL+"/Register?device_id=ddd", &legacy)
+	if err != nil || resp.StatusCode != http.StatusOK || legacy.DeviceID != "ddd" {
+		t.Fatalf("%v %+v %+v", err, resp, legacy)
+	}
+	resp, _, err = util.JSONReq3("POST", ts.URL+"/Register?device_id=ddd", nil)
+	if err != nil || resp.StatusCode != http.StatusConflict {
+		t.Fatalf("%v %+v", err, resp)
+	}
+
+	for _, c := range []struct {
+		v    url.Values
+		code int
+	}{
+		{url.Values{"device_token": {dev.Token}}, http.StatusOK},
+		{url.Values{"device_token": {legacy.Token}}, http.StatusOK},
+		{url.Values{"device_token": {dev.Token + "x"}}, http.StatusUnauthorized},
+		{url.Values{"device_id": {"eee"}}, http.StatusOK},
+		{url.Values{"device_id": {dev.DeviceID}}, http.StatusUnauthorized},
+	} {
+		if code := vote(c.v); code != c.code {
+			t.Fatalf("%+v: %d", c.v, code)
+		}
+	}
+
+	// Feeds show the votes of the device the token is for.
+	imgs := FeedJSON{}
+	util.JSONReq3("GET", ts.URL+"/Hot?"+url.Values{"device_token": {legacy.Token}}.Encode(), &imgs)
+	if len(imgs.Posts) != 1 || imgs.Posts[0].V != 1 || imgs.Posts[0].S.N != "3" {
+		t.Fatalf("%+v", imgs)
+	}
+
+	s.devices.mode = deviceAuthRequired
+	for _, c := range []struct {
+		v    url.Values
+		code int
+	}{
+		{url.Values{"device_token": {register().Token}}, http.StatusOK},
+		{url.Values{"device_id": {"fff"}}, http.StatusUnauthorized},
+	} {
+		if code := vote(c.v); code != c.code {
+			t.Fatalf("%+v: %d", c.v, code)
+		}
+	}
+	for _, path := range []string{"/Hot?device_id=eee", "/Register?device_id=fff", "/PostImg?device_id=eee&url=http%3A%2F%2F127.0.0.1%2Fa.gif"} {
+		resp, _, err := util.JSONReq3("POST", ts.URL+path, nil)
+		if err != nil || resp.StatusCode < 400 {
+			t.Fatalf("%s: %v %+v", path, err, resp)
+		}
+	}
+	// Posts must come from a device.
+	post := url.Values{"url": {"http://127.0.0.1/a.gif"}}
+	if resp, _, err := util.JSONReq3("POST", ts.URL+"/PostImg?"+post.Encode(), nil); err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("%v %+v", err, resp)
+	}
+	post.Set("device_token", dev.Token)
+	if resp, _, err := util.JSONReq3("POST", ts.URL+"/PostImg?"+post.Encode(), nil); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("%v %+v", err, resp)
+	}
+}
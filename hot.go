@@ -0,0 +1,78 @@
+package burstbooth
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// hotRanker computes the H attribute that orders the Hot feed. Like reddit's
+// ranking, a post's hot score grows with the logarithm of its net votes and
+// linearly with its creation time, so newer posts need fewer votes to rank
+// above older ones. Because the score only depends on the votes and the
+// creation time it never has to be recomputed as posts age, which lets it be
+// the range key of an index.
+type hotRanker struct {
+	// epoch is the unix time, in seconds, that creation times are measured
+	// from. It only has to be fixed per deployment.
+	epoch int64
+	// decay is the number of seconds of age that are worth a tenfold
+	// increase in votes.
+	decay float64
+}
+
+// defaultHotRanker is configured with the HOT_EPOCH and HOT_DECAY
+// environment variables.
+var defaultHotRanker = hotRanker{
+	epoch: int64(envFloat("HOT_EPOCH", 1420070400)), // 2015-01-01
+	decay: envFloat("HOT_DECAY", 45000),             // 12.5 hours
+}
+
+func envFloat(name string, def float64) float64 {
+	s := os.Getenv(name)
+	if s == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		glog.Fatalf("invalid %s %q: %v", name, s, err)
+	}
+	return f
+}
+
+// score returns the hot score of a post with the given net votes and key.
+func (h hotRanker) score(votes int, key []byte) float64 {
+	order := math.Log10(1 + math.Abs(float64(votes)))
+	if votes < 0 {
+		order = -order
+	}
+	age := float64(postTime(key).Unix() - h.epoch)
+	return order + age/h.decay
+}
+
+// scoreN returns the hot score formatted as a DynamoDB number.
+func (h hotRanker) scoreN(votes int, key []byte) string {
+	return strconv.FormatFloat(h.score(votes, key), 'f', -1, 64)
+}
+
+// postKey returns the key of a post created at t.
+func postKey(t time.Time) ([]byte, error) {
+	buf := bytes.NewBuffer([]byte{})
+	if err := binary.Write(buf, binary.BigEndian, t.UnixNano()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// postTime returns the creation time encoded in a post key.
+func postTime(key []byte) time.Time {
+	if len(key) < 8 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key)))
+}
@@ -0,0 +1,69 @@
+package burstbooth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cardinalblue/burstbooth/aws"
+)
+
+func TestHotRanker(t *testing.T) {
+	h := hotRanker{epoch: 1420070400, decay: 45000}
+	now := time.Unix(1420070400+100*86400, 0)
+	old, _ := postKey(now.Add(-48 * time.Hour))
+	recent, _ := postKey(now)
+
+	if h.score(10, recent) <= h.score(9, recent) {
+		t.Fatalf("more votes should rank higher")
+	}
+	if h.score(0, recent) <= h.score(0, old) {
+		t.Fatalf("newer posts should rank higher")
+	}
+	// 48 hours is worth a bit less than four orders of magnitude of votes.
+	if h.score(10, recent) <= h.score(1000, old) {
+		t.Fatalf("an old post should not stay on top")
+	}
+	if h.score(10000, old) <= h.score(0, recent) {
+		t.Fatalf("enough votes should beat age")
+	}
+	if h.score(-5, recent) >= h.score(0, recent) {
+		t.Fatalf("downvoted posts should rank lower")
+	}
+}
+
+func TestBackfillHot(t *testing.T) {
+	setup(t)
+	store := &ddbStore{tables: ddbTables}
+	h := hotRanker{epoch: 1420070400, decay: 45000}
+
+	// A post from before hot scores.
+	key, _ := postKey(time.Now())
+	put := struct {
+		TableName string
+		Item      struct {
+			I, URL struct{ S string }
+			K      struct{ B []byte }
+			S      struct{ N string }
+		}
+	}{TableName: ddbTables.Post}
+	put.Item.I.S = postTypeGIF
+	put.Item.URL.S = "http://127.0.0.1/old.gif"
+	put.Item.K.B = key
+	put.Item.S.N = "2"
+	if err := aws.DynamoDBPost("PutItem", put, nil); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if page, err := store.HotPosts(FeedQuery{Type: postTypeGIF, Limit: 10}); err != nil || len(page.Posts) != 0 {
+		t.Fatalf("%v %+v", err, page)
+	}
+
+	for _, want := range []int{1, 0} {
+		if n, err := store.backfillHot(h); err != nil || n != want {
+			t.Fatalf("%v %d, want %d", err, n, want)
+		}
+	}
+	page, err := store.HotPosts(FeedQuery{Type: postTypeGIF, Limit: 10})
+	if err != nil || len(page.Posts) != 1 || page.Posts[0].H.N != h.scoreN(2, key) {
+		t.Fatalf("%v %+v", err, page)
+	}
+}
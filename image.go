@@ -0,0 +1,273 @@
+package burstbooth
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// maxImgBytes bounds the size of an image, uploaded or fetched.
+	maxImgBytes = 10 << 20
+	// maxImgPixels bounds the area of an image, or of the logical screen of
+	// a GIF, which is checked before decoding, as a small file can decode
+	// to gigabytes.
+	maxImgPixels = 24 << 20
+	// maxGIFPixels bounds the area of all the frames of a GIF together.
+	maxGIFPixels = 64 << 20
+)
+
+var (
+	errNotImg      = errors.New("not a GIF or JPEG image")
+	errImgTooBig   = fmt.Errorf("image larger than %d bytes", maxImgBytes)
+	errImgTooLarge = fmt.Errorf("image larger than %d pixels", maxImgPixels)
+	// errImgFetch is all clients learn about failed downloads, so the
+	// server can not be used to probe other hosts.
+	errImgFetch = errors.New("can not fetch image")
+)
+
+// maxImgRedirects bounds the redirects followed when fetching an image.
+const maxImgRedirects = 5
+
+// imgClient fetches the images of posts made by URL. It only connects to
+// public addresses, including when redirected, so clients can not make it
+// fetch from the EC2 metadata service or hosts inside the VPC.
+var imgClient = &http.Client{
+	Timeout:       10 * time.Second,
+	Transport:     newImgTransport(),
+	CheckRedirect: checkImgRedirect,
+}
+
+func newImgTransport() *http.Transport {
+	d := &net.Dialer{Timeout: 5 * time.Second, Control: dialPublicOnly}
+	// No Proxy: it would connect on behalf of the server, unchecked.
+	return &http.Transport{DialContext: d.DialContext, TLSHandshakeTimeout: 5 * time.Second}
+}
+
+func checkImgRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxImgRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxImgRedirects)
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("unsupported redirect to %q", req.URL)
+	}
+	return nil
+}
+
+// nonPublicNets are the ranges that isPublicIP rejects beyond those the
+// net.IP methods know.
+var nonPublicNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",     // this network
+		"100.64.0.0/10", // carrier-grade NAT
+		"192.0.0.0/24",  // IETF protocol assignments
+		"198.18.0.0/15", // benchmarking
+		"240.0.0.0/4",   // reserved
+	} {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// isPublicIP reports whether ip is a unicast address on the internet.
+func isPublicIP(ip net.IP) bool {
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, n := range nonPublicNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialPublicOnly is a net.Dialer Control function that refuses connections
+// to addresses that are not public. It sees the resolved address, so host
+// names that resolve to internal addresses are refused too.
+func dialPublicOnly(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("refusing to connect to %s", address)
+	}
+	return nil
+}
+
+// imgMeta describes a decoded image.
+type imgMeta struct {
+	ContentType string
+	Ext         string // file extension for ContentType
+
+	Width    int
+	Height   int
+	Frames   int
+	Duration time.Duration // of one loop of an animation, 0 for stills
+
+	poster image.Image // see posterFrame
+}
+
+// decodeImg decodes a GIF or JPEG and returns its metadata. It returns
+// errNotImg for anything else, and for images that do not decode, and
+// errImgTooLarge for images over maxImgPixels or GIFs over maxGIFPixels.
+func decodeImg(data []byte) (imgMeta, error) {
+	meta := imgMeta{ContentType: http.DetectContentType(data)}
+	switch meta.ContentType {
+	case "image/gif":
+		c, err := gif.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return meta, errNotImg
+		}
+		if int64(c.Width)*int64(c.Height) > maxImgPixels || gifFramePixels(data) > maxGIFPixels {
+			return meta, errImgTooLarge
+		}
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil || len(g.Image) == 0 {
+			return meta, errNotImg
+		}
+		meta.Ext = ".gif"
+		meta.Width, meta.Height = g.Config.Width, g.Config.Height
+		if meta.Width == 0 || meta.Height == 0 {
+			// Some encoders leave the logical screen size empty.
+			b := image.Rectangle{}
+			for _, f := range g.Image {
+				b = b.Union(f.Bounds())
+			}
+			meta.Width, meta.Height = b.Dx(), b.Dy()
+		}
+		if int64(meta.Width)*int64(meta.Height) > maxImgPixels {
+			// posterFrame draws on a canvas of this size.
+			return meta, errImgTooLarge
+		}
+		meta.Frames = len(g.Image)
+		meta.poster = posterFrame(g)
+		for _, d := range g.Delay {
+			meta.Duration += time.Duration(d) * 10 * time.Millisecond
+		}
+	case "image/jpeg":
+		c, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return meta, errNotImg
+		}
+		if int64(c.Width)*int64(c.Height) > maxImgPixels {
+			return meta, errImgTooLarge
+		}
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return meta, errNotImg
+		}
+		meta.Ext = ".jpg"
+		meta.Width, meta.Height = img.Bounds().Dx(), img.Bounds().Dy()
+		meta.Frames = 1
+		meta.poster = img
+	default:
+		return meta, errNotImg
+	}
+	return meta, nil
+}
+
+// gifFramePixels returns the area of all the frames of a GIF together, read
+// from their image descriptors without decoding them. It stops at the end of
+// data, or at anything that is not a GIF block, and leaves reporting those
+// to the decoder.
+func gifFramePixels(data []byte) int64 {
+	if len(data) < 13 {
+		return 0
+	}
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&7 + 1) // global color table
+	}
+	// skipBlocks skips the data sub-blocks starting at i.
+	skipBlocks := func() {
+		for i < len(data) && data[i] != 0 {
+			i += 1 + int(data[i])
+		}
+		i++
+	}
+	var pixels int64
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // extension
+			i += 2
+			skipBlocks()
+		case 0x2c: // image descriptor
+			if i+10 > len(data) {
+				return pixels
+			}
+			w := int64(data[i+5]) | int64(data[i+6])<<8
+			h := int64(data[i+7]) | int64(data[i+8])<<8
+			pixels += w * h
+			if data[i+9]&0x80 != 0 {
+				i += 3 << (data[i+9]&7 + 1) // local color table
+			}
+			i += 11 // descriptor and LZW code size
+			skipBlocks()
+		default: // trailer or garbage
+			return pixels
+		}
+	}
+	return pixels
+}
+
+// setOn records the metadata as attributes of post.
+func (m imgMeta) setOn(post *PostDDB) {
+	post.W = &struct{ N string }{N: strconv.Itoa(m.Width)}
+	post.Ht = &struct{ N string }{N: strconv.Itoa(m.Height)}
+	post.F = &struct{ N string }{N: strconv.Itoa(m.Frames)}
+	post.Ms = &struct{ N string }{N: strconv.FormatInt(int64(m.Duration/time.Millisecond), 10)}
+}
+
+// fetchImg downloads the image at rawurl. It returns errImgTooBig for
+// images larger than maxImgBytes, and errImgFetch when the download fails.
+func fetchImg(rawurl string) ([]byte, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported url %q", rawurl)
+	}
+	resp, err := imgClient.Get(u.String())
+	if err != nil {
+		glog.Warningf("fetching %s: %v", rawurl, err)
+		return nil, errImgFetch
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		glog.Warningf("fetching %s: %s", rawurl, resp.Status)
+		return nil, errImgFetch
+	}
+	if resp.ContentLength > maxImgBytes {
+		return nil, errImgTooBig
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxImgBytes+1))
+	if err != nil {
+		glog.Warningf("fetching %s: %v", rawurl, err)
+		return nil, errImgFetch
+	}
+	if len(data) > maxImgBytes {
+		return nil, errImgTooBig
+	}
+	return data, nil
+}
@@ -0,0 +1,206 @@
+package burstbooth
+
+import (
+	"bytes"
+	"image"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cardinalblue/burstbooth/util"
+)
+
+// testImgTransport serves images for the URLs of test posts, which point at
+// 127.0.0.1. Paths containing "missing" are not found, paths ending in ".txt"
+// are text, and paths containing "photo" are JPEGs. Anything else is a
+// 3-frame GIF.
+type testImgTransport struct{}
+
+func (testImgTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != "127.0.0.1" {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+	rec := httptest.NewRecorder()
+	switch {
+	case strings.Contains(req.URL.Path, "missing"):
+		http.NotFound(rec, req)
+	case strings.HasSuffix(req.URL.Path, ".txt"):
+		rec.Write([]byte("not an image"))
+	case strings.Contains(req.URL.Path, "photo"):
+		rec.Write(encodeTestJPEG(6, 5))
+	default:
+		rec.Write(encodeTestGIF(8, 6, 3, 10))
+	}
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+// encodeTestGIF returns a width by height GIF with frames frames, each shown
+// for delay hundredths of a second.
+func encodeTestGIF(width, height, frames, delay int) []byte {
+	g := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, width, height), palette.Plan9))
+		g.Delay = append(g.Delay, delay)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := gif.EncodeAll(buf, g); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// encodeHeaderGIF returns a GIF with a width by height logical screen and
+// frames frames of frameWidth by frameHeight, with image data that only
+// decodes for 1 by 1 frames. It costs a few bytes however large it claims to
+// be.
+func encodeHeaderGIF(width, height, frames, frameWidth, frameHeight int) []byte {
+	le := func(n int) []byte { return []byte{byte(n), byte(n >> 8)} }
+	b := append([]byte("GIF89a"), le(width)...)
+	b = append(b, le(height)...)
+	b = append(b, 0, 0, 0)
+	for i := 0; i < frames; i++ {
+		b = append(b, 0x2c, 0, 0, 0, 0)
+		b = append(b, le(frameWidth)...)
+		b = append(b, le(frameHeight)...)
+		b = append(b, 0x80, 0, 0, 0, 0xff, 0xff, 0xff, 2, 2, 0x4c, 0x01, 0)
+	}
+	return append(b, 0x3b)
+}
+
+func encodeTestJPEG(width, height int) []byte {
+	buf := bytes.NewBuffer(nil)
+	if err := jpeg.Encode(buf, image.NewGray(image.Rect(0, 0, width, height)), nil); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeImg(t *testing.T) {
+	meta, err := decodeImg(encodeTestGIF(8, 6, 3, 10))
+	if err != nil || meta.ContentType != "image/gif" || meta.Width != 8 || meta.Height != 6 || meta.Frames != 3 || meta.Duration != 300*time.Millisecond {
+		t.Fatalf("%v %+v", err, meta)
+	}
+	meta, err = decodeImg(encodeTestJPEG(6, 5))
+	if err != nil || meta.ContentType != "image/jpeg" || meta.Width != 6 || meta.Height != 5 || meta.Frames != 1 || meta.Duration != 0 {
+		t.Fatalf("%v %+v", err, meta)
+	}
+	gifData := encodeTestGIF(8, 6, 3, 10)
+	for _, data := range [][]byte{[]byte("not an image"), gifData[:len(gifData)/2]} {
+		if _, err := decodeImg(data); err != errNotImg {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	if meta, err := decodeImg(encodeHeaderGIF(1, 1, 2, 1, 1)); err != nil || meta.Frames != 2 {
+		t.Fatalf("%v %+v", err, meta)
+	}
+	// Sizes are checked before anything is decoded.
+	jpegData := encodeTestJPEG(6, 5)
+	sof := bytes.Index(jpegData, []byte{0xff, 0xc0})
+	copy(jpegData[sof+5:], []byte{0x60, 0, 0x60, 0}) // 24576 by 24576
+	for _, data := range [][]byte{
+		encodeHeaderGIF(6000, 5000, 1, 1, 1),
+		encodeHeaderGIF(4096, 4096, 5, 4096, 4096),
+		jpegData,
+	} {
+		if _, err := decodeImg(data); err != errImgTooLarge {
+			t.Fatalf("%v", err)
+		}
+	}
+	if n := gifFramePixels(encodeTestGIF(8, 6, 3, 10)); n != 144 {
+		t.Fatalf("%d", n)
+	}
+}
+
+func TestImgClientPublicOnly(t *testing.T) {
+	for addr, public := range map[string]bool{
+		"93.184.216.34:80":      true,
+		"[2606:4700::1]:443":    true,
+		"127.0.0.1:80":          false,
+		"10.1.2.3:80":           false,
+		"172.16.0.1:80":         false,
+		"192.168.1.1:80":        false,
+		"169.254.169.254:80":    false,
+		"100.64.0.1:80":         false,
+		"0.0.0.0:80":            false,
+		"[::1]:80":              false,
+		"[fd00::1]:80":          false,
+		"[fe80::1]:80":          false,
+		"[::ffff:127.0.0.1]:80": false,
+	} {
+		if err := dialPublicOnly("tcp", addr, nil); (err == nil) != public {
+			t.Errorf("%s: %v", addr, err)
+		}
+	}
+
+	// The test server is on a loopback address.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(encodeTestGIF(1, 1, 1, 0))
+	}))
+	defer ts.Close()
+	c := &http.Client{Transport: newImgTransport(), CheckRedirect: checkImgRedirect}
+	if _, err := c.Get(ts.URL); err == nil {
+		t.Fatalf("fetched %s", ts.URL)
+	}
+
+	via := make([]*http.Request, maxImgRedirects)
+	req, _ := http.NewRequest("GET", "http://example.com/a.gif", nil)
+	if err := checkImgRedirect(req, via[:1]); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := checkImgRedirect(req, via); err == nil {
+		t.Fatalf("followed %d redirects", len(via))
+	}
+	req.URL.Scheme = "file"
+	if err := checkImgRedirect(req, via[:1]); err == nil {
+		t.Fatalf("followed %s", req.URL)
+	}
+}
+
+func TestPostImgMeta(t *testing.T) {
+	ts := newMemTestServer()
+	defer ts.Close()
+
+	for u, code := range map[string]int{
+		"http://127.0.0.1/missing.gif": http.StatusBadRequest,
+		"http://127.0.0.1/a.txt":       http.StatusUnsupportedMediaType,
+		"file:///etc/passwd":           http.StatusBadRequest,
+		"":                             http.StatusBadRequest,
+	} {
+		v := url.Values{"url": {u}}
+		resp, _, err := util.JSONReq3("POST", ts.URL+"/PostImg?"+v.Encode(), nil)
+		if err != nil || resp.StatusCode != code {
+			t.Fatalf("%s: %v %+v", u, err, resp)
+		}
+	}
+
+	postAndVoteNTimes(ts, "http://127.0.0.1/a.gif", 0)
+	imgs := struct{ Posts []PostJSON }{}
+	util.JSONReq3("GET", ts.URL+"/New", &imgs)
+	if len(imgs.Posts) != 1 {
+		t.Fatalf("%+v", imgs)
+	}
+	p := imgs.Posts[0]
+	if p.W.N != "8" || p.Ht.N != "6" || p.F.N != "3" || p.Ms.N != "300" {
+		t.Fatalf("%+v", p)
+	}
+
+	v := url.Values{"type": {"photo"}, "url": {"http://127.0.0.1/photo.jpg"}}
+	util.JSONReq3("POST", ts.URL+"/PostImg?"+v.Encode(), nil)
+	util.JSONReq3("GET", ts.URL+"/New?type=photo", &imgs)
+	if len(imgs.Posts) != 1 {
+		t.Fatalf("%+v", imgs)
+	}
+	p = imgs.Posts[0]
+	if p.W.N != "6" || p.Ht.N != "5" || p.F.N != "1" || p.Ms.N != "0" {
+		t.Fatalf("%+v", p)
+	}
+}
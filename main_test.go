@@ -0,0 +1,74 @@
+package burstbooth
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/golang/glog"
+
+	"github.com/cardinalblue/burstbooth/aws"
+	"github.com/cardinalblue/burstbooth/aws/dynamodbtest"
+)
+
+var (
+	ddbLocal = flag.Bool("ddblocal", false, "run the tests against DynamoDB Local instead of an in-process fake")
+
+	// fakeDDB is the fake the tests run against, nil with -ddblocal.
+	fakeDDB *dynamodbtest.DB
+
+	// testBlobDir is a temporary directory for filesystem blob stores.
+	testBlobDir string
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	os.Exit(runTests(m))
+}
+
+func runTests(m *testing.M) int {
+	var err error
+	testBlobDir, err = ioutil.TempDir("", "burstbooth-test")
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(testBlobDir)
+	imgClient.Transport = testImgTransport{}
+	// The tests make more requests from one address than the default limits
+	// allow. TestRateLimit sets its own.
+	defaultRateLimiter.limits = map[string]endpointLimits{}
+
+	if !*ddbLocal {
+		fakeDDB = dynamodbtest.New()
+		ts := httptest.NewServer(fakeDDB)
+		defer ts.Close()
+		if err := aws.SetDynamoDBEndpoint(ts.URL); err != nil {
+			glog.Fatalf("%v", err)
+		}
+	}
+	return m.Run()
+}
+
+// forEachStore recreates the DynamoDB tables and runs test as a subtest
+// against a Server on the DynamoDB store and one on the in-memory store. ts
+// serves s, and is closed when the subtest ends. Handlers read the fields of
+// s as they serve requests, so test can still set them up.
+func forEachStore(t *testing.T, test func(t *testing.T, s *Server, ts *httptest.Server)) {
+	setup(t)
+	for _, c := range []struct {
+		name  string
+		store Store
+	}{{"ddb", NewDDBStore(ddbTables)}, {"mem", NewMemStore()}} {
+		t.Run(c.name, func(t *testing.T) {
+			s := NewServer(c.store, NewFSBlobStore(testBlobDir))
+			mux := http.NewServeMux()
+			s.Register(mux)
+			ts := httptest.NewServer(mux)
+			t.Cleanup(ts.Close)
+			test(t, s, ts)
+		})
+	}
+}
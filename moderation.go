@@ -0,0 +1,294 @@
+package burstbooth
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/golang/glog"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Caption policies decide what happens to a caption with a blocked word.
+const (
+	captionReject = "reject" // refuse the post
+	captionMask   = "mask"   // replace the blocked words with *
+	captionHold   = "hold"   // keep the post out of the feeds until it is reviewed
+)
+
+// postStateHeld is the moderation state, the X attribute, of a post that is
+// held for review.
+const postStateHeld = "held"
+
+// defaultCaptionMaxLen is the default limit on the length of a caption, in
+// characters.
+const defaultCaptionMaxLen = 140
+
+var (
+	errCaptionTooLong = errors.New("caption too long")
+	errCaptionBlocked = errors.New("caption not allowed")
+)
+
+// captionModerator normalizes captions and checks them against block and
+// allow lists. Words are compared by their skeleton, see captionSkeleton, so
+// that a blocked word still matches with accents, homoglyphs, leetspeak,
+// repeated letters or separators between its letters.
+type captionModerator struct {
+	maxLen int
+	policy string
+	block  *regexp.Regexp  // matches blocked skeletons, nil if nothing is blocked
+	allow  map[string]bool // skeletons of words that are never blocked
+}
+
+// newCaptionModerator returns a captionModerator applying policy to
+// captions with a word of block that is not in allow. A word of block may
+// start or end with * to also block words that end or start with it.
+func newCaptionModerator(maxLen int, policy string, block, allow []string) (*captionModerator, error) {
+	switch policy {
+	case captionReject, captionMask, captionHold:
+	default:
+		return nil, fmt.Errorf("unknown caption policy %q", policy)
+	}
+	m := &captionModerator{maxLen: maxLen, policy: policy, allow: map[string]bool{}}
+
+	var alts []string
+	for _, w := range block {
+		alt := ""
+		for _, r := range captionSkeleton(strings.Trim(w, "*")) {
+			// Any number of repeats of a letter match it.
+			alt += regexp.QuoteMeta(string(r)) + "+"
+		}
+		if alt == "" {
+			continue
+		}
+		if strings.HasPrefix(w, "*") {
+			alt = ".*" + alt
+		}
+		if strings.HasSuffix(w, "*") {
+			alt += ".*"
+		}
+		alts = append(alts, alt)
+	}
+	if len(alts) > 0 {
+		m.block = regexp.MustCompile("^(?:" + strings.Join(alts, "|") + ")$")
+	}
+	for _, w := range allow {
+		m.allow[captionSkeleton(w)] = true
+	}
+	return m, nil
+}
+
+var defaultCaptionModerator = captionModeratorFromEnv()
+
+// captionModeratorFromEnv configures a captionModerator from the
+// environment:
+//
+//	CAPTION_MAX_LENGTH: characters in a caption, 140 by default
+//	CAPTION_POLICY: reject, mask, the default, or hold
+//	CAPTION_BLOCKLIST: file of blocked words, one per line
+//	CAPTION_ALLOWLIST: file of words that are never blocked, one per line
+func captionModeratorFromEnv() *captionModerator {
+	maxLen := defaultCaptionMaxLen
+	if v := os.Getenv("CAPTION_MAX_LENGTH"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			glog.Fatalf("bad CAPTION_MAX_LENGTH %q", v)
+		}
+		maxLen = n
+	}
+	policy := os.Getenv("CAPTION_POLICY")
+	if policy == "" {
+		policy = captionMask
+	}
+	block, err := readWordList(os.Getenv("CAPTION_BLOCKLIST"))
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
+	allow, err := readWordList(os.Getenv("CAPTION_ALLOWLIST"))
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
+	m, err := newCaptionModerator(maxLen, policy, block, allow)
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
+	return m
+}
+
+// readWordList reads a file with a word on each line. Empty lines and lines
+// starting with # are skipped. An empty path is an empty list.
+func readWordList(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var words []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		w := strings.TrimSpace(sc.Text())
+		if w == "" || strings.HasPrefix(w, "#") {
+			continue
+		}
+		words = append(words, w)
+	}
+	return words, sc.Err()
+}
+
+// moderate returns the caption to store and whether to hold the post for
+// review. It returns errCaptionTooLong or errCaptionBlocked for captions
+// that are refused.
+func (m *captionModerator) moderate(caption string) (string, bool, error) {
+	caption = normalizeCaption(caption)
+	if utf8.RuneCountInString(caption) > m.maxLen {
+		return "", false, errCaptionTooLong
+	}
+	blocked := m.blockedSpans(caption)
+	if len(blocked) == 0 {
+		return caption, false, nil
+	}
+	switch m.policy {
+	case captionMask:
+		return maskSpans(caption, blocked), false, nil
+	case captionHold:
+		return caption, true, nil
+	}
+	return "", false, errCaptionBlocked
+}
+
+// normalizeCaption puts a caption in Unicode NFKC form, drops control and
+// invisible formatting characters, and collapses white space.
+func normalizeCaption(caption string) string {
+	caption = norm.NFKC.String(strings.ToValidUTF8(caption, ""))
+	b := strings.Builder{}
+	space := false
+	for _, r := range caption {
+		switch {
+		case unicode.IsSpace(r):
+			space = true
+			continue
+		case r == '\u200d':
+			// Keep the zero width joiner of emoji sequences.
+		case unicode.Is(unicode.Cc, r) || unicode.Is(unicode.Cf, r):
+			continue
+		}
+		if space && b.Len() > 0 {
+			b.WriteRune(' ')
+		}
+		space = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// captionFold maps characters that stand in for letters to the letter. l and
+// 1 are both folded to i, as they stand in for each other.
+var captionFold = map[rune]rune{
+	'0': 'o', '1': 'i', '!': 'i', '|': 'i', 'l': 'i', '3': 'e', '4': 'a',
+	'@': 'a', '5': 's', '$': 's', '7': 't', '+': 't', '8': 'b', '9': 'g',
+	// Cyrillic look-alikes
+	'а': 'a', 'е': 'e', 'о': 'o', 'р': 'p', 'с': 'c', 'х': 'x', 'у': 'y',
+	'і': 'i', 'ѕ': 's', 'к': 'k',
+}
+
+// captionSkeleton reduces a word to the letters it reads as: without
+// accents, in lower case and with look-alikes folded.
+func captionSkeleton(word string) string {
+	b := strings.Builder{}
+	for _, r := range norm.NFKD.String(word) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		r = unicode.ToLower(r)
+		if f, ok := captionFold[r]; ok {
+			r = f
+		}
+		if unicode.IsLetter(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// isWordRune reports whether r can be part of a word, including the
+// symbols of captionFold.
+func isWordRune(r rune) bool {
+	_, fold := captionFold[r]
+	return fold || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
+
+// span is a range of byte offsets.
+type span struct{ start, end int }
+
+// blockedSpans returns the spans of caption with a blocked word.
+func (m *captionModerator) blockedSpans(caption string) []span {
+	if m.block == nil {
+		return nil
+	}
+	var words []span
+	start := -1
+	for i, r := range caption {
+		switch {
+		case isWordRune(r) && start < 0:
+			start = i
+		case !isWordRune(r) && start >= 0:
+			words = append(words, span{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, span{start, len(caption)})
+	}
+
+	blockedSkeleton := func(sk string) bool {
+		return sk != "" && !m.allow[sk] && m.block.MatchString(sk)
+	}
+	blocked := func(s string) bool {
+		// Symbols at the ends of a word may be punctuation, as in "bad!", or
+		// letters, as in "$ad".
+		trimmed := strings.TrimFunc(s, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+		return blockedSkeleton(captionSkeleton(s)) || blockedSkeleton(captionSkeleton(trimmed))
+	}
+	var spans []span
+	for i := 0; i < len(words); i++ {
+		w := words[i]
+		if blocked(caption[w.start:w.end]) {
+			spans = append(spans, w)
+			continue
+		}
+		// Single letters in a row, like "b a d" or "b.a.d", spell a word.
+		j := i
+		letters := ""
+		for j < len(words) && utf8.RuneCountInString(caption[words[j].start:words[j].end]) == 1 {
+			letters += caption[words[j].start:words[j].end]
+			j++
+		}
+		if j-i > 1 && blocked(letters) {
+			spans = append(spans, words[i:j]...)
+			i = j - 1
+		}
+	}
+	return spans
+}
+
+// maskSpans replaces the characters in spans of s with *.
+func maskSpans(s string, spans []span) string {
+	b := strings.Builder{}
+	last := 0
+	for _, sp := range spans {
+		b.WriteString(s[last:sp.start])
+		b.WriteString(strings.Repeat("*", utf8.RuneCountInString(s[sp.start:sp.end])))
+		last = sp.end
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
@@ -0,0 +1,78 @@
+package burstbooth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/cardinalblue/burstbooth/util"
+)
+
+func TestModerateCaption(t *testing.T) {
+	m, err := newCaptionModerator(20, captionMask, []string{"bad*", "*heck", "ass"}, []string{"badminton"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for in, want := range map[string]string{
+		"ｈｅｌｌｏ  \t world\u200b": "hello world",
+		"so bad":                "so ***",
+		"so BAAAD":              "so *****",
+		"b4d":                   "***",
+		"b.a.d day":             "*.*.* day",
+		"bаd":                   "***", // Cyrillic а
+		"bád":                   "***",
+		"bad!":                  "****",
+		"badly":                 "*****",
+		"badminton":             "badminton",
+		"oh heck":               "oh ****",
+		"as we go":              "as we go",
+		"a$$":                   "***",
+	} {
+		got, held, err := m.moderate(in)
+		if err != nil || held || got != want {
+			t.Errorf("%q: %v %v %q, want %q", in, err, held, got, want)
+		}
+	}
+	if _, _, err := m.moderate(strings.Repeat("ü", 21)); err != errCaptionTooLong {
+		t.Fatalf("%v", err)
+	}
+
+	m.policy = captionReject
+	if _, _, err := m.moderate("so bad"); err != errCaptionBlocked {
+		t.Fatalf("%v", err)
+	}
+	m.policy = captionHold
+	if c, held, err := m.moderate("so  bad"); err != nil || !held || c != "so bad" {
+		t.Fatalf("%v %v %q", err, held, c)
+	}
+}
+
+func TestHeldPost(t *testing.T) {
+	m, err := newCaptionModerator(defaultCaptionMaxLen, captionHold, []string{"bad"}, nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	forEachStore(t, func(t *testing.T, s *Server, ts *httptest.Server) {
+		s.captions = m
+
+		for _, caption := range []string{"good", "bad"} {
+			v := url.Values{"url": {"http://127.0.0.1/" + caption + ".gif"}, "caption": {caption}}
+			p := PostDDB{}
+			resp, _, err := util.JSONReq3("POST", ts.URL+"/PostImg?"+v.Encode(), &p)
+			if err != nil || resp.StatusCode != http.StatusOK || (p.X != nil) != (caption == "bad") {
+				t.Fatalf("%v %+v %+v", err, resp, p)
+			}
+		}
+
+		// Held posts are left out of the feeds.
+		for _, feed := range []string{"/Hot", "/New"} {
+			imgs := FeedJSON{}
+			util.JSONReq3("GET", ts.URL+feed, &imgs)
+			if len(imgs.Posts) != 1 || imgs.Posts[0].C.S != "good" {
+				t.Fatalf("%s: %+v", feed, imgs)
+			}
+		}
+	})
+}
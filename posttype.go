@@ -0,0 +1,66 @@
+package burstbooth
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const (
+	postTypeGIF   = "gif"
+	postTypePhoto = "photo"
+	postTypeBurst = "burst"
+)
+
+const (
+	// minBurstFrames and maxBurstFrames bound the length of a burst.
+	minBurstFrames = 2
+	maxBurstFrames = 30
+)
+
+// postType is a kind of post. Every type is a partition of the post table,
+// keyed by the I attribute, and has its own feeds.
+type postType struct {
+	// validate checks that an image can be posted as this type.
+	validate func(meta imgMeta) error
+}
+
+// postTypes are the types posts can have, by name.
+var postTypes = map[string]postType{
+	// An animated or still GIF.
+	postTypeGIF: {validate: func(meta imgMeta) error {
+		if meta.ContentType != "image/gif" {
+			return fmt.Errorf("a %s post must be a GIF, not %s", postTypeGIF, meta.ContentType)
+		}
+		return nil
+	}},
+	// A single photo.
+	postTypePhoto: {validate: func(meta imgMeta) error {
+		if meta.Frames != 1 {
+			return fmt.Errorf("a %s post must have one frame, not %d", postTypePhoto, meta.Frames)
+		}
+		return nil
+	}},
+	// A short sequence of photos taken in a burst, animated as a GIF.
+	postTypeBurst: {validate: func(meta imgMeta) error {
+		if meta.ContentType != "image/gif" {
+			return fmt.Errorf("a %s post must be a GIF, not %s", postTypeBurst, meta.ContentType)
+		}
+		if meta.Frames < minBurstFrames || meta.Frames > maxBurstFrames {
+			return fmt.Errorf("a %s post must have %d to %d frames, not %d", postTypeBurst, minBurstFrames, maxBurstFrames, meta.Frames)
+		}
+		return nil
+	}},
+}
+
+// formPostType returns the post type named by the type form value. Clients
+// that predate post types leave it out, and get gif.
+func formPostType(r *http.Request) (string, *appError) {
+	name := r.FormValue("type")
+	if name == "" {
+		return postTypeGIF, nil
+	}
+	if _, ok := postTypes[name]; !ok {
+		return "", &appError{Message: fmt.Sprintf("unknown type %q", name), Code: http.StatusBadRequest}
+	}
+	return name, nil
+}
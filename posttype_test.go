@@ -0,0 +1,71 @@
+package burstbooth
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/cardinalblue/burstbooth/util"
+)
+
+func TestPostTypes(t *testing.T) {
+	setup(t)
+	ts := httptest.NewServer(http.DefaultServeMux)
+	defer ts.Close()
+
+	for _, c := range []struct {
+		typ, url string
+		code     int
+	}{
+		{"", "http://127.0.0.1/a.gif", http.StatusOK},
+		{"gif", "http://127.0.0.1/photo.jpg", http.StatusBadRequest},
+		{"photo", "http://127.0.0.1/photo.jpg", http.StatusOK},
+		{"photo", "http://127.0.0.1/a.gif", http.StatusBadRequest},
+		{"burst", "http://127.0.0.1/b.gif", http.StatusOK},
+		{"burst", "http://127.0.0.1/photo.jpg", http.StatusBadRequest},
+		{"video", "http://127.0.0.1/a.gif", http.StatusBadRequest},
+	} {
+		v := url.Values{"type": {c.typ}, "url": {c.url}}
+		resp, _, err := util.JSONReq3("POST", ts.URL+"/PostImg?"+v.Encode(), nil)
+		if err != nil || resp.StatusCode != c.code {
+			t.Fatalf("%+v: %v %+v", c, err, resp)
+		}
+	}
+
+	// Every type has its own feed.
+	feeds := map[string]string{"gif": "http://127.0.0.1/a.gif", "photo": "http://127.0.0.1/photo.jpg", "burst": "http://127.0.0.1/b.gif"}
+	photo := PostJSON{}
+	for typ, u := range feeds {
+		imgs := FeedJSON{}
+		util.JSONReq3("GET", ts.URL+"/Hot?type="+typ, &imgs)
+		if len(imgs.Posts) != 1 || imgs.Posts[0].URL.S != u || imgs.Posts[0].I.S != typ {
+			t.Fatalf("%s: %+v", typ, imgs)
+		}
+		if typ == "photo" {
+			photo = imgs.Posts[0]
+		}
+	}
+
+	// Votes name the type of the post, gif by default.
+	v := url.Values{"device_id": {"ddd"}, "key": {base64.StdEncoding.EncodeToString(photo.K.B)}}
+	resp, _, err := util.JSONReq3("POST", ts.URL+"/Vote?"+v.Encode(), nil)
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("%v %+v", err, resp)
+	}
+	v.Set("type", "photo")
+	pj := PostJSON{}
+	resp, _, err = util.JSONReq3("POST", ts.URL+"/Vote?"+v.Encode(), &pj)
+	if err != nil || resp.StatusCode != http.StatusOK || pj.S.N != "1" || pj.I.S != "photo" {
+		t.Fatalf("%v %+v %+v", err, resp, pj)
+	}
+
+	// A cursor only pages through the feed it came from.
+	imgs := FeedJSON{}
+	util.JSONReq3("GET", ts.URL+"/New?limit=1&type=gif", &imgs)
+	resp, _, err = util.JSONReq3("GET", ts.URL+"/New?type=photo&cursor="+url.QueryEscape(imgs.Next), nil)
+	if imgs.Next == "" || err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("%v %+v %+v", err, resp, imgs)
+	}
+}
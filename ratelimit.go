@@ -0,0 +1,405 @@
+package burstbooth
+
+import (
+	"fmt"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/cardinalblue/burstbooth/aws"
+)
+
+// rateLimit allows burst requests, refilled evenly over per, as a token
+// bucket. The zero rateLimit allows everything.
+type rateLimit struct {
+	burst int
+	per   time.Duration
+}
+
+func (l rateLimit) unlimited() bool {
+	return l.burst <= 0 || l.per <= 0
+}
+
+// parseRateLimit parses a rateLimit written as burst/per, like 10/1h.
+func parseRateLimit(s string) (rateLimit, error) {
+	i := strings.Index(s, "/")
+	if i < 0 {
+		return rateLimit{}, fmt.Errorf("bad rate limit %q, want like 10/1h", s)
+	}
+	burst, err := strconv.Atoi(s[:i])
+	if err != nil || burst <= 0 {
+		return rateLimit{}, fmt.Errorf("bad rate limit %q, want like 10/1h", s)
+	}
+	per, err := time.ParseDuration(s[i+1:])
+	if err != nil || per <= 0 {
+		return rateLimit{}, fmt.Errorf("bad rate limit %q, want like 10/1h", s)
+	}
+	return rateLimit{burst: burst, per: per}, nil
+}
+
+// endpointLimits are the limits of an endpoint, for every device_id and for
+// every client IP.
+type endpointLimits struct {
+	device rateLimit
+	ip     rateLimit
+}
+
+// defaultRateLimits are the limits by endpoint path. Endpoints that are not
+// listed are not limited.
+var defaultRateLimits = map[string]endpointLimits{
+	"/Register":     {ip: rateLimit{20, time.Hour}},
+	"/PostImg":      {device: rateLimit{10, time.Hour}, ip: rateLimit{30, time.Hour}},
+	"/PostBurst":    {device: rateLimit{10, time.Hour}, ip: rateLimit{30, time.Hour}},
+	"/Hot":          {device: rateLimit{120, time.Minute}, ip: rateLimit{600, time.Minute}},
+	"/New":          {device: rateLimit{120, time.Minute}, ip: rateLimit{600, time.Minute}},
+	"/Tag":          {device: rateLimit{120, time.Minute}, ip: rateLimit{600, time.Minute}},
+	"/Search":       {device: rateLimit{60, time.Minute}, ip: rateLimit{300, time.Minute}},
+	"/Post":         {device: rateLimit{120, time.Minute}, ip: rateLimit{600, time.Minute}},
+	"/Vote":         {device: rateLimit{60, time.Minute}, ip: rateLimit{300, time.Minute}},
+	"/Unvote":       {device: rateLimit{60, time.Minute}, ip: rateLimit{300, time.Minute}},
+	"/React":        {device: rateLimit{60, time.Minute}, ip: rateLimit{300, time.Minute}},
+	"/Unreact":      {device: rateLimit{60, time.Minute}, ip: rateLimit{300, time.Minute}},
+	"/Report":       {device: rateLimit{20, time.Hour}, ip: rateLimit{60, time.Hour}},
+	"/Comment":      {device: rateLimit{30, time.Hour}, ip: rateLimit{120, time.Hour}},
+	"/Comments":     {device: rateLimit{120, time.Minute}, ip: rateLimit{600, time.Minute}},
+	"/MyPosts":      {device: rateLimit{120, time.Minute}, ip: rateLimit{600, time.Minute}},
+	"/DeleteMyPost": {device: rateLimit{30, time.Hour}, ip: rateLimit{60, time.Hour}},
+}
+
+// bucket is the state of a token bucket.
+type bucket struct {
+	tokens float64
+	at     time.Time // when tokens was computed
+}
+
+// take takes a token from b, refilled at the rate of l up to now. If there is
+// none, it returns b unchanged with how long until there is one.
+func (b bucket) take(l rateLimit, now time.Time) (bucket, time.Duration) {
+	rate := float64(l.burst) / float64(l.per) // tokens per nanosecond
+	tokens := float64(l.burst)
+	if !b.at.IsZero() {
+		tokens = math.Min(tokens, b.tokens+float64(now.Sub(b.at))*rate)
+	}
+	if tokens < 1 {
+		return b, time.Duration(math.Ceil((1 - tokens) / rate))
+	}
+	return bucket{tokens: tokens - 1, at: now}, 0
+}
+
+// bucketStore keeps token buckets by key.
+type bucketStore interface {
+	// take takes a token from the bucket of key. It returns 0 if it got
+	// one, or else how long until there is one.
+	take(key string, l rateLimit, now time.Time) (time.Duration, error)
+
+	// refund puts back a token taken from the bucket of key for a request
+	// that was refused anyway. It is best effort: a refund that races a
+	// take can be lost.
+	refund(key string) error
+}
+
+// memBuckets keeps token buckets in process memory, so every instance has
+// its own.
+type memBuckets struct {
+	mu        sync.Mutex
+	buckets   map[string]bucket
+	lastSweep time.Time
+}
+
+func newMemBuckets() *memBuckets {
+	return &memBuckets{buckets: make(map[string]bucket)}
+}
+
+func (m *memBuckets) take(key string, l rateLimit, now time.Time) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.Sub(m.lastSweep) > time.Minute {
+		// A bucket that has not been touched for a while is full, which is
+		// the same as not having one.
+		for k, b := range m.buckets {
+			if now.Sub(b.at) > 24*time.Hour {
+				delete(m.buckets, k)
+			}
+		}
+		m.lastSweep = now
+	}
+	b, wait := m.buckets[key].take(l, now)
+	m.buckets[key] = b
+	return wait, nil
+}
+
+func (m *memBuckets) refund(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if b, ok := m.buckets[key]; ok {
+		// take caps the tokens at the burst again.
+		b.tokens++
+		m.buckets[key] = b
+	}
+	return nil
+}
+
+type RateLimitDDB struct {
+	K struct{ S string } // bucket key
+	T struct{ N string } // tokens
+	U struct{ N string } // when T was computed, in Unix nanoseconds
+	E struct{ N string } // when the bucket is full again, in Unix seconds, for DynamoDB TTL
+}
+
+// ddbBuckets keeps token buckets in a DynamoDB table, shared by every
+// instance. Buckets are updated optimistically, conditional on U.
+type ddbBuckets struct {
+	table string
+}
+
+// ddbBucketRetries bounds how often a bucket update that raced another one
+// is retried.
+const ddbBucketRetries = 3
+
+func (d ddbBuckets) take(key string, l rateLimit, now time.Time) (time.Duration, error) {
+	for i := 0; i < ddbBucketRetries; i++ {
+		getj := struct {
+			TableName      string
+			Key            struct{ K struct{ S string } }
+			ConsistentRead bool
+		}{}
+		getj.TableName = d.table
+		getj.Key.K.S = key
+		getj.ConsistentRead = true
+		item := struct{ Item *RateLimitDDB }{}
+		if err := aws.DynamoDBPost("GetItem", getj, &item); err != nil {
+			return 0, err
+		}
+
+		old := bucket{}
+		cond := "attribute_not_exists(K)"
+		if item.Item != nil {
+			old.tokens, _ = strconv.ParseFloat(item.Item.T.N, 64)
+			u, _ := strconv.ParseInt(item.Item.U.N, 10, 64)
+			old.at = time.Unix(0, u)
+			cond = "U = :u"
+		}
+		b, wait := old.take(l, now)
+		if wait > 0 {
+			return wait, nil
+		}
+
+		putj := struct {
+			TableName                 string
+			Item                      RateLimitDDB
+			ConditionExpression       string
+			ExpressionAttributeValues interface{} `json:",omitempty"`
+		}{}
+		putj.TableName = d.table
+		putj.Item.K.S = key
+		putj.Item.T.N = strconv.FormatFloat(b.tokens, 'f', -1, 64)
+		putj.Item.U.N = strconv.FormatInt(now.UnixNano(), 10)
+		putj.Item.E.N = strconv.FormatInt(now.Add(l.per).Unix(), 10)
+		putj.ConditionExpression = cond
+		if item.Item != nil {
+			values := struct {
+				U struct{ N string } `json:":u"`
+			}{}
+			values.U.N = item.Item.U.N
+			putj.ExpressionAttributeValues = values
+		}
+		err := aws.DynamoDBPost("PutItem", putj, nil)
+		if derr, ok := err.(*aws.ErrDynamoDB); ok && derr.Type == "ConditionalCheckFailedException" {
+			continue
+		}
+		return 0, err
+	}
+	// The bucket is too busy to update, which means it is being drained.
+	return time.Duration(float64(l.per) / float64(l.burst)), nil
+}
+
+func (d ddbBuckets) refund(key string) error {
+	bj := struct {
+		TableName                 string
+		Key                       struct{ K struct{ S string } }
+		UpdateExpression          string
+		ConditionExpression       string
+		ExpressionAttributeValues struct {
+			One struct{ N string } `json:":one"`
+		}
+	}{}
+	bj.TableName = d.table
+	bj.Key.K.S = key
+	// take caps the tokens at the burst again.
+	bj.UpdateExpression = "ADD T :one"
+	bj.ConditionExpression = "attribute_exists(K)"
+	bj.ExpressionAttributeValues.One.N = "1"
+	err := aws.DynamoDBPost("UpdateItem", bj, nil)
+	if derr, ok := err.(*aws.ErrDynamoDB); ok && derr.Type == "ConditionalCheckFailedException" {
+		return nil
+	}
+	return err
+}
+
+// rateLimiter limits the rate of requests by endpoint.
+type rateLimiter struct {
+	limits  map[string]endpointLimits
+	buckets bucketStore
+	// proxies is the number of proxies in front of the server that add to
+	// X-Forwarded-For, see clientIP.
+	proxies int
+}
+
+var defaultRateLimiter = rateLimiterFromEnv()
+
+// rateLimiterFromEnv configures a rateLimiter from the environment:
+//
+//	RATE_LIMITS: limits that replace those of defaultRateLimits, like
+//	  /PostImg=device:10/1h,ip:30/1h;/Hot=ip:600/1m
+//	  An endpoint with no limits, like /Hot=, is not limited.
+//	RATE_LIMIT_SHARED: true to keep the buckets in the DDB_TABLE_RATELIMIT
+//	  table, shared by all instances, rather than in memory
+//	RATE_LIMIT_PROXIES: the number of proxies in front of the server, like
+//	  1 behind the Elastic Beanstalk load balancer, 0 by default
+func rateLimiterFromEnv() *rateLimiter {
+	l := &rateLimiter{limits: map[string]endpointLimits{}, buckets: newMemBuckets()}
+	for path, el := range defaultRateLimits {
+		l.limits[path] = el
+	}
+	for _, spec := range strings.Split(os.Getenv("RATE_LIMITS"), ";") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		path, el, err := parseEndpointLimits(spec)
+		if err != nil {
+			glog.Fatalf("RATE_LIMITS: %v", err)
+		}
+		l.limits[path] = el
+	}
+	if os.Getenv("RATE_LIMIT_SHARED") == "true" {
+		if ddbTables.RateLimit == "" {
+			glog.Fatalf("RATE_LIMIT_SHARED needs DDB_TABLE_RATELIMIT")
+		}
+		l.buckets = ddbBuckets{table: ddbTables.RateLimit}
+	}
+	if proxies := os.Getenv("RATE_LIMIT_PROXIES"); proxies != "" {
+		n, err := strconv.Atoi(proxies)
+		if err != nil || n < 0 {
+			glog.Fatalf("bad RATE_LIMIT_PROXIES %q, want a number of proxies", proxies)
+		}
+		l.proxies = n
+	}
+	return l
+}
+
+// parseEndpointLimits parses the limits of an endpoint, written like
+// /PostImg=device:10/1h,ip:30/1h.
+func parseEndpointLimits(spec string) (string, endpointLimits, error) {
+	el := endpointLimits{}
+	i := strings.Index(spec, "=")
+	if i < 0 {
+		return "", el, fmt.Errorf("bad endpoint limits %q, want like /PostImg=device:10/1h", spec)
+	}
+	path := strings.TrimSpace(spec[:i])
+	for _, kv := range strings.Split(spec[i+1:], ",") {
+		if strings.TrimSpace(kv) == "" {
+			continue
+		}
+		j := strings.Index(kv, ":")
+		if j < 0 {
+			return "", el, fmt.Errorf("bad limit %q, want like device:10/1h", kv)
+		}
+		l, err := parseRateLimit(strings.TrimSpace(kv[j+1:]))
+		if err != nil {
+			return "", el, err
+		}
+		switch strings.TrimSpace(kv[:j]) {
+		case "device":
+			el.device = l
+		case "ip":
+			el.ip = l
+		default:
+			return "", el, fmt.Errorf("bad limit %q, limits are by device or ip", kv)
+		}
+	}
+	return path, el, nil
+}
+
+// rateLimit wraps the handler of the endpoint at path with its limits.
+// Requests over a limit get a 429 with Retry-After. If the buckets can not be
+// read, requests are let through.
+func (s *Server) rateLimit(path string, fn func(w http.ResponseWriter, r *http.Request) *appError) func(w http.ResponseWriter, r *http.Request) *appError {
+	return func(w http.ResponseWriter, r *http.Request) *appError {
+		el := s.limiter.limits[path]
+		now := time.Now()
+		var wait time.Duration
+		// take reports whether it took a token.
+		take := func(l rateLimit, kind, id string) bool {
+			if l.unlimited() || id == "" || wait > 0 {
+				return false
+			}
+			d, err := s.limiter.buckets.take(path+" "+kind+":"+id, l, now)
+			if err != nil {
+				glog.Errorf("rate limit %s: %v", path, err)
+				return false
+			}
+			wait = d
+			return d == 0
+		}
+		// A device over its limit does not use up the limit of its address,
+		// which other devices may share, and a request refused for its
+		// address gives the device its token back.
+		deviceID := s.rateLimitDeviceID(r)
+		tookDevice := take(el.device, "device", deviceID)
+		take(el.ip, "ip", clientIP(r, s.limiter.proxies))
+		if tookDevice && wait > 0 {
+			if err := s.limiter.buckets.refund(path + " device:" + deviceID); err != nil {
+				glog.Errorf("rate limit %s: %v", path, err)
+			}
+		}
+		if wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			return &appError{Message: "too many requests", Code: http.StatusTooManyRequests}
+		}
+		return fn(w, r)
+	}
+}
+
+// clientIP returns the address of the client. Each of the proxies in front
+// of the server adds the address it got the request from to
+// X-Forwarded-For, so the client's is the proxies-th from the end; those
+// before it are whatever the client sent. Without proxies, or if the header
+// has fewer addresses, the header is ignored and the address of the
+// connection used.
+func clientIP(r *http.Request, proxies int) string {
+	if xff := r.Header["X-Forwarded-For"]; proxies > 0 && len(xff) > 0 {
+		addrs := strings.Split(strings.Join(xff, ","), ",")
+		if len(addrs) >= proxies {
+			return strings.TrimSpace(addrs[len(addrs)-proxies])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimitDeviceID returns the device a request comes from, like
+// formDeviceID, or "" if it does not name one or names it wrong. Multipart
+// bodies are left unread, so that the handler can bound their size, and only
+// the URL counts for them.
+func (s *Server) rateLimitDeviceID(r *http.Request) string {
+	get := r.FormValue
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "multipart/form-data" {
+		get = r.URL.Query().Get
+	}
+	if token := get("device_token"); token != "" {
+		id, _ := s.devices.verify(token)
+		return id
+	}
+	return get("device_id")
+}
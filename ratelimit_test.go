@@ -0,0 +1,126 @@
+package burstbooth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/cardinalblue/burstbooth/util"
+)
+
+func TestBucket(t *testing.T) {
+	l := rateLimit{burst: 2, per: time.Minute}
+	now := time.Unix(1000, 0)
+	b := bucket{}
+	for i := 0; i < 2; i++ {
+		var wait time.Duration
+		if b, wait = b.take(l, now); wait != 0 {
+			t.Fatalf("%d: %v", i, wait)
+		}
+	}
+	if _, wait := b.take(l, now); wait != 30*time.Second {
+		t.Fatalf("%v", wait)
+	}
+	if _, wait := b.take(l, now.Add(20*time.Second)); wait != 10*time.Second {
+		t.Fatalf("%v", wait)
+	}
+	// Buckets refill up to their burst only.
+	b, _ = b.take(l, now.Add(time.Hour))
+	if b.tokens != 1 {
+		t.Fatalf("%+v", b)
+	}
+}
+
+func TestParseEndpointLimits(t *testing.T) {
+	path, el, err := parseEndpointLimits("/PostImg=device:10/1h, ip:30/1h")
+	if err != nil || path != "/PostImg" || el.device != (rateLimit{10, time.Hour}) || el.ip != (rateLimit{30, time.Hour}) {
+		t.Fatalf("%v %s %+v", err, path, el)
+	}
+	if _, el, err := parseEndpointLimits("/Hot="); err != nil || !el.device.unlimited() || !el.ip.unlimited() {
+		t.Fatalf("%v %+v", err, el)
+	}
+	for _, spec := range []string{"/Hot", "/Hot=ip:10", "/Hot=ip:0/1m", "/Hot=user:10/1m"} {
+		if _, _, err := parseEndpointLimits(spec); err == nil {
+			t.Fatalf("%s parsed", spec)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	for _, c := range []struct {
+		xff     []string
+		proxies int
+		ip      string
+	}{
+		{nil, 0, "192.0.2.1"},
+		{nil, 1, "192.0.2.1"},
+		// Without proxies, clients could send any address.
+		{[]string{"10.0.0.1"}, 0, "192.0.2.1"},
+		{[]string{"10.0.0.1, 10.0.0.2"}, 1, "10.0.0.2"},
+		{[]string{"10.0.0.1, 10.0.0.2", "10.0.0.3"}, 2, "10.0.0.2"},
+		{[]string{"10.0.0.1"}, 2, "192.0.2.1"},
+	} {
+		r := httptest.NewRequest("GET", "/Hot", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.Header["X-Forwarded-For"] = c.xff
+		if ip := clientIP(r, c.proxies); ip != c.ip {
+			t.Errorf("%+v: %s", c, ip)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	setup(t)
+	for _, buckets := range []bucketStore{newMemBuckets(), ddbBuckets{table: ddbTables.RateLimit}} {
+		s := NewServer(NewMemStore(), NewFSBlobStore(testBlobDir))
+		s.limiter = &rateLimiter{
+			limits:  map[string]endpointLimits{"/Hot": {device: rateLimit{2, time.Hour}, ip: rateLimit{3, time.Hour}}},
+			buckets: buckets,
+			proxies: 1,
+		}
+		mux := http.NewServeMux()
+		s.Register(mux)
+		ts := httptest.NewServer(mux)
+
+		for _, c := range []struct {
+			device, ip string
+			code       int
+			retryAfter string
+		}{
+			{"a", "10.0.0.1", http.StatusOK, ""},
+			{"a", "10.0.0.1", http.StatusOK, ""},
+			{"a", "10.0.0.1", http.StatusTooManyRequests, "1800"},
+			// Another device from the same address runs into the IP limit.
+			{"b", "10.0.0.1", http.StatusOK, ""},
+			{"c", "10.0.0.1", http.StatusTooManyRequests, "1200"},
+			// Only the last X-Forwarded-For address counts.
+			{"c", "10.0.0.1, 10.0.0.2", http.StatusOK, ""},
+			{"a", "10.0.0.2", http.StatusTooManyRequests, "1800"},
+			// c got back the token of its request refused for the address.
+			{"c", "10.0.0.2", http.StatusOK, ""},
+		} {
+			header := http.Header{"X-Forwarded-For": {c.ip}}
+			v := url.Values{"device_id": {c.device}}
+			resp, _, err := util.JSONReq5("GET", ts.URL+"/Hot?"+v.Encode(), nil, header, nil)
+			if err != nil || resp.StatusCode != c.code {
+				t.Fatalf("%+v: %v %+v", c, err, resp)
+			}
+			if resp.Header.Get("Retry-After") != c.retryAfter {
+				t.Fatalf("%+v: %+v", c, resp.Header)
+			}
+		}
+
+		// Requests without a device_id are only limited by address, and
+		// other endpoints have their own limits.
+		for _, path := range []string{"/Hot", "/Hot", "/Hot", "/New?device_id=a"} {
+			header := http.Header{"X-Forwarded-For": {"10.0.0.3"}}
+			resp, _, err := util.JSONReq5("GET", ts.URL+path, nil, header, nil)
+			if err != nil || resp.StatusCode != http.StatusOK {
+				t.Fatalf("%s: %v %+v", path, err, resp)
+			}
+		}
+		ts.Close()
+	}
+}
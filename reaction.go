@@ -0,0 +1,160 @@
+package burstbooth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+type ReactionDDB struct {
+	D struct{ B []byte } // device ID
+	P struct{ B []byte } // post ID
+
+	// Optional Attributes
+	R *struct{ SS []string } `json:",omitempty"` // reactions of the device, absent if it took them all back
+}
+
+func newReactionDDB(deviceID, postPK []byte) ReactionDDB {
+	r := ReactionDDB{}
+	r.D.B = deviceID
+	r.P.B = postPK
+	return r
+}
+
+// has reports whether the device reacted with reaction.
+func (r *ReactionDDB) has(reaction string) bool {
+	if r.R == nil {
+		return false
+	}
+	for _, name := range r.R.SS {
+		if name == reaction {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultReactionNames are the reactions when REACTIONS is not set.
+var defaultReactionNames = []string{"heart", "laugh", "wow", "fire", "clap"}
+
+// maxReactionLen bounds the length of reaction names, which are kept as map
+// keys on every post.
+const maxReactionLen = 16
+
+// reactionSet is the fixed set of reactions devices can use.
+type reactionSet map[string]bool
+
+var defaultReactions = reactionsFromEnv()
+
+// reactionsFromEnv reads the reactions from REACTIONS, a comma separated list
+// of names of lowercase letters, digits and _.
+func reactionsFromEnv() reactionSet {
+	names := defaultReactionNames
+	if v := os.Getenv("REACTIONS"); v != "" {
+		names = strings.Split(v, ",")
+	}
+	rs, err := newReactionSet(names)
+	if err != nil {
+		glog.Fatalf("REACTIONS: %v", err)
+	}
+	return rs
+}
+
+func newReactionSet(names []string) (reactionSet, error) {
+	rs := reactionSet{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || len(name) > maxReactionLen || strings.Trim(name, "abcdefghijklmnopqrstuvwxyz0123456789_") != "" {
+			return nil, fmt.Errorf("bad reaction %q", name)
+		}
+		rs[name] = true
+	}
+	if len(rs) == 0 {
+		return nil, fmt.Errorf("no reactions")
+	}
+	return rs, nil
+}
+
+// React adds reaction to the reactions of the device to a post, identified
+// like in Vote. A device can react once in every way to a post. It responds
+// with the post.
+//   curl 'http://localhost:8080/React?device_id=ddd&key=E7MySUSwyFQ%3D&reaction=heart'
+func (s *Server) React(w http.ResponseWriter, r *http.Request) *appError {
+	return s.react(w, r, s.store.AddReaction)
+}
+
+// Unreact takes back a reaction of the device to a post, like in React.
+//   curl 'http://localhost:8080/Unreact?device_id=ddd&key=E7MySUSwyFQ%3D&reaction=heart'
+func (s *Server) Unreact(w http.ResponseWriter, r *http.Request) *appError {
+	return s.react(w, r, s.store.RemoveReaction)
+}
+
+func (s *Server) react(w http.ResponseWriter, r *http.Request, change func(deviceID, postPK []byte, reaction string) error) *appError {
+	deviceID, appErr := s.requireDevice(r)
+	if appErr != nil {
+		return appErr
+	}
+	postType, appErr := formPostType(r)
+	if appErr != nil {
+		return appErr
+	}
+	key, err := base64.StdEncoding.DecodeString(r.FormValue("key"))
+	if err != nil {
+		return &appError{Message: err.Error(), Code: http.StatusBadRequest}
+	}
+	reaction := r.FormValue("reaction")
+	if !s.reactions[reaction] {
+		return &appError{Message: fmt.Sprintf("unknown reaction %q", reaction), Code: http.StatusBadRequest}
+	}
+
+	if appErr := voteError(change([]byte(deviceID), postPK(postType, key), reaction)); appErr != nil {
+		return appErr
+	}
+	post, err := s.store.GetPost(postType, key)
+	if err != nil {
+		glog.Errorf("%v", err)
+		return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+	}
+	if post == nil {
+		return &appError{Message: ErrNoPost.Error(), Code: http.StatusNotFound}
+	}
+	pjs, err := s.postsJSON([]PostDDB{*post}, []byte(deviceID))
+	if err != nil {
+		glog.Errorf("%v", err)
+		return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+	}
+	json.NewEncoder(w).Encode(pjs[0])
+	return nil
+}
+
+// reactionCounts returns the counts of the RE attribute of a post, leaving
+// out reactions nobody uses.
+func reactionCounts(p PostDDB) map[string]int {
+	counts := map[string]int{}
+	if p.RE == nil {
+		return counts
+	}
+	for name, n := range p.RE.M {
+		if c, _ := strconv.Atoi(n.N); c > 0 {
+			counts[name] = c
+		}
+	}
+	return counts
+}
+
+// deviceReactions returns the reactions of a device to a post, sorted.
+func deviceReactions(r *ReactionDDB) []string {
+	names := []string{}
+	if r != nil && r.R != nil {
+		names = append(names, r.R.SS...)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,87 @@
+package burstbooth
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/cardinalblue/burstbooth/util"
+)
+
+func TestNewReactionSet(t *testing.T) {
+	rs, err := newReactionSet([]string{"heart", " thumbs_up "})
+	if err != nil || !rs["heart"] || !rs["thumbs_up"] {
+		t.Fatalf("%v %v", err, rs)
+	}
+	for _, names := range [][]string{{}, {""}, {"Heart"}, {"a,b"}, {"way_too_long_a_name"}} {
+		if _, err := newReactionSet(names); err == nil {
+			t.Fatalf("%q", names)
+		}
+	}
+}
+
+func TestReactions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *Server, ts *httptest.Server) {
+		s.reactions = reactionSet{"heart": true, "fire": true}
+
+		p := postAndVoteNTimes(ts, "http://127.0.0.1/a.gif", 0)
+		key := base64.StdEncoding.EncodeToString(p.K.B)
+		for _, c := range []struct {
+			path, device, key, reaction string
+			code                        int
+		}{
+			{"/React", "d1", key, "heart", http.StatusOK},
+			// Reactions are deduplicated by device and kind.
+			{"/React", "d1", key, "heart", http.StatusBadRequest},
+			{"/React", "d1", key, "fire", http.StatusOK},
+			{"/React", "d2", key, "heart", http.StatusOK},
+			{"/React", "d2", key, "poop", http.StatusBadRequest},
+			{"/React", "d2", base64.StdEncoding.EncodeToString([]byte("nopost")), "heart", http.StatusNotFound},
+			{"/Unreact", "d2", key, "fire", http.StatusBadRequest},
+		} {
+			v := url.Values{"device_id": {c.device}, "key": {c.key}, "reaction": {c.reaction}}
+			resp, _, err := util.JSONReq3("POST", ts.URL+c.path+"?"+v.Encode(), nil)
+			if err != nil || resp.StatusCode != c.code {
+				t.Fatalf("%+v: %v %+v", c, err, resp)
+			}
+		}
+
+		imgs := FeedJSON{}
+		util.JSONReq3("GET", ts.URL+"/Hot?device_id=d1", &imgs)
+		if len(imgs.Posts) != 1 || fmt.Sprint(imgs.Posts[0].RE) != "map[fire:1 heart:2]" || fmt.Sprint(imgs.Posts[0].VR) != "[fire heart]" {
+			t.Fatalf("%+v", imgs)
+		}
+		pj := PostJSON{}
+		v := url.Values{"device_id": {"d1"}, "key": {key}, "reaction": {"heart"}}
+		resp, _, err := util.JSONReq3("POST", ts.URL+"/Unreact?"+v.Encode(), &pj)
+		if err != nil || resp.StatusCode != http.StatusOK || fmt.Sprint(pj.RE) != "map[fire:1 heart:1]" || fmt.Sprint(pj.VR) != "[fire]" {
+			t.Fatalf("%v %+v %+v", err, resp, pj)
+		}
+		pj = PostJSON{}
+		util.JSONReq3("GET", ts.URL+"/Post?device_id=d2&key="+url.QueryEscape(key), &pj)
+		if fmt.Sprint(pj.VR) != "[heart]" {
+			t.Fatalf("%+v", pj)
+		}
+
+		// Posts made before reactions get their counts on the first one.
+		old := PostDDB{}
+		old.I.S = postTypeGIF
+		old.K.B, _ = postKey(time.Now())
+		old.S.N = "0"
+		old.H.N = s.hot.scoreN(0, old.K.B)
+		old.URL.S = "http://127.0.0.1/old.gif"
+		if err := s.store.CreatePost(old); err != nil {
+			t.Fatalf("%v", err)
+		}
+		pj = PostJSON{}
+		v.Set("key", base64.StdEncoding.EncodeToString(old.K.B))
+		resp, _, err = util.JSONReq3("POST", ts.URL+"/React?"+v.Encode(), &pj)
+		if err != nil || resp.StatusCode != http.StatusOK || pj.RE["heart"] != 1 {
+			t.Fatalf("%v %+v %+v", err, resp, pj)
+		}
+	})
+}
@@ -0,0 +1,169 @@
+package burstbooth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/golang/glog"
+)
+
+// postStateFlagged is the moderation state, the X attribute, of a post that
+// has been reported too many times.
+const postStateFlagged = "flagged"
+
+// defaultReportThreshold is the default number of reports that flag a post.
+const defaultReportThreshold = 5
+
+// reportReasons are the reasons a post can be reported for.
+var reportReasons = map[string]bool{
+	"spam":      true,
+	"offensive": true,
+	"nudity":    true,
+	"violence":  true,
+	"other":     true,
+}
+
+type ReportDDB struct {
+	D struct{ B []byte } // device ID
+	P struct{ B []byte } // post ID
+	R struct{ S string } // reason, one of reportReasons
+}
+
+func newReportDDB(deviceID, postPK []byte, reason string) ReportDDB {
+	report := ReportDDB{}
+	report.D.B = deviceID
+	report.P.B = postPK
+	report.R.S = reason
+	return report
+}
+
+// reportPolicy decides when reported posts are flagged.
+type reportPolicy struct {
+	// threshold is the number of reports that flag a post and take it out
+	// of the feeds.
+	threshold int
+}
+
+var defaultReportPolicy = reportPolicyFromEnv()
+
+// reportPolicyFromEnv reads the report threshold from REPORT_THRESHOLD, 5 by
+// default.
+func reportPolicyFromEnv() reportPolicy {
+	p := reportPolicy{threshold: defaultReportThreshold}
+	if v := os.Getenv("REPORT_THRESHOLD"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			glog.Fatalf("bad REPORT_THRESHOLD %q", v)
+		}
+		p.threshold = n
+	}
+	return p
+}
+
+// Report reports an image, identified like in Vote, as objectionable. reason
+// is one of spam, offensive, nudity, violence or other, the default. A device
+// can report a post once. Posts with enough reports are flagged, which takes
+// them out of the feeds and into the moderation queue.
+//   curl 'http://localhost:8080/Report?device_id=ddd&key=E7MySUSwyFQ%3D&reason=spam'
+func (s *Server) Report(w http.ResponseWriter, r *http.Request) *appError {
+	deviceID, appErr := s.requireDevice(r)
+	if appErr != nil {
+		return appErr
+	}
+	postType, appErr := formPostType(r)
+	if appErr != nil {
+		return appErr
+	}
+	key, err := base64.StdEncoding.DecodeString(r.FormValue("key"))
+	if err != nil {
+		return &appError{Message: err.Error(), Code: http.StatusBadRequest}
+	}
+	reason := r.FormValue("reason")
+	if reason == "" {
+		reason = "other"
+	}
+	if !reportReasons[reason] {
+		return &appError{Message: fmt.Sprintf("unknown reason %q", reason), Code: http.StatusBadRequest}
+	}
+
+	report := newReportDDB([]byte(deviceID), postPK(postType, key), reason)
+	if appErr := voteError(s.store.ReportPost(report)); appErr != nil {
+		return appErr
+	}
+	// The report is counted even if flagging fails, the next report flags
+	// the post then.
+	if err := s.store.FlagPost(postType, key, s.reports.threshold); err != nil {
+		glog.Errorf("%v", err)
+	}
+	json.NewEncoder(w).Encode(report)
+	return nil
+}
+
+// QueuedPostJSON is a post in the moderation queue.
+type QueuedPostJSON struct {
+	PostJSON
+	X       struct{ S string } // moderation state
+	Reports int                // number of reports
+	Reasons map[string]int     // number of reports by reason
+}
+
+// QueueJSON is a page of the moderation queue.
+type QueueJSON struct {
+	Posts []QueuedPostJSON
+	Next  string `json:",omitempty"`
+	Prev  string `json:",omitempty"`
+}
+
+// queueStates are the moderation states Queue lists.
+var queueStates = map[string]bool{postStateFlagged: true, postStateHeld: true, postStateHidden: true}
+
+// Queue returns the posts under moderation in a state, flagged by reports,
+// the default, held for their caption or hidden by an admin, newest first,
+// with their reports. It pages like New.
+//   curl -H 'Authorization: Bearer t0k3n' http://localhost:8080/admin/Queue?state=held
+func (s *Server) Queue(w http.ResponseWriter, r *http.Request, admin string) *appError {
+	state := r.FormValue("state")
+	if state == "" {
+		state = postStateFlagged
+	}
+	if !queueStates[state] {
+		return &appError{Message: fmt.Sprintf("unknown state %q", state), Code: http.StatusBadRequest}
+	}
+	feed := "Queue/" + state
+	q, appErr := s.feedQuery(r, feed, state)
+	if appErr != nil {
+		return appErr
+	}
+
+	page, err := s.store.ModerationQueue(q)
+	if err != nil {
+		glog.Errorf("%v", err)
+		return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+	}
+	fj, appErr := s.feedJSON(feed, q, page, nil)
+	if appErr != nil {
+		return appErr
+	}
+	resp := QueueJSON{Posts: make([]QueuedPostJSON, len(fj.Posts)), Next: fj.Next, Prev: fj.Prev}
+	for i, pj := range fj.Posts {
+		qp := QueuedPostJSON{PostJSON: pj, Reasons: map[string]int{}}
+		qp.X.S = state
+		reports, err := s.store.GetReports(postPK(pj.I.S, pj.K.B))
+		if err != nil {
+			glog.Errorf("%v", err)
+			return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+		}
+		for _, rep := range reports {
+			qp.Reports++
+			qp.Reasons[rep.R.S]++
+		}
+		resp.Posts[i] = qp
+	}
+
+	json.NewEncoder(w).Encode(resp)
+	return nil
+}
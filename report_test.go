@@ -0,0 +1,81 @@
+package burstbooth
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/cardinalblue/burstbooth/util"
+)
+
+func TestReport(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *Server, ts *httptest.Server) {
+		s.reports = reportPolicy{threshold: 2}
+		s.admins = []adminToken{{name: "mod", token: "t0k3n"}}
+
+		p := PostDDB{}
+		v := url.Values{"url": {"http://127.0.0.1/a.gif"}}
+		util.JSONReq3("POST", ts.URL+"/PostImg?"+v.Encode(), &p)
+		key := base64.StdEncoding.EncodeToString(p.K.B)
+
+		for _, c := range []struct {
+			device, key, reason string
+			code                int
+		}{
+			{"d1", key, "spam", http.StatusOK},
+			// Reports are deduplicated by device.
+			{"d1", key, "nudity", http.StatusBadRequest},
+			{"d2", key, "bogus", http.StatusBadRequest},
+			{"d2", base64.StdEncoding.EncodeToString([]byte("none")), "", http.StatusNotFound},
+		} {
+			v := url.Values{"device_id": {c.device}, "key": {c.key}, "reason": {c.reason}}
+			resp, _, err := util.JSONReq3("POST", ts.URL+"/Report?"+v.Encode(), nil)
+			if err != nil || resp.StatusCode != c.code {
+				t.Fatalf("%+v: %v %+v", c, err, resp)
+			}
+		}
+
+		// One report short of the threshold, the post is still in the feed.
+		imgs := FeedJSON{}
+		util.JSONReq3("GET", ts.URL+"/Hot", &imgs)
+		if len(imgs.Posts) != 1 {
+			t.Fatalf("%+v", imgs)
+		}
+		v = url.Values{"device_id": {"d2"}, "key": {key}}
+		resp, _, err := util.JSONReq3("POST", ts.URL+"/Report?"+v.Encode(), nil)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("%v %+v", err, resp)
+		}
+		imgs = FeedJSON{}
+		util.JSONReq3("GET", ts.URL+"/Hot", &imgs)
+		if len(imgs.Posts) != 0 {
+			t.Fatalf("%+v", imgs)
+		}
+
+		// Only admins see the queue.
+		for _, auth := range []string{"", "Bearer nope"} {
+			header := http.Header{"Authorization": {auth}}
+			resp, _, err := util.JSONReq5("GET", ts.URL+"/admin/Queue", nil, header, nil)
+			if err != nil || resp.StatusCode != http.StatusUnauthorized {
+				t.Fatalf("%v %+v", err, resp)
+			}
+		}
+		header := http.Header{"Authorization": {"Bearer t0k3n"}}
+		queue := QueueJSON{}
+		resp, _, err = util.JSONReq5("GET", ts.URL+"/admin/Queue", nil, header, &queue)
+		if err != nil || resp.StatusCode != http.StatusOK || len(queue.Posts) != 1 {
+			t.Fatalf("%v %+v %+v", err, resp, queue)
+		}
+		qp := queue.Posts[0]
+		if qp.URL.S != p.URL.S || qp.X.S != postStateFlagged || qp.Reports != 2 || qp.Reasons["spam"] != 1 || qp.Reasons["other"] != 1 {
+			t.Fatalf("%+v", qp)
+		}
+		queue = QueueJSON{}
+		util.JSONReq5("GET", ts.URL+"/admin/Queue?state=held", nil, header, &queue)
+		if len(queue.Posts) != 0 {
+			t.Fatalf("%+v", queue)
+		}
+	})
+}
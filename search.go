@@ -0,0 +1,271 @@
+package burstbooth
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/golang/glog"
+	"golang.org/x/text/unicode/norm"
+)
+
+type WordDDB struct {
+	W struct{ S string } // the first minWordLen characters of the word
+	R struct{ B []byte } // the word, a 0 byte and the post ID, see wordRange
+}
+
+const (
+	// minWordLen is the length, in characters, of the shortest words that
+	// are indexed and searched for, and of the prefix the word table is
+	// partitioned by.
+	minWordLen = 2
+	// maxWordLen bounds the length of indexed words. Longer words are cut.
+	maxWordLen = 32
+	// maxSearchTerms bounds the number of words of a search.
+	maxSearchTerms = 5
+	// maxSearchResults bounds the number of matching posts a search ranks.
+	maxSearchResults = 200
+	// maxSearchReads bounds the number of word items a search reads, so a
+	// short prefix of many words stays cheap.
+	maxSearchReads = 5000
+	// searchPageSize is the number of word items read at once.
+	searchPageSize = 100
+	// searchScoreWeight is what every factor of ten in the score of a post
+	// adds to its rank, relative to the text relevance, which is at most 1.
+	searchScoreWeight = 0.25
+)
+
+// wordRange returns the range key of the word item for word in a post.
+// Words do not contain 0, so the item of a word sorts before those of
+// longer words with the same start.
+func wordRange(word string, postPK []byte) []byte {
+	return append(append([]byte(word), 0), postPK...)
+}
+
+// splitWordRange is the inverse of wordRange.
+func splitWordRange(r []byte) (string, []byte) {
+	i := bytes.IndexByte(r, 0)
+	if i < 0 {
+		return "", nil
+	}
+	return string(r[:i]), r[i+1:]
+}
+
+// wordBucket returns the hash key of the word items of word, or of the words
+// starting with it.
+func wordBucket(word string) string {
+	n := 0
+	for i := range word {
+		if n == minWordLen {
+			return word[:i]
+		}
+		n++
+	}
+	return word
+}
+
+// isSearchRune reports whether r can be part of a word.
+func isSearchRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
+
+// captionWords returns the words of a normalized caption, in the order they
+// first appear, in lower case and without accents, so that searching for
+// "cafe" finds "Café". Hashtags count as words, without #.
+func captionWords(caption string) []string {
+	var words []string
+	seen := map[string]bool{}
+	for _, w := range strings.FieldsFunc(caption, func(r rune) bool { return !isSearchRune(r) }) {
+		b := strings.Builder{}
+		n := 0
+		for _, r := range norm.NFKD.String(w) {
+			if unicode.Is(unicode.Mn, r) {
+				continue
+			}
+			if n == maxWordLen {
+				break
+			}
+			b.WriteRune(unicode.ToLower(r))
+			n++
+		}
+		w = b.String()
+		if n < minWordLen || seen[w] {
+			continue
+		}
+		seen[w] = true
+		words = append(words, w)
+	}
+	return words
+}
+
+// indexCaption brings the word items of a post in line with its caption
+// changing from old to caption. Failures are logged, the caption stays as it
+// is.
+func (s *Server) indexCaption(postPK []byte, old, caption string) {
+	words, oldWords := captionWords(caption), captionWords(old)
+	if removed := tagsNotIn(oldWords, words); len(removed) > 0 {
+		if err := s.store.UnindexWords(postPK, removed); err != nil {
+			glog.Errorf("unindex %v: %v", removed, err)
+		}
+	}
+	if added := tagsNotIn(words, oldWords); len(added) > 0 {
+		if err := s.store.IndexWords(postPK, added); err != nil {
+			glog.Errorf("index %v: %v", added, err)
+		}
+	}
+}
+
+// searchResult is a post matching a search, with its rank.
+type searchResult struct {
+	post PostDDB
+	rank float64
+}
+
+// searchPosts returns the visible posts with caption words starting with
+// every one of terms. It pages through the word items of the longest term,
+// likely the one that starts the fewest words, in the order of their range
+// keys, and stops once it has maxSearchResults posts or has read
+// maxSearchReads items. Posts past that are missed whatever their rank, so
+// searches matching more posts than that, or with only terms starting that
+// many words, do not find them all.
+func (s *Server) searchPosts(terms []string) ([]searchResult, error) {
+	term := terms[0]
+	for _, t := range terms[1:] {
+		if utf8.RuneCountInString(t) > utf8.RuneCountInString(term) {
+			term = t
+		}
+	}
+	var results []searchResult
+	seen := map[string]bool{}
+	var start json.RawMessage
+	for read := 0; read < maxSearchReads && len(results) < maxSearchResults; {
+		items, last, err := s.store.FindWords(term, start, searchPageSize)
+		if err != nil {
+			return nil, err
+		}
+		read += len(items)
+		var pks [][]byte
+		for _, it := range items {
+			if _, pk := splitWordRange(it.R.B); !seen[string(pk)] {
+				seen[string(pk)] = true
+				pks = append(pks, pk)
+			}
+		}
+		posts, err := s.store.GetPosts(pks)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range posts {
+			if p == nil || p.X != nil || p.C == nil {
+				continue
+			}
+			if rel := searchRelevance(terms, p.C.S); rel > 0 {
+				results = append(results, searchResult{*p, searchRank(rel, *p)})
+			}
+		}
+		if last == nil {
+			break
+		}
+		start = last
+	}
+	return results, nil
+}
+
+// searchRelevance returns how well a caption matches terms, or 0 if a term
+// starts none of its words. A term that is a whole word counts 1, one that
+// starts a word counts its share of the word's length, and the relevance is
+// the average over the terms.
+func searchRelevance(terms []string, caption string) float64 {
+	words := captionWords(caption)
+	sum := 0.0
+	for _, term := range terms {
+		best := 0.0
+		for _, word := range words {
+			if strings.HasPrefix(word, term) {
+				best = math.Max(best, float64(utf8.RuneCountInString(term))/float64(utf8.RuneCountInString(word)))
+			}
+		}
+		if best == 0 {
+			return 0
+		}
+		sum += best
+	}
+	return sum / float64(len(terms))
+}
+
+// searchRank mixes the text relevance of a post with its score. Negative
+// scores count like 0.
+func searchRank(relevance float64, p PostDDB) float64 {
+	score, _ := strconv.Atoi(p.S.N)
+	if score < 0 {
+		score = 0
+	}
+	return relevance + searchScoreWeight*math.Log10(1+float64(score))
+}
+
+// Search returns the posts, of every type, with captions that have words
+// starting with every word of q, best first. Posts rank by how much of
+// their words the search covers, mixed with their score. Only the first
+// maxSearchResults matches in the index of the longest word are ranked, see
+// searchPosts. Pages are positions in the ranking, so they can shift when
+// votes come in between requests.
+//   curl 'http://localhost:8080/Search?device_id=ddd&q=beach+par'
+func (s *Server) Search(w http.ResponseWriter, r *http.Request) *appError {
+	terms := captionWords(normalizeCaption(r.FormValue("q")))
+	if len(terms) == 0 {
+		return &appError{Message: "no words to search for", Code: http.StatusBadRequest}
+	}
+	if len(terms) > maxSearchTerms {
+		terms = terms[:maxSearchTerms]
+	}
+	feed := "Search/" + strings.Join(terms, " ")
+	q, appErr := s.feedQuery(r, feed, "")
+	if appErr != nil {
+		return appErr
+	}
+	if q.Limit <= 0 {
+		return &appError{Message: "limit must be a positive number", Code: http.StatusBadRequest}
+	}
+	offset := struct{ O int }{}
+	if q.Start != nil {
+		if err := json.Unmarshal(q.Start, &offset); err != nil {
+			return &appError{Message: err.Error(), Code: http.StatusBadRequest}
+		}
+	}
+	deviceID, appErr := s.formDeviceID(r)
+	if appErr != nil {
+		return appErr
+	}
+
+	posts, err := s.searchPosts(terms)
+	if err != nil {
+		glog.Errorf("%v", err)
+		return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+	}
+	sort.Slice(posts, func(i, j int) bool {
+		if posts[i].rank != posts[j].rank {
+			return posts[i].rank > posts[j].rank
+		}
+		return bytes.Compare(posts[i].post.K.B, posts[j].post.K.B) > 0
+	})
+
+	page := FeedPage{}
+	for i := offset.O; i < len(posts) && i < offset.O+q.Limit; i++ {
+		page.Posts = append(page.Posts, posts[i].post)
+	}
+	if end := offset.O + q.Limit; end < len(posts) {
+		page.Last, _ = json.Marshal(struct{ O int }{end})
+	}
+	resp, appErr := s.feedJSON(feed, q, page, []byte(deviceID))
+	if appErr != nil {
+		return appErr
+	}
+	json.NewEncoder(w).Encode(resp)
+	return nil
+}
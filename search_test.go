@@ -0,0 +1,133 @@
+package burstbooth
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/cardinalblue/burstbooth/util"
+)
+
+func TestCaptionWords(t *testing.T) {
+	for _, c := range []struct {
+		caption, words string
+	}{
+		{"", "[]"},
+		{"Café au lait, #Beach_party! x 2015 AU", "[cafe au lait beach party 2015]"},
+		{strings.Repeat("ab", 20), "[" + strings.Repeat("ab", 16) + "]"},
+	} {
+		if got := fmt.Sprint(captionWords(c.caption)); got != c.words {
+			t.Errorf("%q: %s, want %s", c.caption, got, c.words)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *Server, ts *httptest.Server) {
+		s.admins = []adminToken{{name: "alice", token: "a"}}
+		header := http.Header{"Authorization": {"Bearer a"}}
+
+		var keys []string
+		for _, caption := range []string{"Beach party tonight", "Partying at the café", "#beach volleyball", "Park run"} {
+			v := url.Values{"url": {"http://127.0.0.1/a.gif"}, "caption": {caption}}
+			p := PostDDB{}
+			util.JSONReq3("POST", ts.URL+"/PostImg?"+v.Encode(), &p)
+			keys = append(keys, base64.StdEncoding.EncodeToString(p.K.B))
+		}
+		search := func(q string, limit int) []string {
+			var got []string
+			cursor := ""
+			for {
+				feed := FeedJSON{}
+				v := url.Values{"q": {q}, "limit": {fmt.Sprint(limit)}, "cursor": {cursor}}
+				resp, _, err := util.JSONReq3("GET", ts.URL+"/Search?"+v.Encode(), &feed)
+				if err != nil || resp.StatusCode != http.StatusOK {
+					t.Fatalf("%q: %v %+v", q, err, resp)
+				}
+				for _, p := range feed.Posts {
+					got = append(got, base64.StdEncoding.EncodeToString(p.K.B))
+				}
+				if cursor = feed.Next; cursor == "" {
+					return got
+				}
+			}
+		}
+		check := func(q string, want ...int) {
+			var wantKeys []string
+			for _, i := range want {
+				wantKeys = append(wantKeys, keys[i])
+			}
+			if got := search(q, 1); fmt.Sprint(got) != fmt.Sprint(wantKeys) {
+				t.Fatalf("%q: %v, want %v", q, got, wantKeys)
+			}
+		}
+		// Whole words rank above words they only start.
+		check("party", 0, 1)
+		check("par", 3, 0, 1)
+		// Every word must match.
+		check("beach par", 0)
+		check("CAFÉ", 1)
+		check("cafe", 1)
+		check("nothing")
+		for _, q := range []string{"", "a !"} {
+			if resp, _, err := util.JSONReq3("GET", ts.URL+"/Search?q="+url.QueryEscape(q), nil); err != nil || resp.StatusCode != http.StatusBadRequest {
+				t.Fatalf("%q: %v %+v", q, err, resp)
+			}
+		}
+
+		// Word items that sort first, here of posts that are gone, do not
+		// crowd out the others.
+		for i := 0; i < 1100; i++ {
+			if err := s.store.IndexWords([]byte(fmt.Sprintf("a\x00%04d", i)), []string{"beach"}); err != nil {
+				t.Fatalf("%v", err)
+			}
+		}
+		check("beach", 2, 0)
+		check("beach volleyball", 2)
+		n := 0
+		var start []byte
+		for {
+			words, last, err := s.store.FindWords("beach", start, 100)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			n += len(words)
+			if start = last; start == nil {
+				break
+			}
+		}
+		if n != 1102 {
+			t.Fatalf("%d beach words", n)
+		}
+
+		// Score moves well voted posts up.
+		for i := 0; i < 10; i++ {
+			v := url.Values{"device_id": {fmt.Sprint(i)}, "key": {keys[1]}}
+			util.JSONReq3("POST", ts.URL+"/Vote?"+v.Encode(), nil)
+		}
+		check("par", 3, 1, 0)
+
+		// Hidden posts are left out, edited captions reindexed and deleted
+		// posts unindexed.
+		admin := func(path string, v url.Values) {
+			resp, _, err := util.JSONReq5("POST", ts.URL+"/admin/"+path+"?"+v.Encode(), nil, header, nil)
+			if err != nil || resp.StatusCode != http.StatusOK {
+				t.Fatalf("%s: %v %+v", path, err, resp)
+			}
+		}
+		admin("Hide", url.Values{"key": {keys[3]}})
+		check("par", 1, 0)
+		admin("EditCaption", url.Values{"key": {keys[0]}, "caption": {"Sunset"}})
+		check("par", 1)
+		check("sunset", 0)
+		admin("DeletePost", url.Values{"key": {keys[1]}})
+		check("par")
+		if words, _, err := s.store.FindWords("partying", nil, 10); err != nil || len(words) != 0 {
+			t.Fatalf("%v %+v", err, words)
+		}
+	})
+}
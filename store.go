@@ -0,0 +1,370 @@
+package burstbooth
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrVoteExists is returned by VoteStore.CastVote when the device has
+	// already voted for the post.
+	ErrVoteExists = errors.New("vote already exists")
+
+	// ErrVoteChanged is returned when a vote was changed or retracted
+	// concurrently.
+	ErrVoteChanged = errors.New("vote changed concurrently")
+
+	// ErrNoVote is returned when the device has not voted for the post.
+	ErrNoVote = errors.New("no vote to retract")
+
+	// ErrNoPost is returned for operations on posts that do not exist.
+	ErrNoPost = errors.New("post not found")
+
+	// ErrReportExists is returned by ReportStore.ReportPost when the device
+	// has already reported the post.
+	ErrReportExists = errors.New("report already exists")
+
+	// ErrReactionExists is returned by ReactionStore.AddReaction when the
+	// device has already reacted to the post that way.
+	ErrReactionExists = errors.New("reaction already exists")
+
+	// ErrNoReaction is returned when the device has not reacted to the post
+	// that way.
+	ErrNoReaction = errors.New("no reaction to retract")
+)
+
+// FeedQuery selects a page of a feed.
+type FeedQuery struct {
+	// Type is the post type whose feed to read, or for the moderation
+	// queue the moderation state. Feeds of a device ignore it.
+	Type string
+	// Start is the DynamoDB key to start after, taken from a previous
+	// FeedPage. A nil Start begins at the top of the feed.
+	Start json.RawMessage
+	// Forward reads towards the top of the feed, that is towards hotter
+	// or newer posts. It is ignored when Start is nil.
+	Forward bool
+	// Limit is the maximum number of posts to return.
+	Limit int
+}
+
+// FeedPage is a page of posts in the order they were read.
+type FeedPage struct {
+	Posts []PostDDB
+	// First is the key of the first post, for reading back in the other
+	// direction.
+	First json.RawMessage
+	// Last is the key to continue reading from, or nil if the end of the
+	// feed was reached.
+	Last json.RawMessage
+}
+
+// PostStore persists posts and serves the feeds built from them.
+type PostStore interface {
+	// CreatePost stores a new post. It fails if a post with the same I and K
+	// already exists.
+	CreatePost(p PostDDB) error
+
+	// HotPosts returns a page of posts ordered by hot score. Its keys are
+	// keys of the Hot index.
+	HotPosts(q FeedQuery) (FeedPage, error)
+
+	// NewPosts returns a page of posts ordered by creation time. Its keys
+	// are keys of the post table.
+	NewPosts(q FeedQuery) (FeedPage, error)
+
+	// AuthorPosts returns a page of the posts of a device, of every type and
+	// moderation state, ordered by creation time. q.Type is ignored. Its
+	// keys are keys of the Author index.
+	AuthorPosts(deviceID []byte, q FeedQuery) (FeedPage, error)
+
+	// GetPost returns a post, or nil if there is none.
+	GetPost(postType string, key []byte) (*PostDDB, error)
+
+	// GetPosts returns several posts, in the order of postPKs, with nil
+	// where there is none.
+	GetPosts(postPKs [][]byte) ([]*PostDDB, error)
+
+	// SetHot sets the hot score of a post, provided its score is still
+	// score. If the score has moved on, a concurrent update owns the hot
+	// score and SetHot does nothing.
+	SetHot(postType string, key []byte, score, hot string) error
+
+	// The methods below are for the admin API. They return ErrNoPost if the
+	// post does not exist.
+
+	// DeletePost deletes a post, and then its votes, reactions, reports,
+	// comments, tags and words.
+	DeletePost(postType string, key []byte) error
+
+	// SetCaption sets the caption of a post, or removes it if caption is
+	// empty.
+	SetCaption(postType string, key []byte, caption string) error
+
+	// SetState sets the moderation state of a post. An empty state takes
+	// the post out of moderation and clears its report count, so that only
+	// new reports can flag it again.
+	SetState(postType string, key []byte, state string) error
+
+	// ResetScore deletes the votes of a post, and then sets its score to 0
+	// and its hot score to hot. A vote cast in between is lost from the
+	// score.
+	ResetScore(postType string, key []byte, hot string) error
+}
+
+// VoteStore persists which devices voted for which posts.
+type VoteStore interface {
+	// GetVote returns the vote of a device for a post, or nil if there is none.
+	GetVote(deviceID, postPK []byte) (*VoteDDB, error)
+
+	// GetVotes returns the votes of a device for several posts, in the
+	// order of postPKs, with nil where the device has not voted.
+	GetVotes(deviceID []byte, postPKs [][]byte) ([]*VoteDDB, error)
+
+	// The methods below change a vote and the score of its post together,
+	// atomically, so the two can not drift apart. They return ErrNoPost if
+	// the post does not exist.
+
+	// CastVote stores a new vote and adds its value to the score. It
+	// returns ErrVoteExists if the device has already voted for the post.
+	CastVote(v VoteDDB) error
+
+	// SwitchVote turns an existing vote into v and moves the score by twice
+	// its value, provided the existing vote goes the other way. Otherwise it
+	// returns ErrVoteChanged.
+	SwitchVote(v VoteDDB) error
+
+	// RetractVote deletes the vote v and subtracts its value from the
+	// score, provided the vote is unchanged. Otherwise it returns
+	// ErrVoteChanged.
+	RetractVote(v VoteDDB) error
+}
+
+// ReportStore persists reports of posts and the moderation queue.
+type ReportStore interface {
+	// ReportPost stores a new report and counts it in the RC attribute of
+	// the post, atomically. It returns ErrReportExists if the device has
+	// already reported the post, and ErrNoPost if the post does not exist.
+	ReportPost(r ReportDDB) error
+
+	// GetReports returns the reports of a post.
+	GetReports(postPK []byte) ([]ReportDDB, error)
+
+	// FlagPost puts a post in the moderation queue as flagged, provided it
+	// has at least minReports reports and is not under moderation already.
+	// Otherwise it does nothing.
+	FlagPost(postType string, key []byte, minReports int) error
+
+	// ModerationQueue returns a page of the posts in moderation state
+	// q.Type, newest first. Its keys are keys of the Moderation index.
+	ModerationQueue(q FeedQuery) (FeedPage, error)
+}
+
+// DeviceStore persists what is known about devices.
+type DeviceStore interface {
+	// GetDevice returns a device, or nil if nothing is stored about it.
+	GetDevice(deviceID []byte) (*DeviceDDB, error)
+
+	// RegisterDevice records that a device registered at t. It returns
+	// ErrDeviceExists if it has registered before.
+	RegisterDevice(deviceID []byte, t time.Time) error
+
+	// SetBan bans a device for reason, or lifts its ban if reason is empty.
+	SetBan(deviceID []byte, reason string) error
+}
+
+// AuditStore persists the audit log of the admin API.
+type AuditStore interface {
+	// AddAudit appends an entry to the audit log.
+	AddAudit(a AuditDDB) error
+
+	// AuditLog returns up to limit entries of the audit log, newest first,
+	// after the entry with key start, or from the newest entry if start is
+	// nil. last is the key to continue from, or nil if the end of the log
+	// was reached.
+	AuditLog(start json.RawMessage, limit int) (entries []AuditDDB, last json.RawMessage, err error)
+}
+
+// CommentStore persists the comments on posts.
+type CommentStore interface {
+	// AddComment stores a new comment and, unless it is held, counts it in
+	// the CC attribute of the post, atomically. It returns ErrNoPost if the
+	// post does not exist.
+	AddComment(c CommentDDB) error
+
+	// Comments returns up to limit comments on a post, newest first, after
+	// the comment with key start, or from the newest one if start is nil.
+	// Held comments are left out but count towards limit. last is the key
+	// to continue from, or nil if the oldest comment was reached.
+	Comments(postPK []byte, start json.RawMessage, limit int) (comments []CommentDDB, last json.RawMessage, err error)
+}
+
+// ReactionStore persists the reactions of devices to posts.
+type ReactionStore interface {
+	// GetReactions returns the reactions of a device to each of the posts,
+	// nil where it has none.
+	GetReactions(deviceID []byte, postPKs [][]byte) ([]*ReactionDDB, error)
+
+	// AddReaction adds reaction to those of a device to a post and counts
+	// it in the RE attribute of the post, atomically. It returns
+	// ErrReactionExists if the device has reacted that way before, and
+	// ErrNoPost if the post does not exist.
+	AddReaction(deviceID, postPK []byte, reaction string) error
+
+	// RemoveReaction takes back a reaction of a device to a post and its
+	// count, atomically. It returns ErrNoReaction if the device has not
+	// reacted that way.
+	RemoveReaction(deviceID, postPK []byte, reaction string) error
+}
+
+// TagStore persists the hashtags of posts.
+type TagStore interface {
+	// TagPost adds tags to the TG attribute of a post and a tag item with
+	// score for each, atomically. It returns ErrNoPost if the post does not
+	// exist.
+	TagPost(postType string, key []byte, tags []string, score string) error
+
+	// UntagPost takes tags out of the TG attribute of a post and deletes
+	// their tag items, atomically. It returns ErrNoPost if the post does
+	// not exist.
+	UntagPost(postType string, key []byte, tags []string) error
+
+	// SetTagScore copies the score of a post to its tag items.
+	SetTagScore(postPK []byte, tags []string, score string) error
+
+	// TagPosts returns a page of the posts with tag, of every type, ordered
+	// by score. Posts under moderation are left out. Its keys are keys of
+	// the Score index of the tag table.
+	TagPosts(tag string, q FeedQuery) (FeedPage, error)
+}
+
+// SearchStore persists the word index of captions.
+type SearchStore interface {
+	// IndexWords adds a word item for each of words to the index of a
+	// post.
+	IndexWords(postPK []byte, words []string) error
+
+	// UnindexWords deletes the word items of words from the index of a
+	// post.
+	UnindexWords(postPK []byte, words []string) error
+
+	// FindWords returns up to limit word items of words starting with
+	// prefix, which is at least minWordLen characters long, in the order of
+	// their range keys, after the item with key start, or from the first if
+	// start is nil. last is the key to continue from, or nil if there are
+	// no more.
+	FindWords(prefix string, start json.RawMessage, limit int) (words []WordDDB, last json.RawMessage, err error)
+}
+
+// Store is everything the HTTP handlers need to persist.
+type Store interface {
+	PostStore
+	VoteStore
+	ReportStore
+	DeviceStore
+	AuditStore
+	CommentStore
+	ReactionStore
+	TagStore
+	SearchStore
+}
+
+// postTableKey returns the key of a post in the post table.
+func postTableKey(postType string, key []byte) json.RawMessage {
+	k := struct {
+		I struct{ S string }
+		K struct{ B []byte }
+	}{}
+	k.I.S = postType
+	k.K.B = key
+	b, _ := json.Marshal(k)
+	return b
+}
+
+// hotIndexKey returns the key of a post in the Hot index.
+func hotIndexKey(postType string, key []byte, hot string) json.RawMessage {
+	k := struct {
+		I struct{ S string }
+		K struct{ B []byte }
+		H struct{ N string }
+	}{}
+	k.I.S = postType
+	k.K.B = key
+	k.H.N = hot
+	b, _ := json.Marshal(k)
+	return b
+}
+
+// authorIndexKey returns the key of a post in the Author index.
+func authorIndexKey(postType string, key, author []byte) json.RawMessage {
+	k := struct {
+		I struct{ S string }
+		K struct{ B []byte }
+		A struct{ B []byte }
+	}{}
+	k.I.S = postType
+	k.K.B = key
+	k.A.B = author
+	b, _ := json.Marshal(k)
+	return b
+}
+
+// moderationIndexKey returns the key of a post in the Moderation index.
+func moderationIndexKey(postType string, key []byte, state string) json.RawMessage {
+	k := struct {
+		I struct{ S string }
+		K struct{ B []byte }
+		X struct{ S string }
+	}{}
+	k.I.S = postType
+	k.K.B = key
+	k.X.S = state
+	b, _ := json.Marshal(k)
+	return b
+}
+
+// commentTableKey returns the key of a comment in the comment table.
+func commentTableKey(postPK, key []byte) json.RawMessage {
+	k := struct {
+		P struct{ B []byte }
+		K struct{ B []byte }
+	}{}
+	k.P.B = postPK
+	k.K.B = key
+	b, _ := json.Marshal(k)
+	return b
+}
+
+// tagIndexKey returns the key of a tag item in the Score index of the tag
+// table.
+func tagIndexKey(tag string, postPK []byte, score string) json.RawMessage {
+	k := struct {
+		T struct{ S string }
+		P struct{ B []byte }
+		S struct{ N string }
+	}{}
+	k.T.S = tag
+	k.P.B = postPK
+	k.S.N = score
+	b, _ := json.Marshal(k)
+	return b
+}
+
+// wordTableKey returns the key of a word item in the word table.
+func wordTableKey(word string, postPK []byte) json.RawMessage {
+	k := struct {
+		W struct{ S string }
+		R struct{ B []byte }
+	}{}
+	k.W.S = wordBucket(word)
+	k.R.B = wordRange(word, postPK)
+	b, _ := json.Marshal(k)
+	return b
+}
+
+// auditTableKey returns the key of an entry in the audit table.
+func auditTableKey(key []byte) json.RawMessage {
+	// Audit entries are keyed like posts.
+	return postTableKey(auditPartition, key)
+}
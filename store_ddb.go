@@ -0,0 +1,1347 @@
+package burstbooth
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/cardinalblue/burstbooth/aws"
+)
+
+// DDBTables names the DynamoDB tables of a Store.
+type DDBTables struct {
+	Post     string
+	Vote     string
+	Report   string
+	Device   string
+	Audit    string
+	Comment  string
+	Reaction string
+	Tag      string
+	Word     string
+	// RateLimit keeps the token buckets of the rate limiter, when they are
+	// shared between instances.
+	RateLimit string
+}
+
+// ddbStore is a Store backed by DynamoDB.
+type ddbStore struct {
+	tables DDBTables
+}
+
+// NewDDBStore returns a Store that keeps its data in the given DynamoDB
+// tables.
+func NewDDBStore(tables DDBTables) Store {
+	return &ddbStore{tables: tables}
+}
+
+func (s *ddbStore) CreatePost(post PostDDB) error {
+	bodyj := struct {
+		TableName                 string
+		Item                      PostDDB
+		ConditionExpression       string
+		ExpressionAttributeValues struct {
+			I struct{ S string } `json:":i"`
+			K struct{ B []byte } `json:":k"`
+		}
+	}{}
+	bodyj.TableName = s.tables.Post
+	bodyj.Item = post
+	bodyj.ConditionExpression = "I <> :i and K <> :k"
+	bodyj.ExpressionAttributeValues.I.S = post.I.S
+	bodyj.ExpressionAttributeValues.K.B = post.K.B
+	return aws.DynamoDBPost("PutItem", bodyj, nil)
+}
+
+func (s *ddbStore) HotPosts(q FeedQuery) (FeedPage, error) {
+	page, err := s.queryPosts("Hot", "I", struct{ S string }{q.Type}, q, feedFilter)
+	if err == nil && len(page.Posts) > 0 {
+		p := page.Posts[0]
+		page.First = hotIndexKey(p.I.S, p.K.B, p.H.N)
+	}
+	return page, err
+}
+
+func (s *ddbStore) NewPosts(q FeedQuery) (FeedPage, error) {
+	page, err := s.queryPosts("", "I", struct{ S string }{q.Type}, q, feedFilter)
+	if err == nil && len(page.Posts) > 0 {
+		p := page.Posts[0]
+		page.First = postTableKey(p.I.S, p.K.B)
+	}
+	return page, err
+}
+
+func (s *ddbStore) ModerationQueue(q FeedQuery) (FeedPage, error) {
+	page, err := s.queryPosts("Moderation", "X", struct{ S string }{q.Type}, q, "")
+	if err == nil && len(page.Posts) > 0 {
+		p := page.Posts[0]
+		page.First = moderationIndexKey(p.I.S, p.K.B, p.X.S)
+	}
+	return page, err
+}
+
+func (s *ddbStore) AuthorPosts(deviceID []byte, q FeedQuery) (FeedPage, error) {
+	page, err := s.queryPosts("Author", "A", struct{ B []byte }{deviceID}, q, "")
+	if err == nil && len(page.Posts) > 0 {
+		p := page.Posts[0]
+		page.First = authorIndexKey(p.I.S, p.K.B, deviceID)
+	}
+	return page, err
+}
+
+// feedFilter leaves posts under moderation out of the feeds. They count
+// towards the Limit of a query but are not returned, so pages can come back
+// short.
+const feedFilter = "attribute_not_exists(X)"
+
+// queryPosts reads a page of a feed from the post table, or from one of its
+// indexes, whose hash key hashAttr is hashValue, an attribute value. filter is
+// an optional filter expression.
+func (s *ddbStore) queryPosts(indexName, hashAttr string, hashValue interface{}, q FeedQuery, filter string) (FeedPage, error) {
+	bodyj := struct {
+		TableName                 string
+		IndexName                 string `json:",omitempty"`
+		KeyConditionExpression    string
+		FilterExpression          string `json:",omitempty"`
+		ExpressionAttributeValues struct {
+			H interface{} `json:":h"`
+		}
+		ExclusiveStartKey json.RawMessage `json:",omitempty"`
+		Limit             int
+		ScanIndexForward  bool
+	}{}
+	bodyj.TableName = s.tables.Post
+	bodyj.IndexName = indexName
+	bodyj.KeyConditionExpression = hashAttr + " = :h"
+	bodyj.FilterExpression = filter
+	bodyj.ExpressionAttributeValues.H = hashValue
+	if q.Start != nil {
+		bodyj.ExclusiveStartKey = q.Start
+		bodyj.ScanIndexForward = q.Forward
+	}
+	bodyj.Limit = q.Limit
+	ddbResp := struct {
+		Items            []PostDDB
+		LastEvaluatedKey json.RawMessage
+	}{}
+	if err := aws.DynamoDBPost("Query", bodyj, &ddbResp); err != nil {
+		return FeedPage{}, err
+	}
+	return FeedPage{Posts: ddbResp.Items, Last: ddbResp.LastEvaluatedKey}, nil
+}
+
+func (s *ddbStore) GetPost(postType string, key []byte) (*PostDDB, error) {
+	bodyj := struct {
+		TableName string
+		Key       json.RawMessage
+	}{}
+	bodyj.TableName = s.tables.Post
+	bodyj.Key = postTableKey(postType, key)
+	p := struct{ Item *PostDDB }{}
+	if err := aws.DynamoDBPost("GetItem", bodyj, &p); err != nil {
+		return nil, err
+	}
+	return p.Item, nil
+}
+
+func (s *ddbStore) GetPosts(postPKs [][]byte) ([]*PostDDB, error) {
+	keys := []interface{}{}
+	seen := map[string]bool{}
+	for _, pk := range postPKs {
+		if seen[string(pk)] {
+			continue
+		}
+		seen[string(pk)] = true
+		keys = append(keys, postTableKey(splitPostPK(pk)))
+	}
+	items, err := aws.DynamoDBBatchGet(s.tables.Post, keys)
+	if err != nil {
+		return nil, err
+	}
+	byPK := make(map[string]*PostDDB, len(items))
+	for _, b := range items {
+		p := &PostDDB{}
+		if err := json.Unmarshal(b, p); err != nil {
+			return nil, err
+		}
+		byPK[string(postPK(p.I.S, p.K.B))] = p
+	}
+	posts := make([]*PostDDB, len(postPKs))
+	for i, pk := range postPKs {
+		posts[i] = byPK[string(pk)]
+	}
+	return posts, nil
+}
+
+func (s *ddbStore) SetHot(postType string, key []byte, score, hot string) error {
+	bj := struct {
+		TableName string
+		Key       struct {
+			I struct{ S string }
+			K struct{ B []byte }
+		}
+		UpdateExpression          string
+		ConditionExpression       string
+		ExpressionAttributeValues struct {
+			S struct{ N string } `json:":s"`
+			H struct{ N string } `json:":h"`
+		}
+	}{}
+	bj.TableName = s.tables.Post
+	bj.Key.I.S = postType
+	bj.Key.K.B = key
+	bj.UpdateExpression = "SET H = :h"
+	bj.ConditionExpression = "S = :s"
+	bj.ExpressionAttributeValues.S.N = score
+	bj.ExpressionAttributeValues.H.N = hot
+	if err := aws.DynamoDBPost("UpdateItem", bj, nil); err != nil {
+		if derr, ok := err.(*aws.ErrDynamoDB); ok && derr.Type == "ConditionalCheckFailedException" {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// updatePost applies an update expression to a post, with values as its
+// expression attribute values. It returns ErrNoPost if the post does not
+// exist.
+func (s *ddbStore) updatePost(postType string, key []byte, update string, values interface{}) error {
+	bj := struct {
+		TableName                 string
+		Key                       json.RawMessage
+		UpdateExpression          string
+		ConditionExpression       string
+		ExpressionAttributeValues interface{} `json:",omitempty"`
+	}{}
+	bj.TableName = s.tables.Post
+	bj.Key = postTableKey(postType, key)
+	bj.UpdateExpression = update
+	bj.ConditionExpression = "attribute_exists(K)"
+	bj.ExpressionAttributeValues = values
+	if err := aws.DynamoDBPost("UpdateItem", bj, nil); err != nil {
+		if derr, ok := err.(*aws.ErrDynamoDB); ok && derr.Type == "ConditionalCheckFailedException" {
+			return ErrNoPost
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *ddbStore) DeletePost(postType string, key []byte) error {
+	bj := struct {
+		TableName           string
+		Key                 json.RawMessage
+		ConditionExpression string
+		ReturnValues        string
+	}{}
+	bj.TableName = s.tables.Post
+	bj.Key = postTableKey(postType, key)
+	bj.ConditionExpression = "attribute_exists(K)"
+	bj.ReturnValues = "ALL_OLD"
+	deleted := struct{ Attributes PostDDB }{}
+	if err := aws.DynamoDBPost("DeleteItem", bj, &deleted); err != nil {
+		if derr, ok := err.(*aws.ErrDynamoDB); ok && derr.Type == "ConditionalCheckFailedException" {
+			return ErrNoPost
+		}
+		return err
+	}
+	pk := postPK(postType, key)
+	if tg := deleted.Attributes.TG; tg != nil {
+		for _, tag := range tg.SS {
+			del := struct {
+				TableName string
+				Key       json.RawMessage
+			}{TableName: s.tables.Tag, Key: tagTableKey(tag, pk)}
+			if err := aws.DynamoDBPost("DeleteItem", del, nil); err != nil {
+				return err
+			}
+		}
+	}
+	if err := s.deletePostItems(s.tables.Vote, pk); err != nil {
+		return err
+	}
+	if err := s.deletePostItems(s.tables.Reaction, pk); err != nil {
+		return err
+	}
+	if err := s.deletePostItems(s.tables.Report, pk); err != nil {
+		return err
+	}
+	if c := deleted.Attributes.C; c != nil {
+		if err := s.UnindexWords(pk, captionWords(c.S)); err != nil {
+			return err
+		}
+	}
+	return s.deleteComments(pk)
+}
+
+// deletePostItems deletes the items of a post from the vote, reaction or
+// report table, through its Post index.
+func (s *ddbStore) deletePostItems(table string, postPK []byte) error {
+	type itemKey struct {
+		D struct{ B []byte }
+		P struct{ B []byte }
+	}
+	bodyj := struct {
+		TableName                 string
+		IndexName                 string
+		KeyConditionExpression    string
+		ExpressionAttributeValues struct {
+			P struct{ B []byte } `json:":p"`
+		}
+		ExclusiveStartKey json.RawMessage `json:",omitempty"`
+	}{}
+	bodyj.TableName = table
+	bodyj.IndexName = "Post"
+	bodyj.KeyConditionExpression = "P = :p"
+	bodyj.ExpressionAttributeValues.P.B = postPK
+	for {
+		ddbResp := struct {
+			Items            []itemKey
+			LastEvaluatedKey json.RawMessage
+		}{}
+		if err := aws.DynamoDBPost("Query", bodyj, &ddbResp); err != nil {
+			return err
+		}
+		for _, k := range ddbResp.Items {
+			del := struct {
+				TableName string
+				Key       itemKey
+			}{TableName: table, Key: k}
+			if err := aws.DynamoDBPost("DeleteItem", del, nil); err != nil {
+				return err
+			}
+		}
+		if ddbResp.LastEvaluatedKey == nil {
+			return nil
+		}
+		bodyj.ExclusiveStartKey = ddbResp.LastEvaluatedKey
+	}
+}
+
+// deleteComments deletes the comments on a post.
+func (s *ddbStore) deleteComments(postPK []byte) error {
+	type itemKey struct {
+		P struct{ B []byte }
+		K struct{ B []byte }
+	}
+	bodyj := struct {
+		TableName                 string
+		KeyConditionExpression    string
+		ProjectionExpression      string
+		ExpressionAttributeValues struct {
+			P struct{ B []byte } `json:":p"`
+		}
+		ExclusiveStartKey json.RawMessage `json:",omitempty"`
+	}{}
+	bodyj.TableName = s.tables.Comment
+	bodyj.KeyConditionExpression = "P = :p"
+	bodyj.ProjectionExpression = "P, K"
+	bodyj.ExpressionAttributeValues.P.B = postPK
+	for {
+		ddbResp := struct {
+			Items            []itemKey
+			LastEvaluatedKey json.RawMessage
+		}{}
+		if err := aws.DynamoDBPost("Query", bodyj, &ddbResp); err != nil {
+			return err
+		}
+		for _, k := range ddbResp.Items {
+			del := struct {
+				TableName string
+				Key       itemKey
+			}{TableName: s.tables.Comment, Key: k}
+			if err := aws.DynamoDBPost("DeleteItem", del, nil); err != nil {
+				return err
+			}
+		}
+		if ddbResp.LastEvaluatedKey == nil {
+			return nil
+		}
+		bodyj.ExclusiveStartKey = ddbResp.LastEvaluatedKey
+	}
+}
+
+func (s *ddbStore) SetCaption(postType string, key []byte, caption string) error {
+	if caption == "" {
+		return s.updatePost(postType, key, "REMOVE C", nil)
+	}
+	values := struct {
+		C struct{ S string } `json:":c"`
+	}{}
+	values.C.S = caption
+	return s.updatePost(postType, key, "SET C = :c", values)
+}
+
+func (s *ddbStore) SetState(postType string, key []byte, state string) error {
+	if state == "" {
+		return s.updatePost(postType, key, "REMOVE X, RC", nil)
+	}
+	values := struct {
+		X struct{ S string } `json:":x"`
+	}{}
+	values.X.S = state
+	return s.updatePost(postType, key, "SET X = :x", values)
+}
+
+func (s *ddbStore) ResetScore(postType string, key []byte, hot string) error {
+	if err := s.deletePostItems(s.tables.Vote, postPK(postType, key)); err != nil {
+		return err
+	}
+	values := struct {
+		S struct{ N string } `json:":s"`
+		H struct{ N string } `json:":h"`
+	}{}
+	values.S.N = "0"
+	values.H.N = hot
+	return s.updatePost(postType, key, "SET S = :s, H = :h", values)
+}
+
+func (s *ddbStore) GetVote(deviceID, postPK []byte) (*VoteDDB, error) {
+	bodyj := struct {
+		TableName string
+		Key       struct {
+			D struct{ B []byte }
+			P struct{ B []byte }
+		}
+	}{}
+	bodyj.TableName = s.tables.Vote
+	bodyj.Key.D.B = deviceID
+	bodyj.Key.P.B = postPK
+	v := struct{ Item *VoteDDB }{}
+	if err := aws.DynamoDBPost("GetItem", bodyj, &v); err != nil {
+		return nil, err
+	}
+	return v.Item, nil
+}
+
+func (s *ddbStore) GetVotes(deviceID []byte, postPKs [][]byte) ([]*VoteDDB, error) {
+	type voteKey struct {
+		D struct{ B []byte }
+		P struct{ B []byte }
+	}
+	keys := []interface{}{}
+	seen := map[string]bool{}
+	for _, pk := range postPKs {
+		if seen[string(pk)] {
+			continue
+		}
+		seen[string(pk)] = true
+		k := voteKey{}
+		k.D.B = deviceID
+		k.P.B = pk
+		keys = append(keys, k)
+	}
+	items, err := aws.DynamoDBBatchGet(s.tables.Vote, keys)
+	if err != nil {
+		return nil, err
+	}
+	byPost := make(map[string]*VoteDDB, len(items))
+	for _, b := range items {
+		v := &VoteDDB{}
+		if err := json.Unmarshal(b, v); err != nil {
+			return nil, err
+		}
+		byPost[string(v.P.B)] = v
+	}
+	votes := make([]*VoteDDB, len(postPKs))
+	for i, pk := range postPKs {
+		votes[i] = byPost[string(pk)]
+	}
+	return votes, nil
+}
+
+// voteScoreUpdate is the part of a vote transaction that adds delta to the
+// score of the voted post.
+func (s *ddbStore) voteScoreUpdate(v VoteDDB, delta int) interface{} {
+	u := struct {
+		TableName                 string
+		Key                       json.RawMessage
+		UpdateExpression          string
+		ConditionExpression       string
+		ExpressionAttributeValues struct {
+			S struct{ N string } `json:":s"`
+		}
+	}{}
+	u.TableName = s.tables.Post
+	u.Key = postTableKey(splitPostPK(v.P.B))
+	u.UpdateExpression = "ADD S :s"
+	u.ConditionExpression = "attribute_exists(K)"
+	u.ExpressionAttributeValues.S.N = strconv.Itoa(delta)
+	return struct{ Update interface{} }{u}
+}
+
+// voteValueCondition is a condition expression that holds if the vote item
+// has the value of :v.
+func voteValueCondition(value int) string {
+	if value == 1 {
+		// Votes cast before downvotes existed have no V.
+		return "attribute_exists(D) and (V = :v or attribute_not_exists(V))"
+	}
+	return "V = :v"
+}
+
+// voteTransactError maps the cancellation reasons of a vote or report
+// transaction, whose first operation is on the vote or report and second on
+// the post.
+func voteTransactError(err error, voteErr error) error {
+	if terr, ok := err.(*aws.ErrTransactionCanceled); ok {
+		switch {
+		case terr.ConditionFailed(1):
+			return ErrNoPost
+		case terr.ConditionFailed(0):
+			return voteErr
+		}
+	}
+	return err
+}
+
+func (s *ddbStore) CastVote(vote VoteDDB) error {
+	put := struct {
+		TableName           string
+		Item                VoteDDB
+		ConditionExpression string
+	}{}
+	put.TableName = s.tables.Vote
+	put.Item = vote
+	put.ConditionExpression = "attribute_not_exists(D)"
+	err := aws.DynamoDBTransactWrite(struct{ Put interface{} }{put}, s.voteScoreUpdate(vote, vote.Value()))
+	return voteTransactError(err, ErrVoteExists)
+}
+
+func (s *ddbStore) SwitchVote(vote VoteDDB) error {
+	update := struct {
+		TableName string
+		Key       struct {
+			D struct{ B []byte }
+			P struct{ B []byte }
+		}
+		UpdateExpression          string
+		ConditionExpression       string
+		ExpressionAttributeValues struct {
+			V   struct{ N string } `json:":v"`
+			New struct{ N string } `json:":new"`
+		}
+	}{}
+	update.TableName = s.tables.Vote
+	update.Key.D.B = vote.D.B
+	update.Key.P.B = vote.P.B
+	update.UpdateExpression = "SET V = :new"
+	update.ConditionExpression = voteValueCondition(-vote.Value())
+	update.ExpressionAttributeValues.V.N = strconv.Itoa(-vote.Value())
+	update.ExpressionAttributeValues.New.N = strconv.Itoa(vote.Value())
+	err := aws.DynamoDBTransactWrite(struct{ Update interface{} }{update}, s.voteScoreUpdate(vote, 2*vote.Value()))
+	return voteTransactError(err, ErrVoteChanged)
+}
+
+func (s *ddbStore) RetractVote(vote VoteDDB) error {
+	del := struct {
+		TableName string
+		Key       struct {
+			D struct{ B []byte }
+			P struct{ B []byte }
+		}
+		ConditionExpression       string
+		ExpressionAttributeValues struct {
+			V struct{ N string } `json:":v"`
+		}
+	}{}
+	del.TableName = s.tables.Vote
+	del.Key.D.B = vote.D.B
+	del.Key.P.B = vote.P.B
+	del.ConditionExpression = voteValueCondition(vote.Value())
+	del.ExpressionAttributeValues.V.N = strconv.Itoa(vote.Value())
+	err := aws.DynamoDBTransactWrite(struct{ Delete interface{} }{del}, s.voteScoreUpdate(vote, -vote.Value()))
+	return voteTransactError(err, ErrVoteChanged)
+}
+
+func (s *ddbStore) ReportPost(r ReportDDB) error {
+	put := struct {
+		TableName           string
+		Item                ReportDDB
+		ConditionExpression string
+	}{}
+	put.TableName = s.tables.Report
+	put.Item = r
+	put.ConditionExpression = "attribute_not_exists(D)"
+	count := struct {
+		TableName                 string
+		Key                       json.RawMessage
+		UpdateExpression          string
+		ConditionExpression       string
+		ExpressionAttributeValues struct {
+			One struct{ N string } `json:":one"`
+		}
+	}{}
+	count.TableName = s.tables.Post
+	count.Key = postTableKey(splitPostPK(r.P.B))
+	count.UpdateExpression = "ADD RC :one"
+	count.ConditionExpression = "attribute_exists(K)"
+	count.ExpressionAttributeValues.One.N = "1"
+	err := aws.DynamoDBTransactWrite(struct{ Put interface{} }{put}, struct{ Update interface{} }{count})
+	return voteTransactError(err, ErrReportExists)
+}
+
+func (s *ddbStore) GetReports(postPK []byte) ([]ReportDDB, error) {
+	bodyj := struct {
+		TableName                 string
+		IndexName                 string
+		KeyConditionExpression    string
+		ExpressionAttributeValues struct {
+			P struct{ B []byte } `json:":p"`
+		}
+		ExclusiveStartKey json.RawMessage `json:",omitempty"`
+	}{}
+	bodyj.TableName = s.tables.Report
+	bodyj.IndexName = "Post"
+	bodyj.KeyConditionExpression = "P = :p"
+	bodyj.ExpressionAttributeValues.P.B = postPK
+	var reports []ReportDDB
+	for {
+		ddbResp := struct {
+			Items            []ReportDDB
+			LastEvaluatedKey json.RawMessage
+		}{}
+		if err := aws.DynamoDBPost("Query", bodyj, &ddbResp); err != nil {
+			return nil, err
+		}
+		reports = append(reports, ddbResp.Items...)
+		if ddbResp.LastEvaluatedKey == nil {
+			return reports, nil
+		}
+		bodyj.ExclusiveStartKey = ddbResp.LastEvaluatedKey
+	}
+}
+
+func (s *ddbStore) FlagPost(postType string, key []byte, minReports int) error {
+	bj := struct {
+		TableName                 string
+		Key                       json.RawMessage
+		UpdateExpression          string
+		ConditionExpression       string
+		ExpressionAttributeValues struct {
+			X   struct{ S string } `json:":x"`
+			Min struct{ N string } `json:":min"`
+		}
+	}{}
+	bj.TableName = s.tables.Post
+	bj.Key = postTableKey(postType, key)
+	bj.UpdateExpression = "SET X = :x"
+	bj.ConditionExpression = "RC >= :min and attribute_not_exists(X)"
+	bj.ExpressionAttributeValues.X.S = postStateFlagged
+	bj.ExpressionAttributeValues.Min.N = strconv.Itoa(minReports)
+	if err := aws.DynamoDBPost("UpdateItem", bj, nil); err != nil {
+		if derr, ok := err.(*aws.ErrDynamoDB); ok && derr.Type == "ConditionalCheckFailedException" {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *ddbStore) GetDevice(deviceID []byte) (*DeviceDDB, error) {
+	bodyj := struct {
+		TableName string
+		Key       struct {
+			D struct{ B []byte }
+		}
+	}{}
+	bodyj.TableName = s.tables.Device
+	bodyj.Key.D.B = deviceID
+	d := struct{ Item *DeviceDDB }{}
+	if err := aws.DynamoDBPost("GetItem", bodyj, &d); err != nil {
+		return nil, err
+	}
+	return d.Item, nil
+}
+
+func (s *ddbStore) RegisterDevice(deviceID []byte, t time.Time) error {
+	bj := struct {
+		TableName string
+		Key       struct {
+			D struct{ B []byte }
+		}
+		UpdateExpression          string
+		ConditionExpression       string
+		ExpressionAttributeValues struct {
+			R struct{ N string } `json:":r"`
+		}
+	}{}
+	bj.TableName = s.tables.Device
+	bj.Key.D.B = deviceID
+	bj.UpdateExpression = "SET R = :r"
+	bj.ConditionExpression = "attribute_not_exists(R)"
+	bj.ExpressionAttributeValues.R.N = strconv.FormatInt(t.Unix(), 10)
+	if err := aws.DynamoDBPost("UpdateItem", bj, nil); err != nil {
+		if derr, ok := err.(*aws.ErrDynamoDB); ok && derr.Type == "ConditionalCheckFailedException" {
+			return ErrDeviceExists
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *ddbStore) SetBan(deviceID []byte, reason string) error {
+	bj := struct {
+		TableName string
+		Key       struct {
+			D struct{ B []byte }
+		}
+		UpdateExpression          string
+		ExpressionAttributeValues interface{} `json:",omitempty"`
+	}{}
+	bj.TableName = s.tables.Device
+	bj.Key.D.B = deviceID
+	bj.UpdateExpression = "REMOVE BN"
+	if reason != "" {
+		values := struct {
+			BN struct{ S string } `json:":bn"`
+		}{}
+		values.BN.S = reason
+		bj.UpdateExpression = "SET BN = :bn"
+		bj.ExpressionAttributeValues = values
+	}
+	return aws.DynamoDBPost("UpdateItem", bj, nil)
+}
+
+func (s *ddbStore) AddAudit(a AuditDDB) error {
+	bodyj := struct {
+		TableName string
+		Item      AuditDDB
+	}{}
+	bodyj.TableName = s.tables.Audit
+	bodyj.Item = a
+	return aws.DynamoDBPost("PutItem", bodyj, nil)
+}
+
+func (s *ddbStore) AuditLog(start json.RawMessage, limit int) ([]AuditDDB, json.RawMessage, error) {
+	bodyj := struct {
+		TableName                 string
+		KeyConditionExpression    string
+		ExpressionAttributeValues struct {
+			I struct{ S string } `json:":i"`
+		}
+		ExclusiveStartKey json.RawMessage `json:",omitempty"`
+		Limit             int
+		ScanIndexForward  bool
+	}{}
+	bodyj.TableName = s.tables.Audit
+	bodyj.KeyConditionExpression = "I = :i"
+	bodyj.ExpressionAttributeValues.I.S = auditPartition
+	bodyj.ExclusiveStartKey = start
+	bodyj.Limit = limit
+	ddbResp := struct {
+		Items            []AuditDDB
+		LastEvaluatedKey json.RawMessage
+	}{}
+	if err := aws.DynamoDBPost("Query", bodyj, &ddbResp); err != nil {
+		return nil, nil, err
+	}
+	return ddbResp.Items, ddbResp.LastEvaluatedKey, nil
+}
+
+func (s *ddbStore) AddComment(c CommentDDB) error {
+	put := struct {
+		TableName string
+		Item      CommentDDB
+	}{}
+	put.TableName = s.tables.Comment
+	put.Item = c
+	// The post is updated even for held comments, to check it exists.
+	count := struct {
+		TableName                 string
+		Key                       json.RawMessage
+		UpdateExpression          string
+		ConditionExpression       string
+		ExpressionAttributeValues struct {
+			N struct{ N string } `json:":n"`
+		}
+	}{}
+	count.TableName = s.tables.Post
+	count.Key = postTableKey(splitPostPK(c.P.B))
+	count.UpdateExpression = "ADD CC :n"
+	count.ConditionExpression = "attribute_exists(K)"
+	count.ExpressionAttributeValues.N.N = "1"
+	if c.X != nil {
+		count.ExpressionAttributeValues.N.N = "0"
+	}
+	err := aws.DynamoDBTransactWrite(struct{ Put interface{} }{put}, struct{ Update interface{} }{count})
+	if terr, ok := err.(*aws.ErrTransactionCanceled); ok && terr.ConditionFailed(1) {
+		return ErrNoPost
+	}
+	return err
+}
+
+func (s *ddbStore) Comments(postPK []byte, start json.RawMessage, limit int) ([]CommentDDB, json.RawMessage, error) {
+	bodyj := struct {
+		TableName                 string
+		KeyConditionExpression    string
+		FilterExpression          string
+		ExpressionAttributeValues struct {
+			P struct{ B []byte } `json:":p"`
+		}
+		ExclusiveStartKey json.RawMessage `json:",omitempty"`
+		Limit             int
+		ScanIndexForward  bool
+	}{}
+	bodyj.TableName = s.tables.Comment
+	bodyj.KeyConditionExpression = "P = :p"
+	bodyj.FilterExpression = "attribute_not_exists(X)"
+	bodyj.ExpressionAttributeValues.P.B = postPK
+	bodyj.ExclusiveStartKey = start
+	bodyj.Limit = limit
+	ddbResp := struct {
+		Items            []CommentDDB
+		LastEvaluatedKey json.RawMessage
+	}{}
+	if err := aws.DynamoDBPost("Query", bodyj, &ddbResp); err != nil {
+		return nil, nil, err
+	}
+	return ddbResp.Items, ddbResp.LastEvaluatedKey, nil
+}
+
+func (s *ddbStore) GetReactions(deviceID []byte, postPKs [][]byte) ([]*ReactionDDB, error) {
+	type reactionKey struct {
+		D struct{ B []byte }
+		P struct{ B []byte }
+	}
+	keys := []interface{}{}
+	seen := map[string]bool{}
+	for _, pk := range postPKs {
+		if seen[string(pk)] {
+			continue
+		}
+		seen[string(pk)] = true
+		k := reactionKey{}
+		k.D.B = deviceID
+		k.P.B = pk
+		keys = append(keys, k)
+	}
+	items, err := aws.DynamoDBBatchGet(s.tables.Reaction, keys)
+	if err != nil {
+		return nil, err
+	}
+	byPost := make(map[string]*ReactionDDB, len(items))
+	for _, b := range items {
+		r := &ReactionDDB{}
+		if err := json.Unmarshal(b, r); err != nil {
+			return nil, err
+		}
+		byPost[string(r.P.B)] = r
+	}
+	reactions := make([]*ReactionDDB, len(postPKs))
+	for i, pk := range postPKs {
+		reactions[i] = byPost[string(pk)]
+	}
+	return reactions, nil
+}
+
+func (s *ddbStore) AddReaction(deviceID, postPK []byte, reaction string) error {
+	return s.react(deviceID, postPK, reaction, "ADD", "not contains(R, :r)", 1, ErrReactionExists)
+}
+
+func (s *ddbStore) RemoveReaction(deviceID, postPK []byte, reaction string) error {
+	return s.react(deviceID, postPK, reaction, "DELETE", "contains(R, :r)", -1, ErrNoReaction)
+}
+
+// react adds reaction to, or deletes it from, the set R of the reaction
+// item with verb, provided cond holds, and adds delta to its count on the
+// post. If cond does not hold it returns condErr.
+func (s *ddbStore) react(deviceID, postPK []byte, reaction, verb, cond string, delta int, condErr error) error {
+	rx := struct {
+		TableName string
+		Key       struct {
+			D struct{ B []byte }
+			P struct{ B []byte }
+		}
+		UpdateExpression          string
+		ConditionExpression       string
+		ExpressionAttributeValues struct {
+			R  struct{ S string }    `json:":r"`
+			RS struct{ SS []string } `json:":rs"`
+		}
+	}{}
+	rx.TableName = s.tables.Reaction
+	rx.Key.D.B = deviceID
+	rx.Key.P.B = postPK
+	rx.UpdateExpression = verb + " R :rs"
+	rx.ConditionExpression = cond
+	rx.ExpressionAttributeValues.R.S = reaction
+	rx.ExpressionAttributeValues.RS.SS = []string{reaction}
+	count := struct {
+		TableName                 string
+		Key                       json.RawMessage
+		UpdateExpression          string
+		ConditionExpression       string
+		ExpressionAttributeNames  map[string]string
+		ExpressionAttributeValues struct {
+			N struct{ N string } `json:":n"`
+		}
+	}{}
+	count.TableName = s.tables.Post
+	count.Key = postTableKey(splitPostPK(postPK))
+	count.UpdateExpression = "ADD RE.#r :n"
+	count.ConditionExpression = "attribute_exists(K)"
+	count.ExpressionAttributeNames = map[string]string{"#r": reaction}
+	count.ExpressionAttributeValues.N.N = strconv.Itoa(delta)
+
+	for i := 0; ; i++ {
+		err := aws.DynamoDBTransactWrite(struct{ Update interface{} }{rx}, struct{ Update interface{} }{count})
+		terr, ok := err.(*aws.ErrTransactionCanceled)
+		if i > 0 || !ok || !terr.Invalid(1) {
+			return voteTransactError(err, condErr)
+		}
+		// Posts made before reactions have no RE map to count in, which
+		// cancels the transaction with a validation error on the post.
+		values := struct {
+			RE struct{ M struct{} } `json:":re"`
+		}{}
+		postType, key := splitPostPK(postPK)
+		if err := s.updatePost(postType, key, "SET RE = if_not_exists(RE, :re)", values); err != nil {
+			return err
+		}
+	}
+}
+
+// tagTableKey returns the key of a tag item in the tag table.
+func tagTableKey(tag string, postPK []byte) json.RawMessage {
+	k := struct {
+		T struct{ S string }
+		P struct{ B []byte }
+	}{}
+	k.T.S = tag
+	k.P.B = postPK
+	b, _ := json.Marshal(k)
+	return b
+}
+
+// tagPostUpdate is the part of a tag transaction that changes the TG
+// attribute of the post with verb.
+func (s *ddbStore) tagPostUpdate(postType string, key []byte, verb string, tags []string) interface{} {
+	u := struct {
+		TableName                 string
+		Key                       json.RawMessage
+		UpdateExpression          string
+		ConditionExpression       string
+		ExpressionAttributeValues struct {
+			TG struct{ SS []string } `json:":tg"`
+		}
+	}{}
+	u.TableName = s.tables.Post
+	u.Key = postTableKey(postType, key)
+	u.UpdateExpression = verb + " TG :tg"
+	u.ConditionExpression = "attribute_exists(K)"
+	u.ExpressionAttributeValues.TG.SS = tags
+	return struct{ Update interface{} }{u}
+}
+
+// tagTransactError maps the cancellation reasons of a tag transaction, whose
+// first operation is on the post.
+func tagTransactError(err error) error {
+	if terr, ok := err.(*aws.ErrTransactionCanceled); ok && terr.ConditionFailed(0) {
+		return ErrNoPost
+	}
+	return err
+}
+
+func (s *ddbStore) TagPost(postType string, key []byte, tags []string, score string) error {
+	items := []interface{}{s.tagPostUpdate(postType, key, "ADD", tags)}
+	for _, tag := range tags {
+		put := struct {
+			TableName string
+			Item      TagDDB
+		}{}
+		put.TableName = s.tables.Tag
+		put.Item.T.S = tag
+		put.Item.P.B = postPK(postType, key)
+		put.Item.S.N = score
+		items = append(items, struct{ Put interface{} }{put})
+	}
+	return tagTransactError(aws.DynamoDBTransactWrite(items...))
+}
+
+func (s *ddbStore) UntagPost(postType string, key []byte, tags []string) error {
+	items := []interface{}{s.tagPostUpdate(postType, key, "DELETE", tags)}
+	for _, tag := range tags {
+		del := struct {
+			TableName string
+			Key       json.RawMessage
+		}{TableName: s.tables.Tag, Key: tagTableKey(tag, postPK(postType, key))}
+		items = append(items, struct{ Delete interface{} }{del})
+	}
+	return tagTransactError(aws.DynamoDBTransactWrite(items...))
+}
+
+func (s *ddbStore) SetTagScore(postPK []byte, tags []string, score string) error {
+	for _, tag := range tags {
+		bj := struct {
+			TableName                 string
+			Key                       json.RawMessage
+			UpdateExpression          string
+			ConditionExpression       string
+			ExpressionAttributeValues struct {
+				S struct{ N string } `json:":s"`
+			}
+		}{}
+		bj.TableName = s.tables.Tag
+		bj.Key = tagTableKey(tag, postPK)
+		bj.UpdateExpression = "SET S = :s"
+		// Do not bring back a tag that was just taken off.
+		bj.ConditionExpression = "attribute_exists(P)"
+		bj.ExpressionAttributeValues.S.N = score
+		err := aws.DynamoDBPost("UpdateItem", bj, nil)
+		if derr, ok := err.(*aws.ErrDynamoDB); ok && derr.Type == "ConditionalCheckFailedException" {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ddbStore) TagPosts(tag string, q FeedQuery) (FeedPage, error) {
+	bodyj := struct {
+		TableName                 string
+		IndexName                 string
+		KeyConditionExpression    string
+		ExpressionAttributeValues struct {
+			T struct{ S string } `json:":t"`
+		}
+		ExclusiveStartKey json.RawMessage `json:",omitempty"`
+		Limit             int
+		ScanIndexForward  bool
+	}{}
+	bodyj.TableName = s.tables.Tag
+	bodyj.IndexName = "Score"
+	bodyj.KeyConditionExpression = "T = :t"
+	bodyj.ExpressionAttributeValues.T.S = tag
+	if q.Start != nil {
+		bodyj.ExclusiveStartKey = q.Start
+		bodyj.ScanIndexForward = q.Forward
+	}
+	bodyj.Limit = q.Limit
+	ddbResp := struct {
+		Items            []TagDDB
+		LastEvaluatedKey json.RawMessage
+	}{}
+	if err := aws.DynamoDBPost("Query", bodyj, &ddbResp); err != nil {
+		return FeedPage{}, err
+	}
+
+	pks := make([][]byte, len(ddbResp.Items))
+	for i, t := range ddbResp.Items {
+		pks[i] = t.P.B
+	}
+	posts, err := s.GetPosts(pks)
+	if err != nil {
+		return FeedPage{}, err
+	}
+	page := FeedPage{Last: ddbResp.LastEvaluatedKey}
+	for i, t := range ddbResp.Items {
+		p := posts[i]
+		if p == nil || p.X != nil {
+			// Like the feed filter, posts under moderation count towards
+			// the Limit but are not returned.
+			continue
+		}
+		if page.First == nil {
+			page.First = tagIndexKey(tag, t.P.B, t.S.N)
+		}
+		page.Posts = append(page.Posts, *p)
+	}
+	return page, nil
+}
+
+func (s *ddbStore) IndexWords(postPK []byte, words []string) error {
+	for _, word := range words {
+		bj := struct {
+			TableName string
+			Item      WordDDB
+		}{}
+		bj.TableName = s.tables.Word
+		bj.Item.W.S = wordBucket(word)
+		bj.Item.R.B = wordRange(word, postPK)
+		if err := aws.DynamoDBPost("PutItem", bj, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ddbStore) UnindexWords(postPK []byte, words []string) error {
+	for _, word := range words {
+		bj := struct {
+			TableName string
+			Key       json.RawMessage
+		}{TableName: s.tables.Word, Key: wordTableKey(word, postPK)}
+		if err := aws.DynamoDBPost("DeleteItem", bj, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ddbStore) FindWords(prefix string, start json.RawMessage, limit int) ([]WordDDB, json.RawMessage, error) {
+	bodyj := struct {
+		TableName                 string
+		KeyConditionExpression    string
+		ExpressionAttributeValues struct {
+			W struct{ S string } `json:":w"`
+			R struct{ B []byte } `json:":r"`
+		}
+		ExclusiveStartKey json.RawMessage `json:",omitempty"`
+		Limit             int
+	}{}
+	bodyj.TableName = s.tables.Word
+	bodyj.KeyConditionExpression = "W = :w AND begins_with(R, :r)"
+	bodyj.ExpressionAttributeValues.W.S = wordBucket(prefix)
+	bodyj.ExpressionAttributeValues.R.B = []byte(prefix)
+	bodyj.ExclusiveStartKey = start
+	bodyj.Limit = limit
+	ddbResp := struct {
+		Items            []WordDDB
+		LastEvaluatedKey json.RawMessage
+	}{}
+	if err := aws.DynamoDBPost("Query", bodyj, &ddbResp); err != nil {
+		return nil, nil, err
+	}
+	return ddbResp.Items, ddbResp.LastEvaluatedKey, nil
+}
+
+// CreateTables creates the post, vote, reaction, report, device, audit,
+// comment, tag, word and rate limit tables.
+func (s *ddbStore) CreateTables() error {
+	bodies := []string{
+		fmt.Sprintf(`{
+  "TableName": "%s",
+  "AttributeDefinitions": [
+    { "AttributeName": "I", "AttributeType": "S" },
+    { "AttributeName": "K", "AttributeType": "B" },
+    { "AttributeName": "S", "AttributeType": "N" },
+    { "AttributeName": "H", "AttributeType": "N" },
+    { "AttributeName": "X", "AttributeType": "S" },
+    { "AttributeName": "A", "AttributeType": "B" } ],
+  "KeySchema": [
+    { "AttributeName": "I", "KeyType": "HASH" },
+    { "AttributeName": "K", "KeyType": "RANGE" } ],
+  "GlobalSecondaryIndexes":[{
+      "IndexName": "Score",
+      "KeySchema": [
+        { "AttributeName": "I", "KeyType": "HASH" },
+        { "AttributeName": "S", "KeyType": "RANGE" } ],
+      "Projection": { "ProjectionType": "ALL" },
+      "ProvisionedThroughput": {"ReadCapacityUnits":1, "WriteCapacityUnits":1}
+  },{
+      "IndexName": "Hot",
+      "KeySchema": [
+        { "AttributeName": "I", "KeyType": "HASH" },
+        { "AttributeName": "H", "KeyType": "RANGE" } ],
+      "Projection": { "ProjectionType": "ALL" },
+      "ProvisionedThroughput": {"ReadCapacityUnits":1, "WriteCapacityUnits":1}
+  },{
+      "IndexName": "Moderation",
+      "KeySchema": [
+        { "AttributeName": "X", "KeyType": "HASH" },
+        { "AttributeName": "K", "KeyType": "RANGE" } ],
+      "Projection": { "ProjectionType": "ALL" },
+      "ProvisionedThroughput": {"ReadCapacityUnits":1, "WriteCapacityUnits":1}
+  },{
+      "IndexName": "Author",
+      "KeySchema": [
+        { "AttributeName": "A", "KeyType": "HASH" },
+        { "AttributeName": "K", "KeyType": "RANGE" } ],
+      "Projection": { "ProjectionType": "ALL" },
+      "ProvisionedThroughput": {"ReadCapacityUnits":1, "WriteCapacityUnits":1}
+  }],
+  "ProvisionedThroughput": { "ReadCapacityUnits": 1, "WriteCapacityUnits": 1 }
+}`, s.tables.Post),
+		fmt.Sprintf(`{
+  "TableName": "%s",
+  "AttributeDefinitions": [
+    { "AttributeName": "D", "AttributeType": "B" },
+    { "AttributeName": "P", "AttributeType": "B" } ],
+  "KeySchema": [
+    { "AttributeName": "D", "KeyType": "HASH" },
+    { "AttributeName": "P", "KeyType": "RANGE" } ],
+  "GlobalSecondaryIndexes":[{
+      "IndexName": "Post",
+      "KeySchema": [
+        { "AttributeName": "P", "KeyType": "HASH" },
+        { "AttributeName": "D", "KeyType": "RANGE" } ],
+      "Projection": { "ProjectionType": "KEYS_ONLY" },
+      "ProvisionedThroughput": {"ReadCapacityUnits":1, "WriteCapacityUnits":1}
+  }],
+  "ProvisionedThroughput": { "ReadCapacityUnits": 1, "WriteCapacityUnits": 1 }
+}`, s.tables.Vote),
+		fmt.Sprintf(`{
+  "TableName": "%s",
+  "AttributeDefinitions": [
+    { "AttributeName": "D", "AttributeType": "B" },
+    { "AttributeName": "P", "AttributeType": "B" } ],
+  "KeySchema": [
+    { "AttributeName": "D", "KeyType": "HASH" },
+    { "AttributeName": "P", "KeyType": "RANGE" } ],
+  "GlobalSecondaryIndexes":[{
+      "IndexName": "Post",
+      "KeySchema": [
+        { "AttributeName": "P", "KeyType": "HASH" },
+        { "AttributeName": "D", "KeyType": "RANGE" } ],
+      "Projection": { "ProjectionType": "KEYS_ONLY" },
+      "ProvisionedThroughput": {"ReadCapacityUnits":1, "WriteCapacityUnits":1}
+  }],
+  "ProvisionedThroughput": { "ReadCapacityUnits": 1, "WriteCapacityUnits": 1 }
+}`, s.tables.Reaction),
+		fmt.Sprintf(`{
+  "TableName": "%s",
+  "AttributeDefinitions": [
+    { "AttributeName": "D", "AttributeType": "B" },
+    { "AttributeName": "P", "AttributeType": "B" } ],
+  "KeySchema": [
+    { "AttributeName": "D", "KeyType": "HASH" },
+    { "AttributeName": "P", "KeyType": "RANGE" } ],
+  "GlobalSecondaryIndexes":[{
+      "IndexName": "Post",
+      "KeySchema": [
+        { "AttributeName": "P", "KeyType": "HASH" },
+        { "AttributeName": "D", "KeyType": "RANGE" } ],
+      "Projection": { "ProjectionType": "ALL" },
+      "ProvisionedThroughput": {"ReadCapacityUnits":1, "WriteCapacityUnits":1}
+  }],
+  "ProvisionedThroughput": { "ReadCapacityUnits": 1, "WriteCapacityUnits": 1 }
+}`, s.tables.Report),
+		fmt.Sprintf(`{
+  "TableName": "%s",
+  "AttributeDefinitions": [
+    { "AttributeName": "D", "AttributeType": "B" } ],
+  "KeySchema": [
+    { "AttributeName": "D", "KeyType": "HASH" } ],
+  "ProvisionedThroughput": { "ReadCapacityUnits": 1, "WriteCapacityUnits": 1 }
+}`, s.tables.Device),
+		fmt.Sprintf(`{
+  "TableName": "%s",
+  "AttributeDefinitions": [
+    { "AttributeName": "I", "AttributeType": "S" },
+    { "AttributeName": "K", "AttributeType": "B" } ],
+  "KeySchema": [
+    { "AttributeName": "I", "KeyType": "HASH" },
+    { "AttributeName": "K", "KeyType": "RANGE" } ],
+  "ProvisionedThroughput": { "ReadCapacityUnits": 1, "WriteCapacityUnits": 1 }
+}`, s.tables.Audit),
+		fmt.Sprintf(`{
+  "TableName": "%s",
+  "AttributeDefinitions": [
+    { "AttributeName": "P", "AttributeType": "B" },
+    { "AttributeName": "K", "AttributeType": "B" } ],
+  "KeySchema": [
+    { "AttributeName": "P", "KeyType": "HASH" },
+    { "AttributeName": "K", "KeyType": "RANGE" } ],
+  "ProvisionedThroughput": { "ReadCapacityUnits": 1, "WriteCapacityUnits": 1 }
+}`, s.tables.Comment),
+		fmt.Sprintf(`{
+  "TableName": "%s",
+  "AttributeDefinitions": [
+    { "AttributeName": "T", "AttributeType": "S" },
+    { "AttributeName": "P", "AttributeType": "B" },
+    { "AttributeName": "S", "AttributeType": "N" } ],
+  "KeySchema": [
+    { "AttributeName": "T", "KeyType": "HASH" },
+    { "AttributeName": "P", "KeyType": "RANGE" } ],
+  "GlobalSecondaryIndexes":[{
+      "IndexName": "Score",
+      "KeySchema": [
+        { "AttributeName": "T", "KeyType": "HASH" },
+        { "AttributeName": "S", "KeyType": "RANGE" } ],
+      "Projection": { "ProjectionType": "KEYS_ONLY" },
+      "ProvisionedThroughput": {"ReadCapacityUnits":1, "WriteCapacityUnits":1}
+  }],
+  "ProvisionedThroughput": { "ReadCapacityUnits": 1, "WriteCapacityUnits": 1 }
+}`, s.tables.Tag),
+		fmt.Sprintf(`{
+  "TableName": "%s",
+  "AttributeDefinitions": [
+    { "AttributeName": "W", "AttributeType": "S" },
+    { "AttributeName": "R", "AttributeType": "B" } ],
+  "KeySchema": [
+    { "AttributeName": "W", "KeyType": "HASH" },
+    { "AttributeName": "R", "KeyType": "RANGE" } ],
+  "ProvisionedThroughput": { "ReadCapacityUnits": 1, "WriteCapacityUnits": 1 }
+}`, s.tables.Word),
+		fmt.Sprintf(`{
+  "TableName": "%s",
+  "AttributeDefinitions": [
+    { "AttributeName": "K", "AttributeType": "S" } ],
+  "KeySchema": [
+    { "AttributeName": "K", "KeyType": "HASH" } ],
+  "ProvisionedThroughput": { "ReadCapacityUnits": 1, "WriteCapacityUnits": 1 }
+}`, s.tables.RateLimit),
+	}
+	for _, b := range bodies {
+		if err := aws.DynamoDBPostBytes("CreateTable", []byte(b), nil); err != nil {
+			glog.Fatalf("%v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func CreateDDBTables() error {
+	return (&ddbStore{tables: ddbTables}).CreateTables()
+}
+
+// BackfillDDBHot sets H on the posts of tables created before hot scores,
+// which are not in the Hot index without it, and returns how many it set.
+// It is safe to run while the server is up, and again.
+func BackfillDDBHot() (int, error) {
+	return (&ddbStore{tables: ddbTables}).backfillHot(defaultHotRanker)
+}
+
+func (s *ddbStore) backfillHot(h hotRanker) (int, error) {
+	n := 0
+	for postType := range postTypes {
+		q := FeedQuery{Limit: 100}
+		for {
+			page, err := s.queryPosts("", "I", struct{ S string }{postType}, q, "attribute_not_exists(H)")
+			if err != nil {
+				return n, err
+			}
+			for _, p := range page.Posts {
+				votes, err := strconv.Atoi(p.S.N)
+				if err != nil {
+					return n, fmt.Errorf("post %x: %v", p.K.B, err)
+				}
+				bj := struct {
+					TableName                 string
+					Key                       json.RawMessage
+					UpdateExpression          string
+					ConditionExpression       string
+					ExpressionAttributeValues struct {
+						H struct{ N string } `json:":h"`
+					}
+				}{}
+				bj.TableName = s.tables.Post
+				bj.Key = postTableKey(p.I.S, p.K.B)
+				bj.UpdateExpression = "SET H = :h"
+				// A vote may have set it since.
+				bj.ConditionExpression = "attribute_not_exists(H)"
+				bj.ExpressionAttributeValues.H.N = h.scoreN(votes, p.K.B)
+				err = aws.DynamoDBPost("UpdateItem", bj, nil)
+				if derr, ok := err.(*aws.ErrDynamoDB); ok && derr.Type == "ConditionalCheckFailedException" {
+					continue
+				}
+				if err != nil {
+					return n, err
+				}
+				n++
+			}
+			if page.Last == nil {
+				break
+			}
+			q.Start = page.Last
+		}
+	}
+	return n, nil
+}
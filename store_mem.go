@@ -0,0 +1,695 @@
+package burstbooth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memStore is a Store that keeps everything in process memory. It is meant
+// for tests and local development.
+type memStore struct {
+	mu        sync.Mutex
+	posts     map[string]PostDDB
+	votes     map[string]VoteDDB
+	reactions map[string]ReactionDDB
+	reports   map[string]ReportDDB
+	devices   map[string]DeviceDDB
+	audit     []AuditDDB              // in the order they were added
+	comments  map[string][]CommentDDB // by post, in the order they were added
+	words     map[string]WordDDB      // by range key
+}
+
+// NewMemStore returns an empty in-memory Store.
+func NewMemStore() Store {
+	return &memStore{
+		posts:     make(map[string]PostDDB),
+		votes:     make(map[string]VoteDDB),
+		reactions: make(map[string]ReactionDDB),
+		reports:   make(map[string]ReportDDB),
+		devices:   make(map[string]DeviceDDB),
+		comments:  make(map[string][]CommentDDB),
+		words:     make(map[string]WordDDB),
+	}
+}
+
+func voteMemKey(deviceID, postPK []byte) string {
+	return string(postPK) + "\x00" + string(deviceID)
+}
+
+func (s *memStore) CreatePost(p PostDDB) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pk := string(postPK(p.I.S, p.K.B))
+	if _, ok := s.posts[pk]; ok {
+		return fmt.Errorf("post %q already exists", pk)
+	}
+	s.posts[pk] = p
+	return nil
+}
+
+func (s *memStore) HotPosts(q FeedQuery) (FeedPage, error) {
+	hot := func(p PostDDB) float64 {
+		h, _ := strconv.ParseFloat(p.H.N, 64)
+		return h
+	}
+	// Order like the Hot index: by hot score, ties broken by key.
+	less := func(a, b PostDDB) bool {
+		if ha, hb := hot(a), hot(b); ha != hb {
+			return ha < hb
+		}
+		return bytes.Compare(a.K.B, b.K.B) < 0
+	}
+	key := func(p PostDDB) json.RawMessage { return hotIndexKey(p.I.S, p.K.B, p.H.N) }
+	in := func(p PostDDB) bool { return p.I.S == q.Type && p.H.N != "" }
+	return s.feed(q, in, visible, less, key)
+}
+
+func (s *memStore) NewPosts(q FeedQuery) (FeedPage, error) {
+	less := func(a, b PostDDB) bool { return bytes.Compare(a.K.B, b.K.B) < 0 }
+	key := func(p PostDDB) json.RawMessage { return postTableKey(p.I.S, p.K.B) }
+	in := func(p PostDDB) bool { return p.I.S == q.Type }
+	return s.feed(q, in, visible, less, key)
+}
+
+func (s *memStore) ModerationQueue(q FeedQuery) (FeedPage, error) {
+	less := func(a, b PostDDB) bool { return bytes.Compare(a.K.B, b.K.B) < 0 }
+	key := func(p PostDDB) json.RawMessage { return moderationIndexKey(p.I.S, p.K.B, p.X.S) }
+	in := func(p PostDDB) bool { return p.X != nil && p.X.S == q.Type }
+	return s.feed(q, in, func(PostDDB) bool { return true }, less, key)
+}
+
+func (s *memStore) AuthorPosts(deviceID []byte, q FeedQuery) (FeedPage, error) {
+	less := func(a, b PostDDB) bool { return bytes.Compare(a.K.B, b.K.B) < 0 }
+	key := func(p PostDDB) json.RawMessage { return authorIndexKey(p.I.S, p.K.B, deviceID) }
+	in := func(p PostDDB) bool { return p.A != nil && bytes.Equal(p.A.B, deviceID) }
+	return s.feed(q, in, func(PostDDB) bool { return true }, less, key)
+}
+
+func (s *memStore) TagPosts(tag string, q FeedQuery) (FeedPage, error) {
+	if q.Start != nil {
+		// Turn the Score index key into a post for feed to compare.
+		k := TagDDB{}
+		if err := json.Unmarshal(q.Start, &k); err != nil {
+			return FeedPage{}, err
+		}
+		start := PostDDB{}
+		start.I.S, start.K.B = splitPostPK(k.P.B)
+		start.S.N = k.S.N
+		b, _ := json.Marshal(start)
+		q.Start = b
+	}
+	less := func(a, b PostDDB) bool {
+		as, _ := strconv.Atoi(a.S.N)
+		bs, _ := strconv.Atoi(b.S.N)
+		if as != bs {
+			return as < bs
+		}
+		return bytes.Compare(postPK(a.I.S, a.K.B), postPK(b.I.S, b.K.B)) < 0
+	}
+	key := func(p PostDDB) json.RawMessage { return tagIndexKey(tag, postPK(p.I.S, p.K.B), p.S.N) }
+	in := func(p PostDDB) bool {
+		if p.TG == nil {
+			return false
+		}
+		for _, t := range p.TG.SS {
+			if t == tag {
+				return true
+			}
+		}
+		return false
+	}
+	return s.feed(q, in, visible, less, key)
+}
+
+// visible leaves posts under moderation out of the feeds.
+func visible(p PostDDB) bool {
+	return p.X == nil
+}
+
+// feed reads a page of the posts for which in returns true, like a DynamoDB
+// query over an index ordered by less, with filter as its filter expression.
+func (s *memStore) feed(q FeedQuery, in, filter func(PostDDB) bool, less func(a, b PostDDB) bool, key func(PostDDB) json.RawMessage) (FeedPage, error) {
+	var start *PostDDB
+	if q.Start != nil {
+		start = &PostDDB{}
+		if err := json.Unmarshal(q.Start, start); err != nil {
+			return FeedPage{}, err
+		}
+	}
+	forward := q.Forward && start != nil
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	posts := []PostDDB{}
+	for _, p := range s.posts {
+		if !in(p) {
+			continue
+		}
+		if start != nil {
+			if forward && !less(*start, p) || !forward && !less(p, *start) {
+				continue
+			}
+		}
+		posts = append(posts, p)
+	}
+	sort.Slice(posts, func(i, j int) bool {
+		if forward {
+			return less(posts[i], posts[j])
+		}
+		return less(posts[j], posts[i])
+	})
+	page := FeedPage{}
+	if q.Limit > 0 && len(posts) >= q.Limit {
+		posts = posts[:q.Limit]
+		page.Last = key(posts[len(posts)-1])
+	}
+	// Like DynamoDB, filter after applying the limit.
+	for _, p := range posts {
+		if filter(p) {
+			page.Posts = append(page.Posts, p)
+		}
+	}
+	posts = page.Posts
+	if len(posts) > 0 {
+		page.First = key(posts[0])
+	}
+	return page, nil
+}
+
+func (s *memStore) GetPost(postType string, key []byte) (*PostDDB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.posts[string(postPK(postType, key))]
+	if !ok {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+func (s *memStore) GetPosts(postPKs [][]byte) ([]*PostDDB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	posts := make([]*PostDDB, len(postPKs))
+	for i, pk := range postPKs {
+		if p, ok := s.posts[string(pk)]; ok {
+			posts[i] = &p
+		}
+	}
+	return posts, nil
+}
+
+func (s *memStore) SetHot(postType string, key []byte, score, hot string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pk := string(postPK(postType, key))
+	p, ok := s.posts[pk]
+	if !ok || p.S.N != score {
+		return nil
+	}
+	p.H.N = hot
+	s.posts[pk] = p
+	return nil
+}
+
+func (s *memStore) DeletePost(postType string, key []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pk := postPK(postType, key)
+	if _, ok := s.posts[string(pk)]; !ok {
+		return ErrNoPost
+	}
+	delete(s.posts, string(pk))
+	s.deleteVotes(pk)
+	for k, r := range s.reactions {
+		if bytes.Equal(r.P.B, pk) {
+			delete(s.reactions, k)
+		}
+	}
+	for k, r := range s.reports {
+		if bytes.Equal(r.P.B, pk) {
+			delete(s.reports, k)
+		}
+	}
+	delete(s.comments, string(pk))
+	for k, w := range s.words {
+		if _, wpk := splitWordRange(w.R.B); bytes.Equal(wpk, pk) {
+			delete(s.words, k)
+		}
+	}
+	return nil
+}
+
+// deleteVotes deletes the votes of a post. The caller must hold s.mu.
+func (s *memStore) deleteVotes(postPK []byte) {
+	for k, v := range s.votes {
+		if bytes.Equal(v.P.B, postPK) {
+			delete(s.votes, k)
+		}
+	}
+}
+
+// updatePost applies update to a post, or returns ErrNoPost if there is
+// none.
+func (s *memStore) updatePost(postType string, key []byte, update func(p *PostDDB)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pk := string(postPK(postType, key))
+	p, ok := s.posts[pk]
+	if !ok {
+		return ErrNoPost
+	}
+	update(&p)
+	s.posts[pk] = p
+	return nil
+}
+
+func (s *memStore) SetCaption(postType string, key []byte, caption string) error {
+	return s.updatePost(postType, key, func(p *PostDDB) {
+		p.C = nil
+		if caption != "" {
+			p.C = &struct{ S string }{S: caption}
+		}
+	})
+}
+
+func (s *memStore) TagPost(postType string, key []byte, tags []string, score string) error {
+	return s.updatePost(postType, key, func(p *PostDDB) {
+		if p.TG == nil {
+			p.TG = &struct{ SS []string }{}
+		}
+		p.TG.SS = append(p.TG.SS, tagsNotIn(tags, p.TG.SS)...)
+	})
+}
+
+func (s *memStore) UntagPost(postType string, key []byte, tags []string) error {
+	return s.updatePost(postType, key, func(p *PostDDB) {
+		if p.TG == nil {
+			return
+		}
+		// Like DynamoDB, drop the attribute rather than leave an empty set.
+		p.TG.SS = tagsNotIn(p.TG.SS, tags)
+		if len(p.TG.SS) == 0 {
+			p.TG = nil
+		}
+	})
+}
+
+// SetTagScore does nothing, TagPosts orders by the score of the posts.
+func (s *memStore) SetTagScore(postPK []byte, tags []string, score string) error {
+	return nil
+}
+
+func (s *memStore) SetState(postType string, key []byte, state string) error {
+	return s.updatePost(postType, key, func(p *PostDDB) {
+		if state == "" {
+			p.X, p.RC = nil, nil
+			return
+		}
+		p.X = &struct{ S string }{S: state}
+	})
+}
+
+func (s *memStore) ResetScore(postType string, key []byte, hot string) error {
+	return s.updatePost(postType, key, func(p *PostDDB) {
+		s.deleteVotes(postPK(postType, key))
+		p.S.N = "0"
+		p.H.N = hot
+	})
+}
+
+func (s *memStore) GetVote(deviceID, postPK []byte) (*VoteDDB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.votes[voteMemKey(deviceID, postPK)]
+	if !ok {
+		return nil, nil
+	}
+	return &v, nil
+}
+
+func (s *memStore) GetVotes(deviceID []byte, postPKs [][]byte) ([]*VoteDDB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	votes := make([]*VoteDDB, len(postPKs))
+	for i, pk := range postPKs {
+		if v, ok := s.votes[voteMemKey(deviceID, pk)]; ok {
+			votes[i] = &v
+		}
+	}
+	return votes, nil
+}
+
+// addScore adds delta to the score of the post a vote is for. The caller
+// must hold s.mu and have checked that the post exists.
+func (s *memStore) addScore(v VoteDDB, delta int) {
+	pk := string(v.P.B)
+	p := s.posts[pk]
+	score, _ := strconv.Atoi(p.S.N)
+	p.S.N = strconv.Itoa(score + delta)
+	s.posts[pk] = p
+}
+
+func (s *memStore) CastVote(v VoteDDB) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := voteMemKey(v.D.B, v.P.B)
+	if _, ok := s.posts[string(v.P.B)]; !ok {
+		return ErrNoPost
+	}
+	if _, ok := s.votes[k]; ok {
+		return ErrVoteExists
+	}
+	s.votes[k] = v
+	s.addScore(v, v.Value())
+	return nil
+}
+
+func (s *memStore) SwitchVote(v VoteDDB) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := voteMemKey(v.D.B, v.P.B)
+	if _, ok := s.posts[string(v.P.B)]; !ok {
+		return ErrNoPost
+	}
+	old, ok := s.votes[k]
+	if !ok || old.Value() != -v.Value() {
+		return ErrVoteChanged
+	}
+	s.votes[k] = v
+	s.addScore(v, 2*v.Value())
+	return nil
+}
+
+func (s *memStore) RetractVote(v VoteDDB) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := voteMemKey(v.D.B, v.P.B)
+	if _, ok := s.posts[string(v.P.B)]; !ok {
+		return ErrNoPost
+	}
+	old, ok := s.votes[k]
+	if !ok || old.Value() != v.Value() {
+		return ErrVoteChanged
+	}
+	delete(s.votes, k)
+	s.addScore(v, -v.Value())
+	return nil
+}
+
+func (s *memStore) ReportPost(r ReportDDB) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := voteMemKey(r.D.B, r.P.B)
+	p, ok := s.posts[string(r.P.B)]
+	if !ok {
+		return ErrNoPost
+	}
+	if _, ok := s.reports[k]; ok {
+		return ErrReportExists
+	}
+	s.reports[k] = r
+	count := 0
+	if p.RC != nil {
+		count, _ = strconv.Atoi(p.RC.N)
+	}
+	p.RC = &struct{ N string }{N: strconv.Itoa(count + 1)}
+	s.posts[string(r.P.B)] = p
+	return nil
+}
+
+func (s *memStore) GetReports(postPK []byte) ([]ReportDDB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var reports []ReportDDB
+	for _, r := range s.reports {
+		if bytes.Equal(r.P.B, postPK) {
+			reports = append(reports, r)
+		}
+	}
+	return reports, nil
+}
+
+func (s *memStore) FlagPost(postType string, key []byte, minReports int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pk := string(postPK(postType, key))
+	p, ok := s.posts[pk]
+	if !ok || p.RC == nil || p.X != nil {
+		return nil
+	}
+	if count, _ := strconv.Atoi(p.RC.N); count < minReports {
+		return nil
+	}
+	p.X = &struct{ S string }{S: postStateFlagged}
+	s.posts[pk] = p
+	return nil
+}
+
+func (s *memStore) GetDevice(deviceID []byte) (*DeviceDDB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.devices[string(deviceID)]
+	if !ok {
+		return nil, nil
+	}
+	return &d, nil
+}
+
+func (s *memStore) RegisterDevice(deviceID []byte, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.devices[string(deviceID)]
+	if d.R != nil {
+		return ErrDeviceExists
+	}
+	d.D.B = deviceID
+	d.R = &struct{ N string }{N: strconv.FormatInt(t.Unix(), 10)}
+	s.devices[string(deviceID)] = d
+	return nil
+}
+
+func (s *memStore) SetBan(deviceID []byte, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.devices[string(deviceID)]
+	d.D.B = deviceID
+	d.BN = nil
+	if reason != "" {
+		d.BN = &struct{ S string }{S: reason}
+	}
+	s.devices[string(deviceID)] = d
+	return nil
+}
+
+func (s *memStore) AddAudit(a AuditDDB) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.audit = append(s.audit, a)
+	return nil
+}
+
+func (s *memStore) AuditLog(start json.RawMessage, limit int) ([]AuditDDB, json.RawMessage, error) {
+	var after *AuditDDB
+	if start != nil {
+		after = &AuditDDB{}
+		if err := json.Unmarshal(start, after); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := []AuditDDB{}
+	for i := len(s.audit) - 1; i >= 0; i-- {
+		a := s.audit[i]
+		if after != nil && bytes.Compare(a.K.B, after.K.B) >= 0 {
+			continue
+		}
+		entries = append(entries, a)
+		if limit > 0 && len(entries) == limit {
+			return entries, auditTableKey(a.K.B), nil
+		}
+	}
+	return entries, nil, nil
+}
+
+func (s *memStore) AddComment(c CommentDDB) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.posts[string(c.P.B)]
+	if !ok {
+		return ErrNoPost
+	}
+	s.comments[string(c.P.B)] = append(s.comments[string(c.P.B)], c)
+	if c.X == nil {
+		count := 0
+		if p.CC != nil {
+			count, _ = strconv.Atoi(p.CC.N)
+		}
+		p.CC = &struct{ N string }{N: strconv.Itoa(count + 1)}
+		s.posts[string(c.P.B)] = p
+	}
+	return nil
+}
+
+func (s *memStore) Comments(postPK []byte, start json.RawMessage, limit int) ([]CommentDDB, json.RawMessage, error) {
+	var after *CommentDDB
+	if start != nil {
+		after = &CommentDDB{}
+		if err := json.Unmarshal(start, after); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all := s.comments[string(postPK)]
+	comments := []CommentDDB{}
+	n := 0
+	for i := len(all) - 1; i >= 0; i-- {
+		c := all[i]
+		if after != nil && bytes.Compare(c.K.B, after.K.B) >= 0 {
+			continue
+		}
+		if c.X == nil {
+			comments = append(comments, c)
+		}
+		if n++; limit > 0 && n == limit {
+			return comments, commentTableKey(postPK, c.K.B), nil
+		}
+	}
+	return comments, nil, nil
+}
+
+func (s *memStore) GetReactions(deviceID []byte, postPKs [][]byte) ([]*ReactionDDB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reactions := make([]*ReactionDDB, len(postPKs))
+	for i, pk := range postPKs {
+		if r, ok := s.reactions[voteMemKey(deviceID, pk)]; ok {
+			reactions[i] = &r
+		}
+	}
+	return reactions, nil
+}
+
+func (s *memStore) AddReaction(deviceID, postPK []byte, reaction string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.posts[string(postPK)]; !ok {
+		return ErrNoPost
+	}
+	k := voteMemKey(deviceID, postPK)
+	r, ok := s.reactions[k]
+	if !ok {
+		r = newReactionDDB(deviceID, postPK)
+	}
+	if r.has(reaction) {
+		return ErrReactionExists
+	}
+	if r.R == nil {
+		r.R = &struct{ SS []string }{}
+	}
+	r.R.SS = append(append([]string{}, r.R.SS...), reaction)
+	s.reactions[k] = r
+	s.addReactionCount(postPK, reaction, 1)
+	return nil
+}
+
+func (s *memStore) RemoveReaction(deviceID, postPK []byte, reaction string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := voteMemKey(deviceID, postPK)
+	r, ok := s.reactions[k]
+	if !ok || !r.has(reaction) {
+		return ErrNoReaction
+	}
+	if _, ok := s.posts[string(postPK)]; !ok {
+		return ErrNoPost
+	}
+	var rest []string
+	for _, name := range r.R.SS {
+		if name != reaction {
+			rest = append(rest, name)
+		}
+	}
+	r.R = nil
+	if len(rest) > 0 {
+		r.R = &struct{ SS []string }{SS: rest}
+	}
+	s.reactions[k] = r
+	s.addReactionCount(postPK, reaction, -1)
+	return nil
+}
+
+// addReactionCount adds delta to the count of reaction on a post. The caller
+// must hold s.mu and have checked that the post exists.
+func (s *memStore) addReactionCount(postPK []byte, reaction string, delta int) {
+	p := s.posts[string(postPK)]
+	counts := map[string]struct{ N string }{}
+	if p.RE != nil {
+		for name, n := range p.RE.M {
+			counts[name] = n
+		}
+	}
+	n, _ := strconv.Atoi(counts[reaction].N)
+	counts[reaction] = struct{ N string }{N: strconv.Itoa(n + delta)}
+	p.RE = &struct{ M map[string]struct{ N string } }{M: counts}
+	s.posts[string(postPK)] = p
+}
+
+func (s *memStore) IndexWords(postPK []byte, words []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, word := range words {
+		w := WordDDB{}
+		w.W.S = wordBucket(word)
+		w.R.B = wordRange(word, postPK)
+		s.words[string(w.R.B)] = w
+	}
+	return nil
+}
+
+func (s *memStore) UnindexWords(postPK []byte, words []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, word := range words {
+		delete(s.words, string(wordRange(word, postPK)))
+	}
+	return nil
+}
+
+func (s *memStore) FindWords(prefix string, start json.RawMessage, limit int) ([]WordDDB, json.RawMessage, error) {
+	after := WordDDB{}
+	if start != nil {
+		if err := json.Unmarshal(start, &after); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for k := range s.words {
+		if strings.HasPrefix(k, prefix) && k > string(after.R.B) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var last json.RawMessage
+	if limit > 0 && len(keys) >= limit {
+		keys = keys[:limit]
+		last = wordTableKey(splitWordRange([]byte(keys[limit-1])))
+	}
+	words := make([]WordDDB, len(keys))
+	for i, k := range keys {
+		words[i] = s.words[k]
+	}
+	return words, last, nil
+}
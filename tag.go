@@ -0,0 +1,154 @@
+package burstbooth
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/golang/glog"
+)
+
+type TagDDB struct {
+	T struct{ S string } // tag, see captionTags
+	P struct{ B []byte } // post ID
+	S struct{ N string } // score of the post, copied for the Score index
+}
+
+const (
+	// maxTagLen bounds the length of a tag, in characters.
+	maxTagLen = 32
+	// maxPostTags bounds the number of tags of a post. Tags after it are
+	// left as plain caption text.
+	maxPostTags = 10
+)
+
+// isTagRune reports whether r can be part of a tag.
+func isTagRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) || r == '_'
+}
+
+// captionTags returns the hashtags of a normalized caption, lowercase,
+// without #, in the order they first appear. A # in the middle of a word
+// does not start a tag, and tags that are too long are left out.
+func captionTags(caption string) []string {
+	var tags []string
+	seen := map[string]bool{}
+	prev := ' '
+	for i, r := range caption {
+		if r != '#' || isTagRune(prev) || prev == '#' {
+			prev = r
+			continue
+		}
+		prev = r
+		end := len(caption)
+		if j := strings.IndexFunc(caption[i+1:], func(r rune) bool { return !isTagRune(r) }); j >= 0 {
+			end = i + 1 + j
+		}
+		tag := strings.ToLower(caption[i+1 : end])
+		if tag == "" || utf8.RuneCountInString(tag) > maxTagLen || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+		if len(tags) == maxPostTags {
+			break
+		}
+	}
+	return tags
+}
+
+// normalizeTag returns the tag a client asks for, with or without #, in the
+// form captionTags returns, or "" if it is not a tag.
+func normalizeTag(tag string) string {
+	tag = strings.TrimPrefix(normalizeCaption(tag), "#")
+	tags := captionTags("#" + tag)
+	if len(tags) != 1 || tags[0] != strings.ToLower(tag) {
+		return ""
+	}
+	return tags[0]
+}
+
+// tagPost brings the tags of a post in line with caption. Failures are
+// logged, the caption stays as it is.
+func (s *Server) tagPost(post *PostDDB, caption string) {
+	tags := captionTags(caption)
+	var old []string
+	if post.TG != nil {
+		old = post.TG.SS
+	}
+	if removed := tagsNotIn(old, tags); len(removed) > 0 {
+		if err := s.store.UntagPost(post.I.S, post.K.B, removed); err != nil {
+			glog.Errorf("untag %v: %v", removed, err)
+			return
+		}
+	}
+	if added := tagsNotIn(tags, old); len(added) > 0 {
+		if err := s.store.TagPost(post.I.S, post.K.B, added, post.S.N); err != nil {
+			glog.Errorf("tag %v: %v", added, err)
+			return
+		}
+	}
+	post.TG = nil
+	if len(tags) > 0 {
+		post.TG = &struct{ SS []string }{SS: tags}
+	}
+}
+
+// tagsNotIn returns the tags of a that are not in b.
+func tagsNotIn(a, b []string) []string {
+	var rest []string
+	for _, t := range a {
+		found := false
+		for _, u := range b {
+			found = found || t == u
+		}
+		if !found {
+			rest = append(rest, t)
+		}
+	}
+	return rest
+}
+
+// updateTagScores copies the score of a post to its tags after it changed.
+func (s *Server) updateTagScores(post *PostDDB) {
+	if post.TG == nil {
+		return
+	}
+	if err := s.store.SetTagScore(postPK(post.I.S, post.K.B), post.TG.SS, post.S.N); err != nil {
+		glog.Errorf("%v", err)
+	}
+}
+
+// Tag returns the posts with a hashtag in their caption, of every type,
+// highest score first. tag can be passed with or without #. It pages like
+// Hot.
+//   curl 'http://localhost:8080/Tag?device_id=ddd&tag=party'
+func (s *Server) Tag(w http.ResponseWriter, r *http.Request) *appError {
+	tag := normalizeTag(r.FormValue("tag"))
+	if tag == "" {
+		return &appError{Message: "bad tag", Code: http.StatusBadRequest}
+	}
+	feed := "Tag/" + tag
+	q, appErr := s.feedQuery(r, feed, "")
+	if appErr != nil {
+		return appErr
+	}
+	deviceID, appErr := s.formDeviceID(r)
+	if appErr != nil {
+		return appErr
+	}
+
+	page, err := s.store.TagPosts(tag, q)
+	if err != nil {
+		glog.Errorf("%v", err)
+		return &appError{Message: err.Error(), Code: http.StatusInternalServerError}
+	}
+	resp, appErr := s.feedJSON(feed, q, page, []byte(deviceID))
+	if appErr != nil {
+		return appErr
+	}
+	json.NewEncoder(w).Encode(resp)
+	return nil
+}
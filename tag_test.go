@@ -0,0 +1,109 @@
+package burstbooth
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/cardinalblue/burstbooth/util"
+)
+
+func TestCaptionTags(t *testing.T) {
+	for _, c := range []struct {
+		caption, tags string
+	}{
+		{"no tags", "[]"},
+		{"#Party at the #beach, #party!", "[party beach]"},
+		{"email#me ##double # alone #", "[]"},
+		{"#été_2015 #x1", "[été_2015 x1]"},
+		{"#abcdefghijklmnopqrstuvwxyz0123456 #ok", "[ok]"},
+		{"#a #b #c #d #e #f #g #h #i #j #k", "[a b c d e f g h i j]"},
+	} {
+		if got := fmt.Sprint(captionTags(c.caption)); got != c.tags {
+			t.Errorf("%q: %s, want %s", c.caption, got, c.tags)
+		}
+	}
+	for tag, want := range map[string]string{"Party": "party", "#beach": "beach", "a b": "", "": "", "#a#b": ""} {
+		if got := normalizeTag(tag); got != want {
+			t.Errorf("%q: %q, want %q", tag, got, want)
+		}
+	}
+}
+
+func TestTagFeed(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *Server, ts *httptest.Server) {
+		s.admins = []adminToken{{name: "alice", token: "a"}}
+		header := http.Header{"Authorization": {"Bearer a"}}
+
+		var keys []string
+		for i, caption := range []string{"#Party one", "#party #beach", "#beach", "#party three"} {
+			v := url.Values{"url": {"http://127.0.0.1/a.gif"}, "caption": {caption}}
+			p := PostDDB{}
+			util.JSONReq3("POST", ts.URL+"/PostImg?"+v.Encode(), &p)
+			key := base64.StdEncoding.EncodeToString(p.K.B)
+			keys = append(keys, key)
+			for j := 0; j < []int{1, 3, 0, 2}[i]; j++ {
+				v := url.Values{"device_id": {fmt.Sprint(j)}, "key": {key}}
+				util.JSONReq3("POST", ts.URL+"/Vote?"+v.Encode(), nil)
+			}
+		}
+		tagKeys := func(tag string, limit int) []string {
+			var got []string
+			cursor := ""
+			for {
+				feed := FeedJSON{}
+				v := url.Values{"device_id": {"0"}, "tag": {tag}, "limit": {fmt.Sprint(limit)}, "cursor": {cursor}}
+				resp, _, err := util.JSONReq3("GET", ts.URL+"/Tag?"+v.Encode(), &feed)
+				if err != nil || resp.StatusCode != http.StatusOK {
+					t.Fatalf("%v %+v", err, resp)
+				}
+				for _, p := range feed.Posts {
+					got = append(got, fmt.Sprintf("%s:%d", base64.StdEncoding.EncodeToString(p.K.B), p.V))
+				}
+				if cursor = feed.Next; cursor == "" {
+					return got
+				}
+			}
+		}
+		// Device 0 voted on every post but the beach one.
+		check := func(tag string, want ...int) {
+			var keysV []string
+			for _, i := range want {
+				keysV = append(keysV, fmt.Sprintf("%s:%d", keys[i], []int{1, 1, 0, 1}[i]))
+			}
+			if got := tagKeys(tag, 1); fmt.Sprint(got) != fmt.Sprint(keysV) {
+				t.Fatalf("%s: %v, want %v", tag, got, keysV)
+			}
+		}
+		check("#PARTY", 1, 3, 0)
+		check("beach", 1, 2)
+		if resp, _, err := util.JSONReq3("GET", ts.URL+"/Tag?tag=a+b", nil); err != nil || resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%v %+v", err, resp)
+		}
+
+		// Votes move posts up, and hidden posts leave the feed.
+		for _, d := range []string{"5", "6", "7"} {
+			v := url.Values{"device_id": {d}, "key": {keys[0]}}
+			util.JSONReq3("POST", ts.URL+"/Vote?"+v.Encode(), nil)
+		}
+		check("party", 0, 1, 3)
+		admin := func(path string, v url.Values) {
+			resp, _, err := util.JSONReq5("POST", ts.URL+"/admin/"+path+"?"+v.Encode(), nil, header, nil)
+			if err != nil || resp.StatusCode != http.StatusOK {
+				t.Fatalf("%s: %v %+v", path, err, resp)
+			}
+		}
+		admin("Hide", url.Values{"key": {keys[3]}})
+		check("party", 0, 1)
+
+		// Editing a caption retags the post, deleting it untags it.
+		admin("EditCaption", url.Values{"key": {keys[1]}, "caption": {"#beach only"}})
+		check("party", 0)
+		check("beach", 1, 2)
+		admin("DeletePost", url.Values{"key": {keys[0]}})
+		check("party")
+	})
+}
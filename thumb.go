@@ -0,0 +1,176 @@
+package burstbooth
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"net/http"
+	"strconv"
+)
+
+// thumbWidths are the widths of the downscaled renditions of a post's poster
+// frame. Widths that are not smaller than the image are skipped.
+var thumbWidths = []int{160, 320, 640}
+
+const (
+	// posterMaxFrames bounds how many frames of a GIF are considered for its
+	// poster frame.
+	posterMaxFrames = 60
+	// thumbJPEGQuality is the quality of poster frames and renditions.
+	thumbJPEGQuality = 80
+)
+
+// posterFrame returns the frame of an animated GIF that best represents it,
+// taken to be the one with the most contrast among its first frames. This
+// skips the blank or faded frames animations often start with. It allocates
+// several images of the size of the logical screen, which decodeImg bounds
+// before calling it.
+func posterFrame(g *gif.GIF) image.Image {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() {
+		for _, f := range g.Image {
+			bounds = bounds.Union(f.Bounds())
+		}
+	}
+	canvas := image.NewRGBA(bounds)
+	var best *image.RGBA
+	bestScore := -1.0
+	for i, f := range g.Image {
+		if i == posterMaxFrames {
+			break
+		}
+		var previous *image.RGBA
+		if i < len(g.Disposal) && g.Disposal[i] == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+		draw.Draw(canvas, f.Bounds(), f, f.Bounds().Min, draw.Over)
+		if score := lumaVariance(canvas); score > bestScore {
+			best, bestScore = cloneRGBA(canvas), score
+		}
+		if i < len(g.Disposal) {
+			switch g.Disposal[i] {
+			case gif.DisposalBackground:
+				draw.Draw(canvas, f.Bounds(), image.Transparent, image.Point{}, draw.Src)
+			case gif.DisposalPrevious:
+				canvas = previous
+			}
+		}
+	}
+	return best
+}
+
+func cloneRGBA(src *image.RGBA) *image.RGBA {
+	dst := image.NewRGBA(src.Rect)
+	copy(dst.Pix, src.Pix)
+	return dst
+}
+
+// lumaVariance returns the variance of the luma of a sample of the pixels of
+// img.
+func lumaVariance(img *image.RGBA) float64 {
+	b := img.Bounds()
+	step := 1
+	for b.Dx()*b.Dy()/(step*step) > 4096 {
+		step++
+	}
+	var n, sum, sumSq float64
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		for x := b.Min.X; x < b.Max.X; x += step {
+			l := float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+			n++
+			sum += l
+			sumSq += l * l
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	mean := sum / n
+	return sumSq/n - mean*mean
+}
+
+// downscale shrinks src to width, keeping its aspect ratio. Every pixel is
+// the average of the source pixels it covers.
+func downscale(src image.Image, width int) *image.RGBA {
+	sb := src.Bounds()
+	height := (sb.Dy()*width + sb.Dx()/2) / sb.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := sb.Min.Y+y*sb.Dy()/height, sb.Min.Y+(y+1)*sb.Dy()/height
+		for x := 0; x < width; x++ {
+			x0, x1 := sb.Min.X+x*sb.Dx()/width, sb.Min.X+(x+1)*sb.Dx()/width
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a, n = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			dst.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(b / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// encodeThumb encodes a poster frame or rendition as a JPEG on a white
+// background, as JPEG has no transparency.
+func encodeThumb(img image.Image) ([]byte, error) {
+	opaque := image.NewRGBA(img.Bounds())
+	draw.Draw(opaque, opaque.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(opaque, opaque.Bounds(), img, img.Bounds().Min, draw.Over)
+	buf := bytes.NewBuffer(nil)
+	if err := jpeg.Encode(buf, opaque, &jpeg.Options{Quality: thumbJPEGQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// storeThumbs stores the poster frame of a post's image and its downscaled
+// renditions under prefix, and records their URLs on post.
+func (s *Server) storeThumbs(r *http.Request, prefix string, meta imgMeta, post *PostDDB) error {
+	if meta.poster == nil {
+		return nil
+	}
+	put := func(name string, img image.Image) (string, error) {
+		data, err := encodeThumb(img)
+		if err != nil {
+			return "", err
+		}
+		u, err := s.blobs.Put(prefix+name, "image/jpeg", data)
+		if err != nil {
+			return "", err
+		}
+		return blobURL(r, u), nil
+	}
+
+	if meta.Frames > 1 {
+		u, err := put("poster.jpg", meta.poster)
+		if err != nil {
+			return err
+		}
+		post.PF = &struct{ S string }{S: u}
+	} else {
+		// A still is its own poster frame.
+		post.PF = &struct{ S string }{S: post.URL.S}
+	}
+	for _, w := range thumbWidths {
+		if w >= meta.poster.Bounds().Dx() {
+			continue
+		}
+		u, err := put(strconv.Itoa(w)+".jpg", downscale(meta.poster, w))
+		if err != nil {
+			return err
+		}
+		if post.T == nil {
+			post.T = &struct{ M map[string]struct{ S string } }{M: map[string]struct{ S string }{}}
+		}
+		post.T.M[strconv.Itoa(w)] = struct{ S string }{S: u}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package burstbooth
+
+import (
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/cardinalblue/burstbooth/util"
+)
+
+func TestPosterFrame(t *testing.T) {
+	// An animation that fades in from a blank frame.
+	g := &gif.GIF{}
+	for i := 0; i < 3; i++ {
+		f := image.NewPaletted(image.Rect(0, 0, 8, 8), palette.Plan9)
+		if i == 1 {
+			for x := 0; x < 4; x++ {
+				for y := 0; y < 8; y++ {
+					f.Set(x, y, color.White)
+				}
+			}
+		}
+		g.Image = append(g.Image, f)
+		g.Delay = append(g.Delay, 10)
+	}
+	g.Config.Width, g.Config.Height = 8, 8
+	poster := posterFrame(g)
+	if poster.Bounds() != image.Rect(0, 0, 8, 8) {
+		t.Fatalf("%v", poster.Bounds())
+	}
+	if r, _, _, _ := poster.At(0, 0).RGBA(); r != 0xffff {
+		t.Fatalf("wrong poster frame %v", poster.At(0, 0))
+	}
+}
+
+func TestPosterFrameHugeScreen(t *testing.T) {
+	ts := newMemTestServer()
+	defer ts.Close()
+
+	// A few bytes that claim a 40000 by 40000 screen would need a 6GB
+	// canvas.
+	resp, _, err := postImgFile(ts.URL, encodeHeaderGIF(40000, 40000, 1, 1, 1), nil)
+	if err != nil || resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("%v %+v", err, resp)
+	}
+	resp, _, err = postImgFile(ts.URL, encodeHeaderGIF(1, 1, 1, 1, 1), nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("%v %+v", err, resp)
+	}
+}
+
+func TestDownscale(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	for x := 0; x < 8; x++ {
+		for y := 0; y < 4; y++ {
+			if x%2 == 0 {
+				src.Set(x, y, color.White)
+			} else {
+				src.Set(x, y, color.Black)
+			}
+		}
+	}
+	dst := downscale(src, 4)
+	if dst.Bounds() != image.Rect(0, 0, 4, 2) {
+		t.Fatalf("%v", dst.Bounds())
+	}
+	if c := dst.RGBAAt(1, 1); c.R != 0x7f || c.A != 0xff {
+		t.Fatalf("%v", c)
+	}
+}
+
+func TestPostImgThumbs(t *testing.T) {
+	ts := newMemTestServer()
+	defer ts.Close()
+
+	p := PostDDB{}
+	resp, _, err := postImgFile(ts.URL, encodeTestGIF(400, 300, 2, 10), &p)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("%v %+v", err, resp)
+	}
+	if p.PF == nil || !strings.HasSuffix(p.PF.S, "/poster.jpg") || p.T == nil || len(p.T.M) != 2 {
+		t.Fatalf("%+v %+v", p.PF, p.T)
+	}
+	assertJPEGWidth(t, p.PF.S, 400)
+	assertJPEGWidth(t, p.T.M["160"].S, 160)
+	assertJPEGWidth(t, p.T.M["320"].S, 320)
+
+	imgs := struct{ Posts []PostJSON }{}
+	util.JSONReq3("GET", ts.URL+"/New", &imgs)
+	if len(imgs.Posts) != 1 || imgs.Posts[0].PF.S != p.PF.S || imgs.Posts[0].T["320"] != p.T.M["320"].S {
+		t.Fatalf("%+v", imgs.Posts)
+	}
+
+	// Stills are their own poster frame.
+	v := url.Values{"type": {"photo"}, "url": {"http://127.0.0.1/photo.jpg"}}
+	util.JSONReq3("POST", ts.URL+"/PostImg?"+v.Encode(), nil)
+	imgs = struct{ Posts []PostJSON }{}
+	util.JSONReq3("GET", ts.URL+"/New?type=photo", &imgs)
+	if len(imgs.Posts) != 1 || imgs.Posts[0].PF.S != "http://127.0.0.1/photo.jpg" || len(imgs.Posts[0].T) != 0 {
+		t.Fatalf("%+v", imgs.Posts)
+	}
+}
+
+func assertJPEGWidth(t *testing.T, url string, width int) {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer resp.Body.Close()
+	img, err := jpeg.Decode(resp.Body)
+	if err != nil || img.Bounds().Dx() != width {
+		t.Fatalf("%s: %v %v", url, err, img)
+	}
+}